@@ -0,0 +1,687 @@
+package pagemanager
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bokwoon95/pagemanager/erro"
+	"github.com/bokwoon95/pagemanager/hyperforms"
+	hy "github.com/bokwoon95/pagemanager/hypergo"
+	"github.com/bokwoon95/pagemanager/sq"
+	"github.com/bokwoon95/pagemanager/templatedir"
+)
+
+const (
+	// siteArchiveVersion is the version of the archives written by
+	// ExportSite. ImportSite rejects archives of any other version.
+	siteArchiveVersion = 1
+	// siteJSONName is the archive entry holding the siteArchive. Images and
+	// themes are kept as files under imagesArchiveDir and themesArchiveDir.
+	siteJSONName     = "site.json"
+	imagesArchiveDir = "images/"
+	themesArchiveDir = "themes/"
+	// maxSiteArchiveBytes is the largest site archive that can be uploaded.
+	maxSiteArchiveBytes = 500 << 20
+)
+
+// errSiteNotArchivable is returned by ExportSite and ImportSite if the site's
+// content is not in the pm_ tables that they read and write directly.
+var errSiteNotArchivable = errors.New("sites can only be exported and imported with the default PageStore, LocalesStore and ValueStore")
+
+// siteArchive is the site.json of a site archive. Nothing in it is encrypted,
+// so the keys in pm_keys are left out and the target site keeps its own. The
+// users, sessions, drafts and revisions are left out as well.
+type siteArchive struct {
+	Version   int
+	CreatedAt time.Time
+	Pages     []Page
+	Locales   []Locale
+	Values    []archiveValue
+	Rows      []archiveRows
+	Images    []archiveImage
+	// Themes are the paths of the themes under themesArchiveDir.
+	Themes []string
+}
+
+type archiveValue struct {
+	LocaleCode string
+	Namespace  string
+	Name       string
+	Value      string
+}
+
+type archiveRows struct {
+	LocaleCode string
+	Namespace  string
+	Name       string
+	Rows       []map[string]interface{}
+}
+
+type archiveImage struct {
+	Name        string
+	ContentType string
+}
+
+func listValues(dialect string, VALUES pm_VALUES) sq.Query {
+	if dialect == "postgres" {
+		return sq.Postgres.From(VALUES).OrderBy(VALUES.LOCALE_CODE, VALUES.NAMESPACE, VALUES.NAME)
+	}
+	return sq.SQLite.From(VALUES).OrderBy(VALUES.LOCALE_CODE, VALUES.NAMESPACE, VALUES.NAME)
+}
+
+func listRows(dialect string, ROWS pm_ROWS) sq.Query {
+	if dialect == "postgres" {
+		return sq.Postgres.From(ROWS).OrderBy(ROWS.LOCALE_CODE, ROWS.NAMESPACE, ROWS.NAME, ROWS.ROW_NUM)
+	}
+	return sq.SQLite.From(ROWS).OrderBy(ROWS.LOCALE_CODE, ROWS.NAMESPACE, ROWS.NAME, ROWS.ROW_NUM)
+}
+
+// archivable reports whether the site's content is in the default stores.
+func (pm *PageManager) archivable() bool {
+	_, pagesOK := pm.pages.(pagestore)
+	_, localesOK := pm.locales.(localesstore)
+	_, valuesOK := pm.valueStore.(valuestore)
+	return pagesOK && localesOK && valuesOK
+}
+
+// ExportSite writes the site's pages, locales, values, rows, uploaded images
+// and installed themes to w as a zip archive that ImportSite can read. Themes
+// that are broken are left out.
+func (pm *PageManager) ExportSite(w io.Writer) error {
+	if !pm.archivable() {
+		return errSiteNotArchivable
+	}
+	archive := siteArchive{Version: siteArchiveVersion, CreatedAt: time.Now().UTC()}
+	// read everything in one transaction so that the archive is a consistent
+	// snapshot of the site
+	err := sq.WithTx(pm.dataDB, func(tx *sql.Tx) error {
+		PAGES := new_PAGES("p")
+		_, err := sq.Fetch(tx, getPages(pm.dataDialect, PAGES), pagesmapper(&archive.Pages, PAGES))
+		if err != nil {
+			return err
+		}
+		LOCALES := new_LOCALES("l")
+		_, err = sq.Fetch(tx, getLocales(pm.dataDialect, LOCALES), localesmapper(&archive.Locales, LOCALES))
+		if err != nil {
+			return err
+		}
+		VALUES := new_VALUES("v")
+		_, err = sq.Fetch(tx, listValues(pm.dataDialect, VALUES), func(row *sq.Row) error {
+			value := archiveValue{
+				LocaleCode: row.String(VALUES.LOCALE_CODE),
+				Namespace:  row.String(VALUES.NAMESPACE),
+				Name:       row.String(VALUES.NAME),
+				Value:      row.String(VALUES.VALUE),
+			}
+			return row.Accumulate(func() error {
+				archive.Values = append(archive.Values, value)
+				return nil
+			})
+		})
+		if err != nil {
+			return err
+		}
+		ROWS := new_ROWS("r")
+		_, err = sq.Fetch(tx, listRows(pm.dataDialect, ROWS), func(row *sq.Row) error {
+			localeCode := row.String(ROWS.LOCALE_CODE)
+			namespace := row.String(ROWS.NAMESPACE)
+			name := row.String(ROWS.NAME)
+			b := row.Bytes(ROWS.DATA)
+			return row.Accumulate(func() error {
+				m := make(map[string]interface{})
+				if len(b) > 0 {
+					err := json.Unmarshal(b, &m)
+					if err != nil {
+						return err
+					}
+				}
+				// the rows are ordered by their key, so every row either
+				// belongs to the last archiveRows or starts a new one
+				n := len(archive.Rows)
+				if n == 0 || archive.Rows[n-1].LocaleCode != localeCode || archive.Rows[n-1].Namespace != namespace || archive.Rows[n-1].Name != name {
+					archive.Rows = append(archive.Rows, archiveRows{LocaleCode: localeCode, Namespace: namespace, Name: name})
+					n++
+				}
+				archive.Rows[n-1].Rows = append(archive.Rows[n-1].Rows, m)
+				return nil
+			})
+		})
+		return err
+	})
+	if err != nil {
+		return erro.Wrap(err)
+	}
+	images, err := pm.imageStore.ListImages()
+	if err != nil {
+		return err
+	}
+	themes, err := pm.tmpldir.ListThemes()
+	if _, ok := err.(templatedir.ThemeErrors); err != nil && !ok {
+		return err
+	}
+	zw := zip.NewWriter(w)
+	for _, name := range images {
+		contentType, err := pm.exportImage(zw, name)
+		if err != nil {
+			return fmt.Errorf("image %s: %w", name, err)
+		}
+		archive.Images = append(archive.Images, archiveImage{Name: name, ContentType: contentType})
+	}
+	for _, theme := range themes {
+		err = exportTheme(zw, pm.themesFS, theme.Path)
+		if err != nil {
+			return fmt.Errorf("theme %s: %w", theme.Path, err)
+		}
+		archive.Themes = append(archive.Themes, theme.Path)
+	}
+	b, err := json.MarshalIndent(archive, "", "  ")
+	if err != nil {
+		return erro.Wrap(err)
+	}
+	f, err := zw.Create(siteJSONName)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func (pm *PageManager) exportImage(zw *zip.Writer, name string) (contentType string, err error) {
+	rc, contentType, err := pm.imageStore.GetImage(name)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	f, err := zw.Create(imagesArchiveDir + name)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, rc)
+	if err != nil {
+		return "", err
+	}
+	return contentType, nil
+}
+
+func exportTheme(zw *zip.Writer, fsys fs.FS, themePath string) error {
+	return fs.WalkDir(fsys, themePath, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		src, err := fsys.Open(name)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		dst, err := zw.Create(themesArchiveDir + name)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(dst, src)
+		return err
+	})
+}
+
+// ImportConflict decides what ImportSite does with the things in an archive
+// that the site already has.
+type ImportConflict int
+
+const (
+	// ImportSkip keeps what the site already has.
+	ImportSkip ImportConflict = 0
+	// ImportOverwrite replaces what the site already has with the archive's.
+	ImportOverwrite ImportConflict = 1
+)
+
+// ImportReport lists what ImportSite did with everything in the archive, or
+// what it would have done in a dry run. Entries look like "page /about" or
+// "value /about title [fr]".
+type ImportReport struct {
+	Created     []string
+	Overwritten []string
+	Skipped     []string
+}
+
+// add records what happens to item and reports whether it is to be written.
+func (report *ImportReport) add(item string, exists bool, conflict ImportConflict) bool {
+	switch {
+	case !exists:
+		report.Created = append(report.Created, item)
+		return true
+	case conflict == ImportOverwrite:
+		report.Overwritten = append(report.Overwritten, item)
+		return true
+	default:
+		report.Skipped = append(report.Skipped, item)
+		return false
+	}
+}
+
+// contentItem names a value or rows in an ImportReport.
+func contentItem(kind, localeCode, namespace, name string) string {
+	item := kind + " " + namespace + " " + name
+	if localeCode != "" {
+		item += " [" + localeCode + "]"
+	}
+	return item
+}
+
+// ImportSite replays an archive written by ExportSite into the site, which
+// may be on a different database. If dryRun is true nothing is written and
+// the report says what would have been.
+//
+// The pages, locales, values and rows are written in one transaction, with
+// the values and rows recorded as revisions made by the user in ctx. The
+// images and themes are only written once that has been committed, so if one
+// of them fails the import can be run again with ImportSkip to finish it.
+func (pm *PageManager) ImportSite(ctx context.Context, r io.ReaderAt, size int64, conflict ImportConflict, dryRun bool) (ImportReport, error) {
+	var report ImportReport
+	if !pm.archivable() {
+		return report, errSiteNotArchivable
+	}
+	if conflict != ImportSkip && conflict != ImportOverwrite {
+		return report, fmt.Errorf("invalid import conflict %d", conflict)
+	}
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return report, err
+	}
+	archive, err := readSiteArchive(zr)
+	if err != nil {
+		return report, err
+	}
+	// check everything that can be checked up front, so that an archive
+	// that cannot be imported fails before anything is written
+	for _, page := range archive.Pages {
+		err = validatePage(page)
+		if err != nil {
+			return report, err
+		}
+	}
+	var images []archiveImage
+	for _, image := range archive.Images {
+		exists, err := pm.checkArchiveImage(zr, &image)
+		if err != nil {
+			return report, fmt.Errorf("image %s: %w", image.Name, err)
+		}
+		if report.add("image "+image.Name, exists, conflict) {
+			images = append(images, image)
+		}
+	}
+	var themes []string
+	for _, themePath := range archive.Themes {
+		_, err = fs.Stat(zr, themesArchiveDir+themePath+"/theme.config.js")
+		if err != nil {
+			return report, fmt.Errorf("theme %s is not in the archive", themePath)
+		}
+		_, err = fs.Stat(pm.themesFS, themePath)
+		if !report.add("theme "+themePath, err == nil, conflict) {
+			continue
+		}
+		_, err = pm.themeDir(themePath)
+		if err != nil {
+			return report, err
+		}
+		themes = append(themes, themePath)
+	}
+	tx, err := pm.dataDB.BeginTx(ctx, nil)
+	if err != nil {
+		return report, erro.Wrap(err)
+	}
+	defer tx.Rollback()
+	err = pm.importContent(ctx, tx, archive, conflict, &report)
+	if err != nil || dryRun {
+		return report, err
+	}
+	err = tx.Commit()
+	pm.cache.Delete(localesCacheKey)
+	if err != nil {
+		return report, erro.Wrap(err)
+	}
+	for _, image := range images {
+		err = pm.importImage(zr, image)
+		if err != nil {
+			return report, fmt.Errorf("image %s: %w", image.Name, err)
+		}
+	}
+	for _, themePath := range themes {
+		err = pm.importTheme(zr, themePath)
+		if err != nil {
+			return report, fmt.Errorf("theme %s: %w", themePath, err)
+		}
+	}
+	return report, nil
+}
+
+func readSiteArchive(zr *zip.Reader) (siteArchive, error) {
+	var archive siteArchive
+	f, err := zr.Open(siteJSONName)
+	if err != nil {
+		return archive, fmt.Errorf("%s not found", siteJSONName)
+	}
+	defer f.Close()
+	err = json.NewDecoder(f).Decode(&archive)
+	if err != nil {
+		return archive, fmt.Errorf("%s: %w", siteJSONName, err)
+	}
+	if archive.Version != siteArchiveVersion {
+		return archive, fmt.Errorf("unsupported site archive version %d", archive.Version)
+	}
+	return archive, nil
+}
+
+// checkArchiveImage checks that image can be imported from the archive, and
+// reports whether the site already has an image with that name. Like an
+// upload, the image must be an allowed image type whose extension matches its
+// content, and image.ContentType is replaced by the detected content type.
+func (pm *PageManager) checkArchiveImage(zr *zip.Reader, image *archiveImage) (exists bool, err error) {
+	_, err = imageName(imagesURLPrefix + image.Name)
+	if err != nil {
+		return false, err
+	}
+	info, err := fs.Stat(zr, imagesArchiveDir+image.Name)
+	if err != nil {
+		return false, fmt.Errorf("not in the archive")
+	}
+	if info.Size() > maxImageBytes {
+		return false, fmt.Errorf("larger than %d bytes", maxImageBytes)
+	}
+	f, err := zr.Open(imagesArchiveDir + image.Name)
+	if err != nil {
+		return false, err
+	}
+	data, err := io.ReadAll(io.LimitReader(f, maxImageBytes))
+	f.Close()
+	if err != nil {
+		return false, err
+	}
+	contentType := http.DetectContentType(data)
+	if _, ok := allowedImageTypes[contentType]; !ok {
+		return false, fmt.Errorf("%s is not an allowed image type", contentType)
+	}
+	if extType := mime.TypeByExtension(path.Ext(image.Name)); extType != contentType {
+		return false, fmt.Errorf("content type %s does not match its extension", contentType)
+	}
+	image.ContentType = contentType
+	rc, _, err := pm.imageStore.GetImage(image.Name)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	rc.Close()
+	return true, nil
+}
+
+// importContent writes the pages, locales, values and rows of the archive
+// in tx.
+func (pm *PageManager) importContent(ctx context.Context, tx *sql.Tx, archive siteArchive, conflict ImportConflict, report *ImportReport) error {
+	PAGES := new_PAGES("p")
+	for _, page := range archive.Pages {
+		var existing Page
+		rowCount, err := sq.Fetch(tx, getPages(pm.dataDialect, PAGES, PAGES.URL.EqString(page.URL)), pagemapper(&existing, PAGES))
+		if err != nil {
+			return erro.Wrap(err)
+		}
+		if !report.add("page "+page.URL, rowCount > 0, conflict) {
+			continue
+		}
+		_, _, err = sq.Exec(tx, deletePage(pm.dataDialect, PAGES, page.URL), 0)
+		if err != nil {
+			return erro.Wrap(err)
+		}
+		_, _, err = sq.Exec(tx, addPage(pm.dataDialect, PAGES, page), 0)
+		if err != nil {
+			return erro.Wrap(err)
+		}
+	}
+	var locales []Locale
+	LOCALES := new_LOCALES("l")
+	_, err := sq.Fetch(tx, getLocales(pm.dataDialect, LOCALES), localesmapper(&locales, LOCALES))
+	if err != nil {
+		return erro.Wrap(err)
+	}
+	existingLocales := make(map[string]bool)
+	hasDefault := false
+	for _, locale := range locales {
+		existingLocales[locale.LocaleCode] = true
+		hasDefault = hasDefault || locale.IsDefault
+	}
+	for _, locale := range archive.Locales {
+		if !report.add("locale "+locale.LocaleCode, existingLocales[locale.LocaleCode], conflict) {
+			continue
+		}
+		if locale.IsDefault {
+			// a site can only have one default locale, and skipping keeps
+			// the one that the site already has
+			if conflict == ImportSkip && hasDefault {
+				locale.IsDefault = false
+			} else {
+				_, _, err = sq.Exec(tx, clearDefaultLocale(pm.dataDialect, LOCALES), 0)
+				if err != nil {
+					return erro.Wrap(err)
+				}
+			}
+		}
+		_, _, err = sq.Exec(tx, deleteLocale(pm.dataDialect, LOCALES, locale.LocaleCode), 0)
+		if err != nil {
+			return erro.Wrap(err)
+		}
+		_, _, err = sq.Exec(tx, addLocale(pm.dataDialect, LOCALES, locale), 0)
+		if err != nil {
+			return erro.Wrap(err)
+		}
+	}
+	vtx := valuestoretx{tx: tx, dialect: pm.dataDialect}
+	if session := SessionFromContext(ctx); session != nil {
+		vtx.userID = session.UserID
+	}
+	for _, value := range archive.Values {
+		oldValue, err := queryValue(tx, pm.dataDialect, value.LocaleCode, value.Namespace, value.Name)
+		if err != nil {
+			return err
+		}
+		if !report.add(contentItem("value", value.LocaleCode, value.Namespace, value.Name), oldValue.Valid, conflict) {
+			continue
+		}
+		err = vtx.SetValue(value.LocaleCode, value.Namespace, value.Name, value.Value)
+		if err != nil {
+			return err
+		}
+	}
+	for _, rows := range archive.Rows {
+		oldRows, err := queryRows(tx, pm.dataDialect, rows.LocaleCode, rows.Namespace, rows.Name)
+		if err != nil {
+			return err
+		}
+		if !report.add(contentItem("rows", rows.LocaleCode, rows.Namespace, rows.Name), len(oldRows) > 0, conflict) {
+			continue
+		}
+		err = vtx.SetRows(rows.LocaleCode, rows.Namespace, rows.Name, rows.Rows)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (pm *PageManager) importImage(zr *zip.Reader, image archiveImage) error {
+	f, err := zr.Open(imagesArchiveDir + image.Name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return pm.imageStore.PutImage(image.Name, image.ContentType, f)
+}
+
+// importTheme installs a theme from the archive with InstallTheme, which
+// takes an archive of just the theme.
+func (pm *PageManager) importTheme(zr *zip.Reader, themePath string) error {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	prefix := themesArchiveDir + themePath + "/"
+	for _, file := range zr.File {
+		if !strings.HasPrefix(file.Name, prefix) || file.FileInfo().IsDir() {
+			continue
+		}
+		err := copyZipFile(zw, file, strings.TrimPrefix(file.Name, prefix))
+		if err != nil {
+			return err
+		}
+	}
+	err := zw.Close()
+	if err != nil {
+		return err
+	}
+	return pm.InstallTheme(themePath, bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+}
+
+func copyZipFile(zw *zip.Writer, file *zip.File, name string) error {
+	src, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+func (pm *PageManager) superadminExport(w http.ResponseWriter, r *http.Request) {
+	if !isSuperadmin(r) {
+		http.Redirect(w, r, superadminURLPrefix+"login", http.StatusSeeOther)
+		return
+	}
+	// the archive is written to a temporary file first so that if the export
+	// fails, the error page is not sent after half of a zip
+	f, err := os.CreateTemp("", "pm-export-*.zip")
+	if err != nil {
+		pm.errHandler(w, r, err)
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	err = pm.ExportSite(f)
+	if err != nil {
+		pm.errHandler(w, r, err)
+		return
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		pm.errHandler(w, r, err)
+		return
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		pm.errHandler(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="site-`+time.Now().UTC().Format("20060102")+`.zip"`)
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	_, _ = io.Copy(w, f)
+}
+
+func (pm *PageManager) superadminImport(w http.ResponseWriter, r *http.Request) {
+	if !isSuperadmin(r) {
+		http.Redirect(w, r, superadminURLPrefix+"login", http.StatusSeeOther)
+		return
+	}
+	form := hyperforms.New(w, r)
+	var result hy.Element
+	if r.Method == http.MethodPost {
+		r.Body = http.MaxBytesReader(w, r.Body, maxSiteArchiveBytes+1<<20)
+		err := r.ParseMultipartForm(32 << 20)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+		dryRun := r.FormValue("dryRun") != ""
+		report, err := pm.importUploadedSite(r, dryRun)
+		if err != nil {
+			form.AddErrMsgs(err.Error())
+			form.Redirect(w, r, r.URL.Path)
+			return
+		}
+		title := "Imported"
+		if dryRun {
+			title = "Dry run: nothing was imported"
+		}
+		result = hy.Elements{
+			hy.H("h2", nil, hy.Txt(title)),
+			importReportList("Created", report.Created),
+			importReportList("Overwritten", report.Overwritten),
+			importReportList("Skipped", report.Skipped),
+		}
+	}
+	err := renderPage(w, "Import site",
+		hy.H("h1", nil, hy.Txt("Import site")),
+		formErrors(form.ErrMsgs),
+		result,
+		hy.H("form[method=post]", hy.Attr{"enctype": "multipart/form-data"},
+			hy.H("label[for=siteZip]", nil, hy.Txt("Site archive (.zip)")),
+			hy.H("input#siteZip[type=file][name=siteZip][required]", hy.Attr{"accept": ".zip"}),
+			hy.H("label[for=conflict]", nil, hy.Txt("If something already exists")),
+			hy.H("select#conflict[name=conflict]", nil,
+				hy.H("option[value=skip]", nil, hy.Txt("Keep it")),
+				hy.H("option[value=overwrite]", nil, hy.Txt("Overwrite it")),
+			),
+			hy.H("label[for=dryRun]", nil, hy.Txt("Dry run")),
+			hy.H("input#dryRun[type=checkbox][name=dryRun][value=1][checked]", nil),
+			hy.H("button[type=submit]", nil, hy.Txt("Import")),
+		),
+	)
+	if err != nil {
+		pm.errHandler(w, r, err)
+		return
+	}
+}
+
+func (pm *PageManager) importUploadedSite(r *http.Request, dryRun bool) (ImportReport, error) {
+	file, header, err := r.FormFile("siteZip")
+	if err != nil {
+		return ImportReport{}, err
+	}
+	defer file.Close()
+	if header.Size > maxSiteArchiveBytes {
+		return ImportReport{}, fmt.Errorf("site archive is larger than %d bytes", maxSiteArchiveBytes)
+	}
+	conflict := ImportSkip
+	if r.FormValue("conflict") == "overwrite" {
+		conflict = ImportOverwrite
+	}
+	return pm.ImportSite(r.Context(), file, header.Size, conflict, dryRun)
+}
+
+func importReportList(title string, items []string) hy.Element {
+	if len(items) == 0 {
+		return nil
+	}
+	var list hy.Elements
+	for _, item := range items {
+		list.Append("li", nil, hy.Txt(item))
+	}
+	return hy.Elements{hy.H("h3", nil, hy.Txt(title)), hy.H("ul", nil, list)}
+}
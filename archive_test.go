@@ -0,0 +1,212 @@
+package pagemanager
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bokwoon95/pagemanager/testutil"
+)
+
+func Test_SiteArchive(t *testing.T) {
+	runDialects(t, func(t *testing.T, dialect string) {
+		is := testutil.New(t)
+		themesDir := t.TempDir()
+		for name, content := range map[string]string{
+			"blog/theme.config.js": `return { Name: "Blog" }`,
+			"blog/post.config.js":  `return { HTML: ["post.html"] }`,
+			"blog/post.html":       `{{ getValue . "title" }}`,
+		} {
+			is.NoErr(os.MkdirAll(filepath.Join(themesDir, filepath.Dir(name)), 0755))
+			is.NoErr(os.WriteFile(filepath.Join(themesDir, name), []byte(content), 0644))
+		}
+		src, err := New(DataDB(newDialectDB(t, dialect), dialect), ThemesDir(themesDir), Images(LocalImageStore(t.TempDir())))
+		is.NoErr(err)
+		is.NoErr(src.pwbox.SetPassword([]byte("password123")))
+		is.NoErr(src.SavePage(Page{URL: "/blog/post", ThemePath: "blog", TemplateConfigPath: "post.config.js", Status: PageStatusPublished}))
+		is.NoErr(src.SavePage(Page{URL: "/old", RedirectURL: "/blog/post", Status: PageStatusPublished}))
+		is.NoErr(src.SetLocale(Locale{LocaleCode: "en", DisplayName: "English", IsDefault: true}))
+		tx, err := src.valueStore.BeginTx(context.Background())
+		is.NoErr(err)
+		is.NoErr(tx.SetValue("", "/blog/post", "title", "Hello"))
+		is.NoErr(tx.SetRows("", "/blog/post", "links", []map[string]interface{}{{"href": "/a"}, {"href": "/b"}}))
+		is.NoErr(tx.Commit())
+		png := "\x89PNG\r\n\x1a\n"
+		is.NoErr(src.imageStore.PutImage("blog/face.png", "image/png", strings.NewReader(png)))
+
+		buf := &bytes.Buffer{}
+		is.NoErr(src.ExportSite(buf))
+		archive := bytes.NewReader(buf.Bytes())
+		zr, err := zip.NewReader(archive, archive.Size())
+		is.NoErr(err)
+		var names []string
+		for _, file := range zr.File {
+			names = append(names, file.Name)
+		}
+		is.Equal([]string{"images/blog/face.png", "themes/blog/post.config.js", "themes/blog/post.html", "themes/blog/theme.config.js", "site.json"}, names)
+
+		dstThemesDir := t.TempDir()
+		dst, err := New(DataDB(newDialectDB(t, dialect), dialect), ThemesDir(dstThemesDir), Images(LocalImageStore(t.TempDir())))
+		is.NoErr(err)
+		is.NoErr(dst.SetLocale(Locale{LocaleCode: "fr", DisplayName: "Français", IsDefault: true}))
+
+		// a dry run writes nothing
+		report, err := dst.ImportSite(context.Background(), archive, archive.Size(), ImportSkip, true)
+		is.NoErr(err)
+		is.Equal([]string{
+			"image blog/face.png", "theme blog", "page /blog/post", "page /old", "locale en",
+			"value /blog/post title", "rows /blog/post links",
+		}, report.Created)
+		page, err := dst.pages.GetPage("/blog/post")
+		is.NoErr(err)
+		is.True(page == nil)
+		_, err = os.Stat(filepath.Join(dstThemesDir, "blog"))
+		is.True(os.IsNotExist(err))
+
+		report, err = dst.ImportSite(context.Background(), archive, archive.Size(), ImportSkip, false)
+		is.NoErr(err)
+		is.Equal(7, len(report.Created))
+		page, err = dst.pages.GetPage("/blog/post")
+		is.NoErr(err)
+		is.Equal("blog", page.ThemePath)
+		locales, err := dst.locales.GetLocales()
+		is.NoErr(err)
+		// the site keeps its own default locale
+		is.Equal("fr", defaultLocale(locales))
+		rows, err := dst.valueStore.GetRows("", "/blog/post", "links")
+		is.NoErr(err)
+		is.Equal([]map[string]interface{}{{"href": "/a"}, {"href": "/b"}}, rows)
+		rc, contentType, err := dst.imageStore.GetImage("blog/face.png")
+		is.NoErr(err)
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		is.NoErr(err)
+		is.Equal(png, string(b))
+		is.Equal("image/png", contentType)
+		// the keys are not carried over, so links signed by one site are not
+		// valid on the other
+		link, err := src.PreviewLink("/blog/post", time.Hour)
+		is.NoErr(err)
+		token, err := url.QueryUnescape(strings.TrimPrefix(link, "/blog/post?"+previewParam+"="))
+		is.NoErr(err)
+		is.True(src.validPreviewToken(token, "/blog/post"))
+		is.True(!dst.validPreviewToken(token, "/blog/post"))
+
+		tx, err = dst.valueStore.BeginTx(context.Background())
+		is.NoErr(err)
+		is.NoErr(tx.SetValue("", "/blog/post", "title", "Changed"))
+		is.NoErr(tx.Commit())
+		report, err = dst.ImportSite(context.Background(), archive, archive.Size(), ImportSkip, false)
+		is.NoErr(err)
+		is.Equal(0, len(report.Created))
+		is.Equal(7, len(report.Skipped))
+		value, err := dst.valueStore.GetValue("", "/blog/post", "title")
+		is.NoErr(err)
+		is.Equal("Changed", value.Str)
+		report, err = dst.ImportSite(context.Background(), archive, archive.Size(), ImportOverwrite, false)
+		is.NoErr(err)
+		is.Equal(7, len(report.Overwritten))
+		value, err = dst.valueStore.GetValue("", "/blog/post", "title")
+		is.NoErr(err)
+		is.Equal("Hello", value.Str)
+		locales, err = dst.locales.GetLocales()
+		is.NoErr(err)
+		is.Equal("en", defaultLocale(locales))
+
+		// archives of other versions are rejected
+		writeArchive := func(files map[string]string) *bytes.Reader {
+			buf := &bytes.Buffer{}
+			zw := zip.NewWriter(buf)
+			for name, content := range files {
+				w, err := zw.Create(name)
+				is.NoErr(err)
+				_, err = w.Write([]byte(content))
+				is.NoErr(err)
+			}
+			is.NoErr(zw.Close())
+			return bytes.NewReader(buf.Bytes())
+		}
+		archive = writeArchive(map[string]string{siteJSONName: `{"Version": 2}`})
+		_, err = dst.ImportSite(context.Background(), archive, archive.Size(), ImportSkip, true)
+		is.True(err != nil)
+
+		// images are checked like uploads, whatever content type site.json
+		// claims they have
+		for name, content := range map[string]string{
+			"blog/x.html": "<script>alert(1)</script>",
+			"blog/x.png":  "<script>alert(1)</script>",
+			"blog/x.jpg":  png,
+		} {
+			archive = writeArchive(map[string]string{
+				siteJSONName:            `{"Version": 1, "Images": [{"Name": "` + name + `", "ContentType": "image/png"}]}`,
+				imagesArchiveDir + name: content,
+			})
+			_, err = dst.ImportSite(context.Background(), archive, archive.Size(), ImportSkip, false)
+			is.True(err != nil)
+			_, _, err = dst.imageStore.GetImage(name)
+			is.True(errors.Is(err, os.ErrNotExist))
+		}
+	})
+}
+
+type brokenImageStore struct {
+	ImageStore
+}
+
+func (store brokenImageStore) GetImage(name string) (io.ReadCloser, string, error) {
+	if name == "broken.png" {
+		return nil, "", fmt.Errorf("broken")
+	}
+	return store.ImageStore.GetImage(name)
+}
+
+func Test_superadminExport(t *testing.T) {
+	is := testutil.New(t)
+	images := LocalImageStore(t.TempDir())
+	pm, err := New(DataDB(newTestDB(t), "sqlite3"), ThemesDir(t.TempDir()), Images(brokenImageStore{images}))
+	is.NoErr(err)
+	rr := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/pm-superadmin/setup", strings.NewReader(url.Values{
+		"loginID": {"admin"}, "password": {"password123"}, "confirmPassword": {"password123"},
+	}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	pm.ServeHTTP(rr, r)
+	cookies := rr.Result().Cookies()
+	is.True(len(cookies) > 0)
+	export := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/pm-superadmin/export", nil)
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+		pm.ServeHTTP(rr, r)
+		return rr
+	}
+
+	is.NoErr(images.PutImage("a.png", "image/png", strings.NewReader("\x89PNG\r\n\x1a\n")))
+	rr = export()
+	is.Equal(http.StatusOK, rr.Code)
+	is.Equal("application/zip", rr.Header().Get("Content-Type"))
+	is.Equal(strconv.Itoa(rr.Body.Len()), rr.Header().Get("Content-Length"))
+	_, err = zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	is.NoErr(err)
+
+	// an export that fails partway sends only the error
+	is.NoErr(images.PutImage("broken.png", "image/png", strings.NewReader("\x89PNG\r\n\x1a\n")))
+	rr = export()
+	is.Equal(http.StatusInternalServerError, rr.Code)
+	is.Equal("", rr.Header().Get("Content-Disposition"))
+	is.True(!bytes.HasPrefix(rr.Body.Bytes(), []byte("PK")))
+}
@@ -0,0 +1,127 @@
+// Package cache defines the Cache interface used throughout pagemanager for
+// data that is expensive to compute but rarely changes, such as locales, and
+// an in-memory LRU. Values that cannot be serialized, such as compiled
+// templates, are only ever kept in an LRU owned by the package that uses
+// them, never in a Cache that may live outside the process.
+package cache
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache is a key-value cache. Keys are namespaced by their users with a
+// prefix (e.g. "templatedir:config:") so that everything under a prefix can
+// be invalidated at once.
+//
+// Implementations must be safe for concurrent use. Only serializable values
+// are stored in a Cache, so that it can live outside the process (such as in
+// Redis); such a cache should treat any error as a miss.
+type Cache interface {
+	// Get returns the value for key, or false if it is not in the cache or
+	// has expired.
+	Get(key string) (value interface{}, ok bool)
+	// Set stores value under key. A ttl of zero or less means the value never
+	// expires, although it may still be evicted.
+	Set(key string, value interface{}, ttl time.Duration)
+	Delete(key string)
+	// DeletePrefix deletes every key that starts with prefix.
+	DeletePrefix(prefix string)
+}
+
+type entry struct {
+	key       string
+	value     interface{}
+	expiresAt time.Time
+}
+
+// LRU is an in-memory Cache that evicts the least recently used entry once it
+// holds more than its capacity.
+type LRU struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+	now      func() time.Time
+}
+
+var _ Cache = (*LRU)(nil)
+
+// NewLRU returns an LRU that holds at most capacity entries. A capacity of
+// zero or less means there is no limit.
+func NewLRU(capacity int) *LRU {
+	return &LRU{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+		now:      time.Now,
+	}
+}
+
+func (c *LRU) Get(key string) (value interface{}, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := elem.Value.(*entry)
+	if !e.expiresAt.IsZero() && !c.now().Before(e.expiresAt) {
+		c.remove(elem)
+		return nil, false
+	}
+	c.ll.MoveToFront(elem)
+	return e.value, true
+}
+
+func (c *LRU) Set(key string, value interface{}, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = c.now().Add(ttl)
+	}
+	if elem, ok := c.items[key]; ok {
+		e := elem.Value.(*entry)
+		e.value, e.expiresAt = value, expiresAt
+		c.ll.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&entry{key: key, value: value, expiresAt: expiresAt})
+	if c.capacity > 0 && c.ll.Len() > c.capacity {
+		c.remove(c.ll.Back())
+	}
+}
+
+func (c *LRU) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[key]; ok {
+		c.remove(elem)
+	}
+}
+
+func (c *LRU) DeletePrefix(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, elem := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			c.remove(elem)
+		}
+	}
+}
+
+// Len returns the number of entries in the cache, including expired entries
+// that have not been removed yet.
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+func (c *LRU) remove(elem *list.Element) {
+	c.ll.Remove(elem)
+	delete(c.items, elem.Value.(*entry).key)
+}
@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bokwoon95/pagemanager/testutil"
+)
+
+func TestLRU(t *testing.T) {
+	is := testutil.New(t)
+	now := time.Now()
+	c := NewLRU(2)
+	c.now = func() time.Time { return now }
+
+	c.Set("a", 1, 0)
+	c.Set("b", 2, 0)
+	value, ok := c.Get("a")
+	is.True(ok)
+	is.Equal(1, value)
+	// b is now the least recently used, so it is evicted
+	c.Set("c", 3, 0)
+	_, ok = c.Get("b")
+	is.True(!ok)
+	is.Equal(2, c.Len())
+	c.Set("a", 10, 0)
+	value, _ = c.Get("a")
+	is.Equal(10, value)
+
+	c.Set("d", 4, time.Minute)
+	_, ok = c.Get("d")
+	is.True(ok)
+	now = now.Add(time.Minute)
+	_, ok = c.Get("d")
+	is.True(!ok)
+
+	c = NewLRU(0)
+	for _, key := range []string{"config:a", "config:b", "tmpl:a"} {
+		c.Set(key, key, 0)
+	}
+	c.DeletePrefix("config:")
+	is.Equal(1, c.Len())
+	_, ok = c.Get("tmpl:a")
+	is.True(ok)
+	c.Delete("tmpl:a")
+	is.Equal(0, c.Len())
+}
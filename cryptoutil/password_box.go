@@ -247,7 +247,7 @@ func (box *PasswordBox) getKey() (key []byte, err error) {
 	if len(pwKey) == 0 {
 		return nil, fmt.Errorf("password not entered")
 	}
-	return key, nil
+	return pwKey, nil
 }
 
 func (box *PasswordBox) Encrypt(plaintext []byte) (ciphertext []byte, err error) {
@@ -44,4 +44,16 @@ func TestPasswordBox(t *testing.T) {
 		_, err = box.HashDecode(append(encodedMsg, "tampered"...))
 		is.True(err != nil)
 	})
+
+	t.Run("different password", func(t *testing.T) {
+		is := testutil.New(t)
+		other, err := NewPasswordBox(&pwstore{}, nil)
+		is.NoErr(err)
+		err = other.SetPassword([]byte(password + "h"))
+		is.NoErr(err)
+		ciphertext, err := box.Encrypt([]byte("lorem ipsum dolor sit amet"))
+		is.NoErr(err)
+		_, err = other.Decrypt(ciphertext)
+		is.True(err != nil)
+	})
 }
@@ -0,0 +1,106 @@
+package pagemanager
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/bokwoon95/pagemanager/testutil"
+)
+
+func Test_drafts(t *testing.T) {
+	is := testutil.New(t)
+	themes := fstest.MapFS{
+		"blog/theme.config.js": {Data: []byte(`return { Name: "Blog" }`)},
+		"blog/post.config.js":  {Data: []byte(`return { HTML: ["post.html"] }`)},
+		"blog/post.html":       {Data: []byte(`{{ getValue . "title" }}`)},
+	}
+	pm, err := New(DataDB(newTestDB(t), "sqlite3"), ThemesFS(themes))
+	is.NoErr(err)
+	is.NoErr(pm.pwbox.SetPassword([]byte("password123")))
+	is.NoErr(pm.users.CreateUser(User{UserID: "editor", LoginID: "editor", Status: UserStatusActive}))
+	is.NoErr(pm.SetUserPermissions("editor", RoleEditor, []string{"/blog/"}))
+	rr := httptest.NewRecorder()
+	is.NoErr(pm.startSession(rr, httptest.NewRequest("GET", "/", nil), "editor"))
+	editor := rr.Result().Cookies()[0]
+	get := func(path string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		pm.ServeHTTP(rr, r)
+		return rr
+	}
+	is.NoErr(pm.SavePage(Page{URL: "/blog/post", ThemePath: "blog", TemplateConfigPath: "post.config.js", Status: PageStatusPublished}))
+	is.NoErr(pm.SavePage(Page{URL: "/blog/draft", ThemePath: "blog", TemplateConfigPath: "post.config.js"}))
+
+	ctx := context.WithValue(context.Background(), ctxKeySession, &Session{UserID: "editor"})
+	tx, err := pm.valueStore.BeginTx(ctx)
+	is.NoErr(err)
+	is.NoErr(tx.SetValue("", "/blog/post", "title", "Published"))
+	is.NoErr(tx.SetDraftValue("", "/blog/post", "title", "Draft"))
+	is.NoErr(tx.SetDraftValue("", "/blog/draft", "title", "Unpublished draft"))
+	is.NoErr(tx.Commit())
+
+	// drafts are only shown to users who can edit the page
+	rr = get("/blog/post")
+	is.Equal("Published", rr.Body.String())
+	is.Equal("", rr.Header().Get("Cache-Control"))
+	rr = get("/blog/post", editor)
+	is.Equal("Draft", rr.Body.String())
+	is.Equal("no-store", rr.Header().Get("Cache-Control"))
+
+	// and to anyone with a preview link, even for an unpublished page
+	link, err := pm.PreviewLink("/blog/draft", time.Hour)
+	is.NoErr(err)
+	is.Equal(http.StatusNotFound, get("/blog/draft").Code)
+	is.Equal("Unpublished draft", get(link).Body.String())
+	expired, err := pm.PreviewLink("/blog/draft", -time.Hour)
+	is.NoErr(err)
+	is.Equal(http.StatusNotFound, get(expired).Code)
+	is.Equal(http.StatusNotFound, get(link+"x").Code)
+	other, err := pm.PreviewLink("/blog/post", time.Hour)
+	is.NoErr(err)
+	is.Equal(http.StatusNotFound, get("/blog/draft"+other[len("/blog/post"):]).Code)
+
+	// the pages dashboard hands out preview links
+	r := httptest.NewRequest("POST", pagesURL, strings.NewReader(url.Values{"action": {"preview"}, "url": {"/blog/draft"}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(editor)
+	rr = httptest.NewRecorder()
+	pm.ServeHTTP(rr, r)
+	is.Equal(http.StatusSeeOther, rr.Code)
+	var flash *http.Cookie
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == flashCookieName {
+			flash = c
+		}
+	}
+	is.True(flash != nil)
+	is.True(strings.Contains(get(pagesURL, editor, flash).Body.String(), "/blog/draft?"+previewParam+"="))
+
+	// publishing promotes the draft to the published value
+	tx, err = pm.valueStore.BeginTx(ctx)
+	is.NoErr(err)
+	is.NoErr(tx.PublishDraftValue("", "/blog/post", "title"))
+	is.NoErr(tx.PublishDraftValue("", "/blog/post", "missing"))
+	is.NoErr(tx.Commit())
+	is.Equal("Draft", get("/blog/post").Body.String())
+	value, err := pm.valueStore.GetDraftValue("", "/blog/post", "title")
+	is.NoErr(err)
+	is.True(!value.Valid)
+	value, err = pm.valueStore.GetDraftValue("", "/blog/draft", "title")
+	is.NoErr(err)
+	is.Equal("Unpublished draft", value.Str)
+	revisions, err := pm.ListRevisions("", "/blog/post", "title")
+	is.NoErr(err)
+	is.Equal(2, len(revisions))
+	is.Equal("Draft", revisions[0].NewValue)
+	is.Equal("editor", revisions[0].UserID)
+}
@@ -3,11 +3,11 @@ module github.com/bokwoon95/pagemanager
 go 1.16
 
 require (
-	github.com/davecgh/go-spew v1.1.1
 	github.com/dop251/goja v0.0.0-20210427212725-462d53687b0d
 	github.com/google/go-cmp v0.5.5
 	github.com/google/uuid v1.2.0
 	github.com/lib/pq v1.10.1
 	github.com/mattn/go-sqlite3 v1.14.7
 	golang.org/x/crypto v0.0.0-20210505212654-3497b51f5e64
+	golang.org/x/net v0.0.0-20210226172049-e18ecbb05110
 )
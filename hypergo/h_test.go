@@ -67,3 +67,27 @@ func Test_H(t *testing.T) {
 		assert(t, el, want)
 	})
 }
+
+func Test_SanitizeHTML(t *testing.T) {
+	assert := func(t *testing.T, s string, want string) {
+		is := testutil.New(t, testutil.Parallel, testutil.FailFast)
+		got, err := SanitizeHTML(nil, s)
+		is.NoErr(err)
+		is.Equal(want, got)
+	}
+	t.Run("plain text", func(t *testing.T) {
+		assert(t, `Where I write about stuff & things`, `Where I write about stuff &amp; things`)
+	})
+	t.Run("allowed tags", func(t *testing.T) {
+		assert(t, `Where I write about <em class="a  b">stuff</em><br/>`, `Where I write about <em class="a b">stuff</em><br>`)
+	})
+	t.Run("disallowed tags and attributes", func(t *testing.T) {
+		assert(t,
+			`<b onclick="alert(1)">bold</b><script>alert(1)</script><blink>text</blink><a href="javascript:alert(1)">link</a>`,
+			`<b>bold</b>text<a>link</a>`,
+		)
+	})
+	t.Run("unbalanced tags", func(t *testing.T) {
+		assert(t, `</p><div><b>bold</div><!-- comment --><i>italic`, `<div><b>bold</b></div><i>italic</i>`)
+	})
+}
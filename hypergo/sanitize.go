@@ -1,6 +1,13 @@
 package hy
 
-import "strings"
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
 
 const sanitizerFailsafe = "ZhypergoZ"
 
@@ -217,3 +224,95 @@ func isSafeURL(s string) bool {
 	}
 	return true
 }
+
+// rawTextElements are elements whose contents are not HTML markup. If one of
+// them is disallowed by the sanitizer, its contents are dropped together with
+// it instead of being emitted as text.
+var rawTextElements = map[string]struct{}{
+	"iframe": {}, "noembed": {}, "noframes": {}, "noscript": {}, "plaintext": {}, "script": {}, "style": {},
+	"textarea": {}, "title": {}, "xmp": {},
+}
+
+// SanitizeHTML parses s as an HTML fragment and re-serializes it, keeping
+// only the tags and attributes allowed by the sanitizer. Comments and
+// doctypes are dropped, stray end tags are discarded and unclosed tags are
+// closed at the end of the fragment.
+func SanitizeHTML(sanitizer Sanitizer, s string) (string, error) {
+	if sanitizer == nil {
+		sanitizer = DefaultSanitizer
+	}
+	buf := bufpool.Get().(*bytes.Buffer)
+	defer func() {
+		buf.Reset()
+		bufpool.Put(buf)
+	}()
+	var openTags []string
+	var skipTag string
+	tokenizer := html.NewTokenizer(strings.NewReader(s))
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			err := tokenizer.Err()
+			if err != io.EOF {
+				return "", fmt.Errorf("hypergo: SanitizeHTML failed: %w", err)
+			}
+			break
+		}
+		token := tokenizer.Token()
+		if skipTag != "" {
+			if tokenType == html.EndTagToken && token.Data == skipTag {
+				skipTag = ""
+			}
+			continue
+		}
+		switch tokenType {
+		case html.TextToken:
+			escapeHTML(buf, htmlReplacementTable, token.Data)
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if !sanitizer(token.Data, "", "") {
+				if _, ok := rawTextElements[token.Data]; ok && tokenType == html.StartTagToken {
+					skipTag = token.Data
+				}
+				continue
+			}
+			attrs := Attributes{Tag: token.Data, Dict: make(map[string]string)}
+			for _, attr := range token.Attr {
+				switch {
+				case attr.Namespace != "":
+					continue
+				case attr.Key == "id":
+					attrs.ID = attr.Val
+				case attr.Key == "class":
+					attrs.Classes = strings.Fields(attr.Val)
+				case attr.Val == "":
+					attrs.Dict[attr.Key] = Enabled
+				default:
+					attrs.Dict[attr.Key] = attr.Val
+				}
+			}
+			buf.WriteString(`<` + attrs.Tag)
+			WriteAttributes(buf, attrs, sanitizer)
+			buf.WriteString(`>`)
+			if _, ok := singletonElements[token.Data]; ok || tokenType == html.SelfClosingTagToken {
+				continue
+			}
+			openTags = append(openTags, token.Data)
+		case html.EndTagToken:
+			i := len(openTags) - 1
+			for i >= 0 && openTags[i] != token.Data {
+				i--
+			}
+			if i < 0 {
+				continue
+			}
+			for j := len(openTags) - 1; j >= i; j-- {
+				buf.WriteString(`</` + openTags[j] + `>`)
+			}
+			openTags = openTags[:i]
+		}
+	}
+	for i := len(openTags) - 1; i >= 0; i-- {
+		buf.WriteString(`</` + openTags[i] + `>`)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,129 @@
+package pagemanager
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bokwoon95/pagemanager/erro"
+)
+
+// ImageStore stores the images uploaded through editmode.js. Names are
+// slash-separated paths relative to the /pm-images/ URL prefix, e.g.
+// "plainsimple/face.jpg".
+type ImageStore interface {
+	PutImage(name string, contentType string, r io.Reader) error
+	// GetImage returns an error satisfying errors.Is(err, os.ErrNotExist) if
+	// there is no image with that name. If the returned io.ReadCloser is also
+	// an io.ReadSeeker, range requests will be supported.
+	GetImage(name string) (rc io.ReadCloser, contentType string, err error)
+	DeleteImage(name string) error
+	// ListImages returns the name of every image, sorted.
+	ListImages() ([]string, error)
+}
+
+type localimagestore struct {
+	dir string
+}
+
+// LocalImageStore returns an ImageStore that keeps images as files inside dir.
+// The content type of an image is derived from its file extension.
+func LocalImageStore(dir string) ImageStore {
+	return localimagestore{dir: dir}
+}
+
+func (store localimagestore) filename(name string) (string, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return "", fmt.Errorf("invalid image name %q", name)
+	}
+	return filepath.Join(store.dir, filepath.FromSlash(name)), nil
+}
+
+func (store localimagestore) PutImage(name string, contentType string, r io.Reader) error {
+	filename, err := store.filename(name)
+	if err != nil {
+		return erro.Wrap(err)
+	}
+	err = os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return erro.Wrap(err)
+	}
+	// write to a temporary file first so that a failed upload does not
+	// clobber the existing image
+	f, err := os.CreateTemp(filepath.Dir(filename), ".upload-*")
+	if err != nil {
+		return erro.Wrap(err)
+	}
+	defer os.Remove(f.Name())
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return erro.Wrap(err)
+	}
+	err = f.Close()
+	if err != nil {
+		return erro.Wrap(err)
+	}
+	return erro.Wrap(os.Rename(f.Name(), filename))
+}
+
+func (store localimagestore) GetImage(name string) (rc io.ReadCloser, contentType string, err error) {
+	filename, err := store.filename(name)
+	if err != nil {
+		return nil, "", erro.Wrap(err)
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, "", erro.Wrap(err)
+	}
+	return f, mime.TypeByExtension(path.Ext(name)), nil
+}
+
+func (store localimagestore) DeleteImage(name string) error {
+	filename, err := store.filename(name)
+	if err != nil {
+		return erro.Wrap(err)
+	}
+	err = os.Remove(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return erro.Wrap(err)
+	}
+	return nil
+}
+
+func (store localimagestore) ListImages() ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(store.dir, func(filename string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && filename == store.dir {
+				return nil
+			}
+			return err
+		}
+		// skip the temporary files of uploads that are still in progress
+		if strings.HasPrefix(d.Name(), ".") && filename != store.dir {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		name, err := filepath.Rel(store.dir, filename)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(name))
+		return nil
+	})
+	if err != nil {
+		return nil, erro.Wrap(err)
+	}
+	return names, nil
+}
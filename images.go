@@ -0,0 +1,185 @@
+package pagemanager
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	imagesURLPrefix = "/pm-images/"
+	uploadURL       = "/pm-upload"
+	// maxImageBytes is the largest single image that can be uploaded.
+	maxImageBytes = 10 << 20
+	// maxUploadBytes is the largest upload request that will be read.
+	maxUploadBytes = 32 << 20
+)
+
+// allowedImageTypes are the content types that can be uploaded, as detected
+// by http.DetectContentType.
+var allowedImageTypes = map[string]struct{}{
+	"image/gif": {}, "image/jpeg": {}, "image/png": {}, "image/webp": {},
+}
+
+var imageSegmentRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-][a-zA-Z0-9._-]*$`)
+
+// imageName converts an image URL like /pm-images/<namespace>/face.jpg into
+// an ImageStore name like <namespace>/face.jpg.
+func imageName(urlPath string) (string, error) {
+	if !strings.HasPrefix(urlPath, imagesURLPrefix) {
+		return "", fmt.Errorf("%q does not start with %s", urlPath, imagesURLPrefix)
+	}
+	name := strings.TrimPrefix(urlPath, imagesURLPrefix)
+	segments := strings.Split(name, "/")
+	if len(segments) < 2 {
+		return "", fmt.Errorf("%q is not under a namespace", urlPath)
+	}
+	for _, segment := range segments {
+		if !imageSegmentRegexp.MatchString(segment) {
+			return "", fmt.Errorf("%q is not a valid image path", urlPath)
+		}
+	}
+	return name, nil
+}
+
+// imageNamespace returns the namespace that the image called name belongs to
+// for permission checks.
+func imageNamespace(name string) string {
+	return "/" + path.Dir(name)
+}
+
+// upload handles the multipart image uploads made by editmode.js. Each file
+// in images[] is stored under the path at the same index in paths[]. Images
+// are subject to the same permissions as values, with the directory of the
+// image as its namespace in the same URL path form: uploading
+// /pm-images/blog/hello/face.jpg needs permission to edit "/blog/hello".
+func (pm *PageManager) upload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+	err := r.ParseMultipartForm(maxImageBytes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+	paths := r.MultipartForm.Value["paths[]"]
+	images := r.MultipartForm.File["images[]"]
+	if len(paths) != len(images) {
+		http.Error(w, fmt.Sprintf("got %d paths but %d images", len(paths), len(images)), http.StatusBadRequest)
+		return
+	}
+	type image struct {
+		name        string
+		contentType string
+		data        []byte
+	}
+	var uploads []image
+	for i, fileheader := range images {
+		name, err := imageName(paths[i])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !pm.canEdit(r, imageNamespace(name)) {
+			http.Error(w, fmt.Sprintf("not allowed to upload %s", paths[i]), http.StatusForbidden)
+			return
+		}
+		if fileheader.Size > maxImageBytes {
+			http.Error(w, fmt.Sprintf("%s exceeds the %dMB limit", paths[i], maxImageBytes>>20), http.StatusRequestEntityTooLarge)
+			return
+		}
+		f, err := fileheader.Open()
+		if err != nil {
+			pm.errHandler(w, r, err)
+			return
+		}
+		data, err := io.ReadAll(io.LimitReader(f, maxImageBytes))
+		f.Close()
+		if err != nil {
+			pm.errHandler(w, r, err)
+			return
+		}
+		contentType := http.DetectContentType(data)
+		if _, ok := allowedImageTypes[contentType]; !ok {
+			http.Error(w, fmt.Sprintf("%s: %s is not an allowed image type", paths[i], contentType), http.StatusUnsupportedMediaType)
+			return
+		}
+		if extType := mime.TypeByExtension(path.Ext(name)); extType != contentType {
+			http.Error(w, fmt.Sprintf("%s: content type %s does not match its extension", paths[i], contentType), http.StatusBadRequest)
+			return
+		}
+		uploads = append(uploads, image{name: name, contentType: contentType, data: data})
+	}
+	for _, upload := range uploads {
+		err = pm.imageStore.PutImage(upload.name, upload.contentType, bytes.NewReader(upload.data))
+		if err != nil {
+			pm.errHandler(w, r, err)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveImage serves an image from the ImageStore, falling back to the theme's
+// FallbackAssets if it has not been uploaded.
+func (pm *PageManager) serveImage(w http.ResponseWriter, r *http.Request) {
+	name, err := imageName(r.URL.Path)
+	if err != nil {
+		pm.notFound.ServeHTTP(w, r)
+		return
+	}
+	rc, contentType, err := pm.imageStore.GetImage(name)
+	if err == nil {
+		defer rc.Close()
+		if contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		if rs, ok := rc.(io.ReadSeeker); ok {
+			http.ServeContent(w, r, name, time.Time{}, rs)
+			return
+		}
+		io.Copy(w, rc)
+		return
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		pm.errHandler(w, r, err)
+		return
+	}
+	f, err := pm.tmpldir.OpenFallbackAsset(r.URL.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		pm.notFound.ServeHTTP(w, r)
+		return
+	}
+	if err != nil {
+		pm.errHandler(w, r, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		pm.errHandler(w, r, err)
+		return
+	}
+	rs, ok := f.(io.ReadSeeker)
+	if !ok {
+		b, err := io.ReadAll(f)
+		if err != nil {
+			pm.errHandler(w, r, err)
+			return
+		}
+		rs = bytes.NewReader(b)
+	}
+	http.ServeContent(w, r, name, info.ModTime(), rs)
+}
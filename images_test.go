@@ -0,0 +1,79 @@
+package pagemanager
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bokwoon95/pagemanager/testutil"
+)
+
+func Test_upload(t *testing.T) {
+	is := testutil.New(t)
+	pm, err := New(DataDB(newTestDB(t), "sqlite3"), Images(LocalImageStore(t.TempDir())))
+	is.NoErr(err)
+	is.NoErr(pm.pwbox.SetPassword([]byte("password123")))
+	rr := httptest.NewRecorder()
+	is.NoErr(pm.startSession(rr, httptest.NewRequest("GET", "/", nil), superadminUserID))
+	cookie := rr.Result().Cookies()[0]
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	jpegData, pngData := &bytes.Buffer{}, &bytes.Buffer{}
+	is.NoErr(jpeg.Encode(jpegData, img, nil))
+	is.NoErr(png.Encode(pngData, img))
+	upload := func(path string, data []byte, cookies ...*http.Cookie) int {
+		body := &bytes.Buffer{}
+		mw := multipart.NewWriter(body)
+		is.NoErr(mw.WriteField("paths[]", path))
+		fw, err := mw.CreateFormFile("images[]", "image")
+		is.NoErr(err)
+		fw.Write(data)
+		is.NoErr(mw.Close())
+		r := httptest.NewRequest("POST", uploadURL, body)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		rr := httptest.NewRecorder()
+		pm.ServeHTTP(rr, r)
+		return rr.Code
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		pm.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		return rr
+	}
+
+	// theme FallbackAssets are served until an image is uploaded
+	rr = get("/pm-images/plainsimple/face.jpg")
+	is.Equal(http.StatusOK, rr.Code)
+	is.True(!bytes.Equal(jpegData.Bytes(), rr.Body.Bytes()))
+	is.Equal(http.StatusNoContent, upload("/pm-images/plainsimple/face.jpg", jpegData.Bytes(), cookie))
+	rr = get("/pm-images/plainsimple/face.jpg")
+	is.Equal(http.StatusOK, rr.Code)
+	is.Equal("image/jpeg", rr.Header().Get("Content-Type"))
+	is.Equal(jpegData.Bytes(), rr.Body.Bytes())
+	is.Equal(http.StatusNotFound, get("/pm-images/plainsimple/missing.jpg").Code)
+
+	is.Equal(http.StatusBadRequest, upload("/pm-images/plainsimple/face.jpg", pngData.Bytes(), cookie))
+	is.Equal(http.StatusUnsupportedMediaType, upload("/pm-images/plainsimple/face.jpg", []byte("<svg></svg>"), cookie))
+	is.Equal(http.StatusBadRequest, upload("/pm-images/face.png", pngData.Bytes(), cookie))
+	is.Equal(http.StatusBadRequest, upload("/pm-images/plainsimple/../../face.png", pngData.Bytes(), cookie))
+	is.Equal(http.StatusBadRequest, upload("/elsewhere/plainsimple/face.png", pngData.Bytes(), cookie))
+	is.Equal(http.StatusNoContent, upload("/pm-images/plainsimple/face.png", pngData.Bytes(), cookie))
+
+	// uploading needs permission to edit the image's namespace
+	is.Equal(http.StatusForbidden, upload("/pm-images/plainsimple/face.png", pngData.Bytes()))
+	// which for editors is the image's directory as a URL path
+	is.NoErr(pm.users.CreateUser(User{UserID: "editor", LoginID: "editor", Status: UserStatusActive}))
+	is.NoErr(pm.SetUserPermissions("editor", RoleEditor, []string{"/blog"}))
+	rr = httptest.NewRecorder()
+	is.NoErr(pm.startSession(rr, httptest.NewRequest("GET", "/", nil), "editor"))
+	editor := rr.Result().Cookies()[0]
+	is.Equal(http.StatusNoContent, upload("/pm-images/blog/hello/face.png", pngData.Bytes(), editor))
+	is.Equal(http.StatusForbidden, upload("/pm-images/plainsimple/face.png", pngData.Bytes(), editor))
+}
@@ -9,29 +9,45 @@ import (
 )
 
 func getKeyByID(dialect string, KEYS pm_KEYS, ID string) sq.Query {
+	if dialect == "postgres" {
+		return sq.Postgres.From(KEYS).Where(KEYS.KEY_ID.EqString(ID))
+	}
 	return sq.SQLite.From(KEYS).Where(KEYS.KEY_ID.EqString(ID))
 }
 
 func getKeysByStatus(dialect string, KEYS pm_KEYS, status cryptoutil.KeyStatus, limit int) sq.Query {
+	if dialect == "postgres" {
+		return sq.Postgres.From(KEYS).Where(KEYS.STATUS.EqInt(int(status))).Limit(limit)
+	}
 	return sq.SQLite.From(KEYS).Where(KEYS.STATUS.EqInt(int(status))).Limit(int64(limit))
 }
 
 func setKeysByStatus(dialect string, KEYS pm_KEYS, status cryptoutil.KeyStatus, IDs ...string) sq.Query {
+	if dialect == "postgres" {
+		return sq.Postgres.Update(KEYS).Set(KEYS.STATUS.SetInt(int(status))).Where(KEYS.KEY_ID.In(IDs))
+	}
 	return sq.SQLite.Update(KEYS).Set(KEYS.STATUS.SetInt(int(status))).Where(KEYS.KEY_ID.In(IDs))
 }
 
 func addKeys(dialect string, KEYS pm_KEYS, keys []cryptoutil.Key) sq.Query {
-	return sq.SQLite.InsertInto(KEYS).Valuesx(func(col *sq.Column) error {
+	mapper := func(col *sq.Column) error {
 		for _, key := range keys {
 			col.SetString(KEYS.KEY_ID, key.ID)
 			col.SetString(KEYS.KEY_CIPHERTEXT, string(key.Contents))
 			col.SetInt(KEYS.STATUS, int(key.Status))
 		}
 		return nil
-	})
+	}
+	if dialect == "postgres" {
+		return sq.Postgres.InsertInto(KEYS).Valuesx(mapper)
+	}
+	return sq.SQLite.InsertInto(KEYS).Valuesx(mapper)
 }
 
 func deleteKeys(dialect string, KEYS pm_KEYS, IDs ...string) sq.Query {
+	if dialect == "postgres" {
+		return sq.Postgres.DeleteFrom(KEYS).Where(KEYS.KEY_ID.In(IDs))
+	}
 	return sq.SQLite.DeleteFrom(KEYS).Where(KEYS.KEY_ID.In(IDs))
 }
 
@@ -58,16 +74,18 @@ func keysmapper(keys *[]cryptoutil.Key, KEYS pm_KEYS) func(*sq.Row) error {
 type keystore struct {
 	db      *sql.DB
 	dialect string
+	table   func(alias string) pm_KEYS
 }
 
 type keystoretx struct {
 	tx      *sql.Tx
 	dialect string
+	table   func(alias string) pm_KEYS
 }
 
 func (store keystore) GetKeyByID(ID string) (*cryptoutil.Key, error) {
 	var key cryptoutil.Key
-	KEYS := new_KEYS("k")
+	KEYS := store.table("k")
 	rowCount, err := sq.Fetch(store.db, getKeyByID(store.dialect, KEYS, ID), keymapper(&key, KEYS))
 	if err != nil {
 		return nil, erro.Wrap(err)
@@ -80,7 +98,7 @@ func (store keystore) GetKeyByID(ID string) (*cryptoutil.Key, error) {
 
 func (store keystore) GetKeysByStatus(status cryptoutil.KeyStatus, limit int) ([]cryptoutil.Key, error) {
 	var keys []cryptoutil.Key
-	KEYS := new_KEYS("k")
+	KEYS := store.table("k")
 	_, err := sq.Fetch(store.db, getKeysByStatus(store.dialect, KEYS, status, limit), keysmapper(&keys, KEYS))
 	if err != nil {
 		return nil, erro.Wrap(err)
@@ -90,12 +108,12 @@ func (store keystore) GetKeysByStatus(status cryptoutil.KeyStatus, limit int) ([
 
 func (store keystore) BeginTx() (cryptoutil.KeyStoreTx, error) {
 	tx, err := store.db.Begin()
-	return keystoretx{tx: tx, dialect: store.dialect}, err
+	return keystoretx{tx: tx, dialect: store.dialect, table: store.table}, err
 }
 
 func (tx keystoretx) GetKeyByID(ID string) (*cryptoutil.Key, error) {
 	var key cryptoutil.Key
-	KEYS := new_KEYS("k")
+	KEYS := tx.table("k")
 	rowCount, err := sq.Fetch(tx.tx, getKeyByID(tx.dialect, KEYS, ID), keymapper(&key, KEYS))
 	if err != nil {
 		return nil, erro.Wrap(err)
@@ -108,7 +126,7 @@ func (tx keystoretx) GetKeyByID(ID string) (*cryptoutil.Key, error) {
 
 func (tx keystoretx) GetKeysByStatus(status cryptoutil.KeyStatus, limit int) ([]cryptoutil.Key, error) {
 	var keys []cryptoutil.Key
-	KEYS := new_KEYS("k")
+	KEYS := tx.table("k")
 	_, err := sq.Fetch(tx.tx, getKeysByStatus(tx.dialect, KEYS, status, limit), keysmapper(&keys, KEYS))
 	if err != nil {
 		return nil, erro.Wrap(err)
@@ -117,19 +135,19 @@ func (tx keystoretx) GetKeysByStatus(status cryptoutil.KeyStatus, limit int) ([]
 }
 
 func (tx keystoretx) SetStatusForKeys(status cryptoutil.KeyStatus, IDs ...string) error {
-	KEYS := new_KEYS("k")
+	KEYS := tx.table("k")
 	_, _, err := sq.Exec(tx.tx, setKeysByStatus(tx.dialect, KEYS, status, IDs...), 0)
 	return erro.Wrap(err)
 }
 
 func (tx keystoretx) AddKeys(keys []cryptoutil.Key) error {
-	KEYS := new_KEYS("k")
+	KEYS := tx.table("k")
 	_, _, err := sq.Exec(tx.tx, addKeys(tx.dialect, KEYS, keys), 0)
 	return erro.Wrap(err)
 }
 
 func (tx keystoretx) DeleteKeys(IDs ...string) error {
-	KEYS := new_KEYS("k")
+	KEYS := tx.table("k")
 	_, _, err := sq.Exec(tx.tx, deleteKeys(tx.dialect, KEYS, IDs...), 0)
 	return erro.Wrap(err)
 }
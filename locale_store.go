@@ -0,0 +1,130 @@
+package pagemanager
+
+import (
+	"database/sql"
+
+	"github.com/bokwoon95/pagemanager/cache"
+	"github.com/bokwoon95/pagemanager/erro"
+	"github.com/bokwoon95/pagemanager/sq"
+)
+
+// Locale is a locale that the site's content can be served in.
+type Locale struct {
+	LocaleCode  string
+	DisplayName string
+	IsDefault   bool
+}
+
+// LocalesStore stores the enabled locales. Locales are read on every page
+// request but almost never change, so implementations are expected to cache
+// them.
+type LocalesStore interface {
+	// GetLocales returns every enabled locale.
+	GetLocales() ([]Locale, error)
+	// SetLocale adds or updates a locale. If the locale is the default, every
+	// other locale stops being the default.
+	SetLocale(locale Locale) error
+	DeleteLocale(localeCode string) error
+}
+
+func getLocales(dialect string, LOCALES pm_LOCALES) sq.Query {
+	if dialect == "postgres" {
+		return sq.Postgres.From(LOCALES).OrderBy(LOCALES.LOCALE_CODE)
+	}
+	return sq.SQLite.From(LOCALES).OrderBy(LOCALES.LOCALE_CODE)
+}
+
+func addLocale(dialect string, LOCALES pm_LOCALES, locale Locale) sq.Query {
+	mapper := func(col *sq.Column) error {
+		col.SetString(LOCALES.LOCALE_CODE, locale.LocaleCode)
+		col.SetString(LOCALES.DISPLAY_NAME, locale.DisplayName)
+		col.SetBool(LOCALES.IS_DEFAULT, locale.IsDefault)
+		return nil
+	}
+	if dialect == "postgres" {
+		return sq.Postgres.InsertInto(LOCALES).Valuesx(mapper)
+	}
+	return sq.SQLite.InsertInto(LOCALES).Valuesx(mapper)
+}
+
+func clearDefaultLocale(dialect string, LOCALES pm_LOCALES) sq.Query {
+	if dialect == "postgres" {
+		return sq.Postgres.Update(LOCALES).Set(LOCALES.IS_DEFAULT.SetBool(false)).Where(LOCALES.IS_DEFAULT)
+	}
+	return sq.SQLite.Update(LOCALES).Set(LOCALES.IS_DEFAULT.SetBool(false)).Where(LOCALES.IS_DEFAULT)
+}
+
+func deleteLocale(dialect string, LOCALES pm_LOCALES, localeCode string) sq.Query {
+	if dialect == "postgres" {
+		return sq.Postgres.DeleteFrom(LOCALES).Where(LOCALES.LOCALE_CODE.EqString(localeCode))
+	}
+	return sq.SQLite.DeleteFrom(LOCALES).Where(LOCALES.LOCALE_CODE.EqString(localeCode))
+}
+
+func localesmapper(locales *[]Locale, LOCALES pm_LOCALES) func(*sq.Row) error {
+	return func(row *sq.Row) error {
+		locale := Locale{
+			LocaleCode:  row.String(LOCALES.LOCALE_CODE),
+			DisplayName: row.String(LOCALES.DISPLAY_NAME),
+			IsDefault:   row.Bool(LOCALES.IS_DEFAULT),
+		}
+		return row.Accumulate(func() error {
+			*locales = append(*locales, locale)
+			return nil
+		})
+	}
+}
+
+// localesstore is a LocalesStore on the pm_locales table. The locales are
+// kept in a cache.Cache after the first read and dropped from it on every
+// write, so the cache is only correct if nothing else writes to pm_locales.
+type localesstore struct {
+	db      *sql.DB
+	dialect string
+	cache   cache.Cache
+}
+
+const localesCacheKey = "pagemanager:locales"
+
+func (store localesstore) GetLocales() ([]Locale, error) {
+	if cached, ok := store.cache.Get(localesCacheKey); ok {
+		if locales, ok := cached.([]Locale); ok {
+			return locales, nil
+		}
+	}
+	var locales []Locale
+	LOCALES := new_LOCALES("l")
+	_, err := sq.Fetch(store.db, getLocales(store.dialect, LOCALES), localesmapper(&locales, LOCALES))
+	if err != nil {
+		return nil, erro.Wrap(err)
+	}
+	store.cache.Set(localesCacheKey, locales, 0)
+	return locales, nil
+}
+
+func (store localesstore) SetLocale(locale Locale) error {
+	defer store.cache.Delete(localesCacheKey)
+	LOCALES := new_LOCALES("l")
+	err := sq.WithTx(store.db, func(tx *sql.Tx) error {
+		if locale.IsDefault {
+			_, _, err := sq.Exec(tx, clearDefaultLocale(store.dialect, LOCALES), 0)
+			if err != nil {
+				return err
+			}
+		}
+		_, _, err := sq.Exec(tx, deleteLocale(store.dialect, LOCALES, locale.LocaleCode), 0)
+		if err != nil {
+			return err
+		}
+		_, _, err = sq.Exec(tx, addLocale(store.dialect, LOCALES, locale), 0)
+		return err
+	})
+	return erro.Wrap(err)
+}
+
+func (store localesstore) DeleteLocale(localeCode string) error {
+	defer store.cache.Delete(localesCacheKey)
+	LOCALES := new_LOCALES("l")
+	_, _, err := sq.Exec(store.db, deleteLocale(store.dialect, LOCALES, localeCode), 0)
+	return erro.Wrap(err)
+}
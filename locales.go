@@ -0,0 +1,158 @@
+package pagemanager
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// localeCookieName is the cookie that remembers the locale a visitor picked.
+// It is not HttpOnly so that a theme's language switcher can set it.
+const localeCookieName = "pm-locale"
+
+// negotiateLocale picks the locale of a page request. In order of precedence
+// it takes the locale from the first segment of the URL path (e.g. /fr-CA/about),
+// the locale cookie, the Accept-Language header and finally the default
+// locale. If the locale came from the URL path, urlPath is the URL path with
+// the locale segment removed. localeCode is empty if no locales are enabled.
+func negotiateLocale(r *http.Request, locales []Locale) (localeCode, urlPath string) {
+	urlPath = r.URL.Path
+	if len(locales) == 0 {
+		return "", urlPath
+	}
+	segment := strings.TrimPrefix(urlPath, "/")
+	if i := strings.Index(segment, "/"); i >= 0 {
+		segment = segment[:i]
+	}
+	if localeCode = matchLocale(segment, locales); localeCode != "" {
+		urlPath = strings.TrimPrefix(urlPath, "/"+segment)
+		if urlPath == "" {
+			urlPath = "/"
+		}
+		return localeCode, urlPath
+	}
+	if c, err := r.Cookie(localeCookieName); err == nil {
+		if localeCode = matchLocale(c.Value, locales); localeCode != "" {
+			return localeCode, urlPath
+		}
+	}
+	tags := parseAcceptLanguage(r.Header.Get("Accept-Language"))
+	for _, tag := range tags {
+		if localeCode = matchLocale(tag, locales); localeCode != "" {
+			return localeCode, urlPath
+		}
+	}
+	// Accept-Language: fr-CA should still get fr if there is no fr-CA
+	for _, tag := range tags {
+		if localeCode = matchLocale(parentLocale(tag), locales); localeCode != "" {
+			return localeCode, urlPath
+		}
+	}
+	return defaultLocale(locales), urlPath
+}
+
+// matchLocale returns the enabled locale code equal to localeCode, ignoring
+// case, or an empty string if there is none.
+func matchLocale(localeCode string, locales []Locale) string {
+	if localeCode == "" {
+		return ""
+	}
+	for _, locale := range locales {
+		if strings.EqualFold(locale.LocaleCode, localeCode) {
+			return locale.LocaleCode
+		}
+	}
+	return ""
+}
+
+func defaultLocale(locales []Locale) string {
+	for _, locale := range locales {
+		if locale.IsDefault {
+			return locale.LocaleCode
+		}
+	}
+	return ""
+}
+
+// parentLocale strips the last subtag of a locale code, so fr-CA becomes fr.
+// It returns an empty string if there is nothing to strip.
+func parentLocale(localeCode string) string {
+	i := strings.LastIndex(localeCode, "-")
+	if i < 0 {
+		return ""
+	}
+	return localeCode[:i]
+}
+
+// parseAcceptLanguage returns the language tags in an Accept-Language header
+// from most to least preferred, leaving out the wildcard and anything with a
+// quality of 0.
+func parseAcceptLanguage(header string) []string {
+	type weightedTag struct {
+		tag string
+		q   float64
+	}
+	var tags []weightedTag
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		tag, q := part, 1.0
+		if i := strings.Index(part, ";"); i >= 0 {
+			tag = strings.TrimSpace(part[:i])
+			param := strings.TrimSpace(part[i+1:])
+			if strings.HasPrefix(param, "q=") {
+				var err error
+				q, err = strconv.ParseFloat(param[len("q="):], 64)
+				if err != nil {
+					continue
+				}
+			}
+		}
+		if tag == "" || tag == "*" || q <= 0 {
+			continue
+		}
+		tags = append(tags, weightedTag{tag: tag, q: q})
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+	result := make([]string, len(tags))
+	for i, tag := range tags {
+		result[i] = tag.tag
+	}
+	return result
+}
+
+// localeFallbacks returns the locales to try, in order, when content is
+// missing for localeCode: its enabled parent locales, then the default
+// locale, then the empty locale that content saved without any locale lives
+// in.
+func (pm *PageManager) localeFallbacks(localeCode string) []string {
+	var fallbacks []string
+	seen := map[string]bool{localeCode: true}
+	add := func(code string) {
+		if !seen[code] {
+			seen[code] = true
+			fallbacks = append(fallbacks, code)
+		}
+	}
+	locales, err := pm.locales.GetLocales()
+	if err == nil {
+		for parent := parentLocale(localeCode); parent != ""; parent = parentLocale(parent) {
+			if code := matchLocale(parent, locales); code != "" {
+				add(code)
+			}
+		}
+		if code := defaultLocale(locales); code != "" {
+			add(code)
+		}
+	}
+	add("")
+	return fallbacks
+}
+
+func (pm *PageManager) SetLocale(locale Locale) error {
+	return pm.locales.SetLocale(locale)
+}
+
+func (pm *PageManager) DeleteLocale(localeCode string) error {
+	return pm.locales.DeleteLocale(localeCode)
+}
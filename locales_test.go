@@ -0,0 +1,95 @@
+package pagemanager
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bokwoon95/pagemanager/testutil"
+)
+
+func Test_parseAcceptLanguage(t *testing.T) {
+	is := testutil.New(t)
+	is.Equal([]string{"fr-CA", "fr", "en"}, parseAcceptLanguage("fr-CA, en;q=0.5, fr;q=0.9, *;q=0.1, de;q=0"))
+	is.Equal([]string{}, parseAcceptLanguage(""))
+}
+
+func Test_negotiateLocale(t *testing.T) {
+	is := testutil.New(t)
+	locales := []Locale{{LocaleCode: "en", IsDefault: true}, {LocaleCode: "fr"}, {LocaleCode: "fr-CA"}}
+	negotiate := func(path, cookie, acceptLanguage string) (string, string) {
+		r := httptest.NewRequest("GET", path, nil)
+		if cookie != "" {
+			r.AddCookie(&http.Cookie{Name: localeCookieName, Value: cookie})
+		}
+		r.Header.Set("Accept-Language", acceptLanguage)
+		return negotiateLocale(r, locales)
+	}
+	for _, tt := range []struct {
+		path, cookie, acceptLanguage string
+		localeCode, urlPath          string
+	}{
+		{"/fr-ca/about", "en", "en", "fr-CA", "/about"},
+		{"/fr", "", "", "fr", "/"},
+		{"/french/about", "", "", "en", "/french/about"},
+		{"/about", "fr", "en", "fr", "/about"},
+		{"/about", "de", "de, fr-CA;q=0.5", "fr-CA", "/about"},
+		{"/about", "", "fr-BE, de", "fr", "/about"},
+		{"/about", "", "de", "en", "/about"},
+	} {
+		localeCode, urlPath := negotiate(tt.path, tt.cookie, tt.acceptLanguage)
+		is.Equal(tt.localeCode, localeCode)
+		is.Equal(tt.urlPath, urlPath)
+	}
+	localeCode, urlPath := negotiateLocale(httptest.NewRequest("GET", "/fr/about", nil), nil)
+	is.Equal("", localeCode)
+	is.Equal("/fr/about", urlPath)
+}
+
+func Test_locales(t *testing.T) {
+	is := testutil.New(t)
+	pm, err := New(DataDB(newTestDB(t), "sqlite3"))
+	is.NoErr(err)
+	is.NoErr(pm.SetLocale(Locale{LocaleCode: "en", DisplayName: "English", IsDefault: true}))
+	is.NoErr(pm.SetLocale(Locale{LocaleCode: "fr", DisplayName: "Français"}))
+	is.NoErr(pm.SetLocale(Locale{LocaleCode: "fr-CA", DisplayName: "Français (Canada)"}))
+	locales, err := pm.locales.GetLocales()
+	is.NoErr(err)
+	is.Equal(3, len(locales))
+	is.Equal("en", defaultLocale(locales))
+	is.Equal([]string{"fr", "en", ""}, pm.localeFallbacks("fr-CA"))
+	is.Equal([]string{""}, pm.localeFallbacks("en"))
+
+	// writes invalidate the cached locales
+	is.NoErr(pm.SetLocale(Locale{LocaleCode: "fr", DisplayName: "Français", IsDefault: true}))
+	locales, err = pm.locales.GetLocales()
+	is.NoErr(err)
+	is.Equal("fr", defaultLocale(locales))
+	is.NoErr(pm.SetLocale(Locale{LocaleCode: "en", DisplayName: "English", IsDefault: true}))
+
+	is.NoErr(pm.SavePage(Page{URL: "/hello", ThemePath: "plainsimple", TemplateConfigPath: "index.config.js", Status: PageStatusPublished}))
+	tx, err := pm.valueStore.BeginTx(context.Background())
+	is.NoErr(err)
+	is.NoErr(tx.SetRows("en", "/hello", "posts", []map[string]interface{}{{"title": "english post", "date": "", "link": "", "summary": ""}}))
+	is.NoErr(tx.SetRows("fr", "/hello", "posts", []map[string]interface{}{{"title": "article français", "date": "", "link": "", "summary": ""}}))
+	is.NoErr(tx.Commit())
+	get := func(path, acceptLanguage string) string {
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set("Accept-Language", acceptLanguage)
+		rr := httptest.NewRecorder()
+		pm.ServeHTTP(rr, r)
+		is.Equal(http.StatusOK, rr.Code)
+		return rr.Body.String()
+	}
+	is.True(strings.Contains(get("/hello", ""), "english post"))
+	is.True(strings.Contains(get("/fr/hello", ""), "article français"))
+	// fr-CA has no posts of its own, so it falls back to fr
+	is.True(strings.Contains(get("/fr-CA/hello", ""), "article français"))
+	is.True(strings.Contains(get("/hello", "fr-CA"), "article français"))
+	// de is not enabled, so it gets the default locale
+	is.True(strings.Contains(get("/hello", "de"), "english post"))
+	is.NoErr(pm.DeleteLocale("fr"))
+	is.True(strings.Contains(get("/fr-CA/hello", ""), "english post"))
+}
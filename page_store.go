@@ -0,0 +1,167 @@
+package pagemanager
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/bokwoon95/pagemanager/erro"
+	"github.com/bokwoon95/pagemanager/sq"
+)
+
+type PageStatus int
+
+const (
+	// PageStatusDraft pages can only be seen by users who can edit them.
+	PageStatusDraft PageStatus = 0
+	// PageStatusPublished pages can be seen by everyone.
+	PageStatusPublished PageStatus = 1
+	// PageStatusScheduled pages are drafts until their PublishAt time, after
+	// which they are published.
+	PageStatusScheduled PageStatus = 2
+)
+
+func (status PageStatus) String() string {
+	switch status {
+	case PageStatusDraft:
+		return "draft"
+	case PageStatusPublished:
+		return "published"
+	case PageStatusScheduled:
+		return "scheduled"
+	default:
+		return "unknown"
+	}
+}
+
+// Page maps a URL to the theme template that is served for it. If
+// RedirectURL is set, the page redirects there instead and has no template.
+type Page struct {
+	URL                string
+	ThemePath          string
+	TemplateConfigPath string
+	Status             PageStatus
+	PublishAt          time.Time
+	RedirectURL        string
+}
+
+// Published reports whether the page can be seen by everyone at time now.
+func (page Page) Published(now time.Time) bool {
+	switch page.Status {
+	case PageStatusPublished:
+		return true
+	case PageStatusScheduled:
+		return !now.Before(page.PublishAt)
+	default:
+		return false
+	}
+}
+
+type PageStore interface {
+	// GetPage returns nil if there is no page at url.
+	GetPage(url string) (*Page, error)
+	// ListPages returns every page, sorted by URL.
+	ListPages() ([]Page, error)
+	// SavePage creates the page, or replaces the page with the same URL.
+	SavePage(page Page) error
+	DeletePage(url string) error
+}
+
+func getPages(dialect string, PAGES pm_PAGES, predicates ...sq.Predicate) sq.Query {
+	if dialect == "postgres" {
+		return sq.Postgres.From(PAGES).Where(predicates...).OrderBy(PAGES.URL)
+	}
+	return sq.SQLite.From(PAGES).Where(predicates...).OrderBy(PAGES.URL)
+}
+
+func addPage(dialect string, PAGES pm_PAGES, page Page) sq.Query {
+	mapper := func(col *sq.Column) error {
+		col.SetString(PAGES.URL, page.URL)
+		col.SetString(PAGES.THEME_PATH, page.ThemePath)
+		col.SetString(PAGES.TEMPLATE_CONFIG_PATH, page.TemplateConfigPath)
+		col.SetInt(PAGES.STATUS, int(page.Status))
+		col.SetTime(PAGES.PUBLISH_AT, page.PublishAt)
+		col.SetString(PAGES.REDIRECT_URL, page.RedirectURL)
+		return nil
+	}
+	if dialect == "postgres" {
+		return sq.Postgres.InsertInto(PAGES).Valuesx(mapper)
+	}
+	return sq.SQLite.InsertInto(PAGES).Valuesx(mapper)
+}
+
+func deletePage(dialect string, PAGES pm_PAGES, url string) sq.Query {
+	if dialect == "postgres" {
+		return sq.Postgres.DeleteFrom(PAGES).Where(PAGES.URL.EqString(url))
+	}
+	return sq.SQLite.DeleteFrom(PAGES).Where(PAGES.URL.EqString(url))
+}
+
+func pagemapper(page *Page, PAGES pm_PAGES) func(*sq.Row) error {
+	return func(row *sq.Row) error {
+		page.URL = row.String(PAGES.URL)
+		page.ThemePath = row.String(PAGES.THEME_PATH)
+		page.TemplateConfigPath = row.String(PAGES.TEMPLATE_CONFIG_PATH)
+		page.Status = PageStatus(row.Int(PAGES.STATUS))
+		page.PublishAt = row.Time(PAGES.PUBLISH_AT)
+		page.RedirectURL = row.String(PAGES.REDIRECT_URL)
+		return sq.SkipRows
+	}
+}
+
+func pagesmapper(pages *[]Page, PAGES pm_PAGES) func(*sq.Row) error {
+	return func(row *sq.Row) error {
+		var page Page
+		_ = pagemapper(&page, PAGES)(row)
+		return row.Accumulate(func() error {
+			*pages = append(*pages, page)
+			return nil
+		})
+	}
+}
+
+type pagestore struct {
+	db      *sql.DB
+	dialect string
+}
+
+func (store pagestore) GetPage(url string) (*Page, error) {
+	var page Page
+	PAGES := new_PAGES("p")
+	rowCount, err := sq.Fetch(store.db, getPages(store.dialect, PAGES, PAGES.URL.EqString(url)), pagemapper(&page, PAGES))
+	if err != nil {
+		return nil, erro.Wrap(err)
+	}
+	if rowCount == 0 {
+		return nil, nil
+	}
+	return &page, nil
+}
+
+func (store pagestore) ListPages() ([]Page, error) {
+	var pages []Page
+	PAGES := new_PAGES("p")
+	_, err := sq.Fetch(store.db, getPages(store.dialect, PAGES), pagesmapper(&pages, PAGES))
+	if err != nil {
+		return nil, erro.Wrap(err)
+	}
+	return pages, nil
+}
+
+func (store pagestore) SavePage(page Page) error {
+	PAGES := new_PAGES("p")
+	err := sq.WithTx(store.db, func(tx *sql.Tx) error {
+		_, _, err := sq.Exec(tx, deletePage(store.dialect, PAGES, page.URL), 0)
+		if err != nil {
+			return err
+		}
+		_, _, err = sq.Exec(tx, addPage(store.dialect, PAGES, page), 0)
+		return err
+	})
+	return erro.Wrap(err)
+}
+
+func (store pagestore) DeletePage(url string) error {
+	PAGES := new_PAGES("p")
+	_, _, err := sq.Exec(store.db, deletePage(store.dialect, PAGES, url), 0)
+	return erro.Wrap(err)
+}
@@ -2,19 +2,356 @@ package pagemanager
 
 import (
 	"database/sql"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+	"time"
 
+	"github.com/bokwoon95/pagemanager/cache"
 	"github.com/bokwoon95/pagemanager/cryptoutil"
+	"github.com/bokwoon95/pagemanager/sq"
+	"github.com/bokwoon95/pagemanager/templatedir"
 )
 
 type PageManager struct {
 	keybox *cryptoutil.KeyBox
 	pwbox  *cryptoutil.PasswordBox
-	// themesFS
-	// imageGetter/imageSetter
+	// sessionKeybox signs session cookies. Its keys are not encrypted with
+	// the pwbox, so that users stay logged in across restarts.
+	sessionKeybox *cryptoutil.KeyBox
 	// pagemanagerFS
-	// pluginsFS
-	dataDB       *sql.DB
-	superadminDB *sql.DB
+	plugins            []Plugin
+	pluginsByName      map[string]Plugin
+	themesFS           fs.FS
+	themesDir          string
+	tmpldir            *templatedir.TemplateDir
+	valueStore         templatedir.ValueStore
+	revisions          revisionstore
+	imageStore         ImageStore
+	sessions           SessionStore
+	users              UserStore
+	pages              PageStore
+	locales            LocalesStore
+	cache              cache.Cache
+	devMode            bool
+	bufferPages        bool
+	validateThemes     bool
+	sessionIdleTimeout time.Duration
+	sessionMaxAge      time.Duration
+	passwords          passwordstore
+	handler            http.Handler
+	notFound           http.Handler
+	errHandler         func(w http.ResponseWriter, r *http.Request, err error)
+	dataDB             *sql.DB
+	dataDialect        string
+	superadminDB       *sql.DB
+	superadminDialect  string
+}
+
+type Option func(*PageManager)
+
+// DataDB sets the database that holds the site's pages, values and users.
+// The dialect is either "sqlite3" or "postgres".
+func DataDB(db *sql.DB, dialect string) Option {
+	return func(pm *PageManager) {
+		pm.dataDB = db
+		pm.dataDialect = dialect
+	}
+}
+
+// SuperadminDB sets the database that holds the superadmin password and the
+// site keys. It defaults to the DataDB. The dialect is either "sqlite3" or
+// "postgres".
+func SuperadminDB(db *sql.DB, dialect string) Option {
+	return func(pm *PageManager) {
+		pm.superadminDB = db
+		pm.superadminDialect = dialect
+	}
+}
+
+func ThemesFS(fsys fs.FS) Option {
+	return func(pm *PageManager) { pm.themesFS = fsys }
+}
+
+// ThemesDir serves themes from a directory on disk. Unlike ThemesFS, themes
+// can then be installed, upgraded and uninstalled from the superadmin
+// dashboard. It defaults to "pm-themes" if neither is set.
+func ThemesDir(dir string) Option {
+	return func(pm *PageManager) {
+		pm.themesDir = dir
+		pm.themesFS = os.DirFS(dir)
+	}
+}
+
+func ValueStore(store templatedir.ValueStore) Option {
+	return func(pm *PageManager) { pm.valueStore = store }
+}
+
+// Images sets the ImageStore that uploaded images are kept in. It defaults to
+// LocalImageStore("pm-images").
+func Images(store ImageStore) Option {
+	return func(pm *PageManager) { pm.imageStore = store }
+}
+
+// Sessions sets the SessionStore. It defaults to a SessionStore on the
+// pm_sessions table of the dataDB.
+func Sessions(store SessionStore) Option {
+	return func(pm *PageManager) { pm.sessions = store }
+}
+
+// Users sets the UserStore. It defaults to a UserStore on the pm_users table
+// of the dataDB.
+func Users(store UserStore) Option {
+	return func(pm *PageManager) { pm.users = store }
+}
+
+// Pages sets the PageStore. It defaults to a PageStore on the pm_pages table
+// of the dataDB.
+func Pages(store PageStore) Option {
+	return func(pm *PageManager) { pm.pages = store }
+}
+
+// Locales sets the LocalesStore. It defaults to a LocalesStore on the
+// pm_locales table of the dataDB, which keeps the locales in the Cache.
+func Locales(store LocalesStore) Option {
+	return func(pm *PageManager) { pm.locales = store }
+}
+
+// Cache sets the cache that the PageManager keeps locales in. It only ever
+// holds serializable data, so it may live outside the process. Compiled theme
+// templates cannot be serialized and are kept in an in-memory LRU of the
+// TemplateDir instead. It defaults to an in-memory LRU.
+func Cache(c cache.Cache) Option {
+	return func(pm *PageManager) { pm.cache = c }
+}
+
+// BufferPages makes pages render in full before any of it is written, so that
+// a page that fails halfway gets a clean error page instead of half a page,
+// and pages get a Content-Length and an ETag that is checked against
+// If-None-Match. By default pages are streamed, so that the browser can start
+// on a page before it has finished rendering.
+func BufferPages(bufferPages bool) Option {
+	return func(pm *PageManager) { pm.bufferPages = bufferPages }
+}
+
+// DevMode makes the TemplateDir recompile theme templates on every request
+// instead of caching them, for use while developing a theme.
+func DevMode(devMode bool) Option {
+	return func(pm *PageManager) { pm.devMode = devMode }
+}
+
+// ValidateThemes makes New fail if any theme or template in the themes
+// directory is broken, listing every problem found.
+func ValidateThemes(validateThemes bool) Option {
+	return func(pm *PageManager) { pm.validateThemes = validateThemes }
+}
+
+// SessionTimeouts sets how long a session may stay idle before it expires,
+// and how long a session may last regardless of activity. They default to 2
+// hours and 7 days respectively.
+func SessionTimeouts(idleTimeout, maxAge time.Duration) Option {
+	return func(pm *PageManager) {
+		pm.sessionIdleTimeout = idleTimeout
+		pm.sessionMaxAge = maxAge
+	}
+}
+
+func NotFound(notfound http.Handler) Option {
+	return func(pm *PageManager) { pm.notFound = notfound }
+}
+
+func ErrHandler(errhandler func(w http.ResponseWriter, r *http.Request, err error)) Option {
+	return func(pm *PageManager) { pm.errHandler = errhandler }
+}
+
+func New(opts ...Option) (*PageManager, error) {
+	pm := &PageManager{}
+	for _, opt := range opts {
+		opt(pm)
+	}
+	if pm.dataDB == nil {
+		return nil, fmt.Errorf("dataDB cannot be nil")
+	}
+	if pm.superadminDB == nil {
+		pm.superadminDB = pm.dataDB
+		pm.superadminDialect = pm.dataDialect
+	}
+	for _, dialect := range []string{pm.dataDialect, pm.superadminDialect} {
+		if dialect != "sqlite3" && dialect != "postgres" {
+			return nil, fmt.Errorf("unsupported dialect %q: must be sqlite3 or postgres", dialect)
+		}
+	}
+	if pm.themesFS == nil {
+		ThemesDir("pm-themes")(pm)
+	}
+	if pm.notFound == nil {
+		pm.notFound = http.NotFoundHandler()
+	}
+	if pm.errHandler == nil {
+		pm.errHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+	if pm.cache == nil {
+		pm.cache = cache.NewLRU(1000)
+	}
+	if pm.sessionIdleTimeout <= 0 {
+		pm.sessionIdleTimeout = 2 * time.Hour
+	}
+	if pm.sessionMaxAge <= 0 {
+		pm.sessionMaxAge = 7 * 24 * time.Hour
+	}
+	err := sq.EnsureTables(pm.dataDB, pm.dataDialect, new_PAGES(""), new_VALUES(""), new_ROWS(""), new_DRAFTS(""), new_REVISIONS(""), new_SESSIONS(""), new_USERS(""), new_GRANTS(""), new_LOCALES(""))
+	if err != nil {
+		return nil, err
+	}
+	err = sq.EnsureTables(pm.superadminDB, pm.superadminDialect, new_SUPERADMIN(""), new_KEYS(""), new_SESSION_KEYS(""))
+	if err != nil {
+		return nil, err
+	}
+	funcMap, err := pm.registerPlugins()
+	if err != nil {
+		return nil, err
+	}
+	pm.passwords = passwordstore{db: pm.superadminDB, dialect: pm.superadminDialect}
+	keys := keystore{db: pm.superadminDB, dialect: pm.superadminDialect, table: new_KEYS}
+	pm.pwbox, err = cryptoutil.NewPasswordBox(pm.passwords, keys)
+	if err != nil {
+		return nil, err
+	}
+	pm.keybox, err = cryptoutil.NewKeyBox(keys, pm.pwbox)
+	if err != nil {
+		return nil, err
+	}
+	pm.sessionKeybox, err = cryptoutil.NewKeyBox(keystore{db: pm.superadminDB, dialect: pm.superadminDialect, table: new_SESSION_KEYS}, nil)
+	if err != nil {
+		return nil, err
+	}
+	pm.revisions = revisionstore{db: pm.dataDB, dialect: pm.dataDialect}
+	if pm.valueStore == nil {
+		pm.valueStore = valuestore{db: pm.dataDB, dialect: pm.dataDialect}
+	}
+	if pm.sessions == nil {
+		pm.sessions = sessionstore{db: pm.dataDB, dialect: pm.dataDialect}
+	}
+	if pm.users == nil {
+		pm.users = userstore{db: pm.dataDB, dialect: pm.dataDialect}
+	}
+	if pm.pages == nil {
+		pm.pages = pagestore{db: pm.dataDB, dialect: pm.dataDialect}
+	}
+	if pm.locales == nil {
+		pm.locales = localesstore{db: pm.dataDB, dialect: pm.dataDialect, cache: pm.cache}
+	}
+	if pm.imageStore == nil {
+		pm.imageStore = LocalImageStore("pm-images")
+	}
+	pm.tmpldir, err = templatedir.New(pm.themesFS, pm.valueStore,
+		templatedir.AssetURLPrefix("/pm-themes/"),
+		templatedir.AssetNotFound(pm.notFound.ServeHTTP),
+		templatedir.AssetErrHandler(pm.errHandler),
+		templatedir.UploadURL(uploadURL),
+		templatedir.EditPermission(pm.canEdit),
+		templatedir.LocaleFallbacks(pm.localeFallbacks),
+		templatedir.DevMode(pm.devMode),
+		templatedir.ValidateThemes(pm.validateThemes),
+		templatedir.FuncMap(funcMap),
+	)
+	if err != nil {
+		return nil, err
+	}
+	pm.handler = pm.LoadSession(pm.tmpldir.Assets(http.HandlerFunc(pm.route)))
+	return pm, nil
+}
+
+func (pm *PageManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pm.handler.ServeHTTP(w, r)
+}
+
+func (pm *PageManager) route(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == uploadURL:
+		pm.upload(w, r)
+	case strings.HasPrefix(r.URL.Path, imagesURLPrefix):
+		pm.serveImage(w, r)
+	case strings.HasPrefix(r.URL.Path, pluginsURLPrefix):
+		pm.servePlugin(w, r)
+	case strings.HasPrefix(r.URL.Path, superadminURLPrefix):
+		pm.superadmin(w, r)
+	case r.URL.Path == loginURL:
+		pm.userLogin(w, r)
+	case r.URL.Path == logoutURL:
+		pm.userLogout(w, r)
+	case r.URL.Path == inviteURL:
+		pm.acceptInvite(w, r)
+	case r.URL.Path == pagesURL:
+		pm.managePages(w, r)
+	default:
+		pm.servePage(w, r)
+	}
+}
+
+func (pm *PageManager) servePage(w http.ResponseWriter, r *http.Request) {
+	locales, err := pm.locales.GetLocales()
+	if err != nil {
+		pm.errHandler(w, r, err)
+		return
+	}
+	localeCode, urlPath := negotiateLocale(r, locales)
+	if localeCode != "" && urlPath == r.URL.Path {
+		w.Header().Add("Vary", "Cookie, Accept-Language")
+	}
+	if urlPath != r.URL.Path {
+		// the locale prefix is not part of the route, nor of the namespace
+		// that the page's values are stored under
+		u := *r.URL
+		u.Path, u.RawPath = urlPath, ""
+		r2 := *r
+		r2.URL = &u
+		r = &r2
+	}
+	page, err := pm.pages.GetPage(r.URL.Path)
+	if err != nil {
+		pm.errHandler(w, r, err)
+		return
+	}
+	if page == nil {
+		pm.notFound.ServeHTTP(w, r)
+		return
+	}
+	// users who can edit the page and visitors with a preview link see its
+	// drafts, as well as the page itself if it is unpublished
+	preview := pm.canEdit(r, page.URL) || pm.validPreviewToken(r.URL.Query().Get(previewParam), page.URL)
+	published := page.Published(time.Now())
+	if !published && !preview {
+		pm.notFound.ServeHTTP(w, r)
+		return
+	}
+	if preview {
+		// drafts must not be kept by shared caches
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	if page.RedirectURL != "" {
+		code := http.StatusMovedPermanently
+		if !published {
+			code = http.StatusFound
+		}
+		http.Redirect(w, r, page.RedirectURL, code)
+		return
+	}
+	opts := append(pm.pluginServeOptions(),
+		templatedir.LocaleCode(localeCode),
+		templatedir.EditMode(r.URL.Query().Get("editmode") != ""),
+		templatedir.Preview(preview),
+		templatedir.BufferResponse(pm.bufferPages),
+	)
+	err = pm.tmpldir.ServeTemplate(w, r, page.ThemePath, page.TemplateConfigPath, opts...)
+	if err != nil {
+		pm.errHandler(w, r, err)
+		return
+	}
 }
 
 // theme may need caching: you don't want to eval js everytime a user requests for a theme template
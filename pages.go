@@ -0,0 +1,308 @@
+package pagemanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bokwoon95/pagemanager/hyperforms"
+	hy "github.com/bokwoon95/pagemanager/hypergo"
+)
+
+const (
+	pagesURL = "/pm-pages"
+	// reservedURLPrefix is the prefix of every URL that PageManager serves
+	// itself, which pages cannot use.
+	reservedURLPrefix = "/pm-"
+	// publishAtLayout is the layout of <input type=datetime-local> values.
+	publishAtLayout = "2006-01-02T15:04"
+	// previewParam is the query parameter that carries a preview token.
+	previewParam = "preview"
+	// previewLinkTTL is how long the preview links made from the pages
+	// dashboard stay valid.
+	previewLinkTTL = 7 * 24 * time.Hour
+)
+
+// SavePage creates or replaces the page at page.URL. The page must either
+// redirect somewhere or use a template config that exists in its theme.
+func (pm *PageManager) SavePage(page Page) error {
+	err := validatePage(page)
+	if err != nil {
+		return err
+	}
+	if page.RedirectURL != "" {
+		return pm.pages.SavePage(page)
+	}
+	theme, err := pm.tmpldir.LoadTheme(page.ThemePath)
+	if err != nil {
+		return err
+	}
+	for _, templateConfigPath := range theme.Templates {
+		if templateConfigPath == page.TemplateConfigPath {
+			return pm.pages.SavePage(page)
+		}
+	}
+	return fmt.Errorf("theme %s has no template %q", page.ThemePath, page.TemplateConfigPath)
+}
+
+// validatePage checks everything about a page but whether its template
+// exists.
+func validatePage(page Page) error {
+	if !strings.HasPrefix(page.URL, "/") || strings.ContainsAny(page.URL, "?#") {
+		return fmt.Errorf("invalid page URL %q", page.URL)
+	}
+	if strings.HasPrefix(page.URL, reservedURLPrefix) {
+		return fmt.Errorf("page URLs cannot start with %s", reservedURLPrefix)
+	}
+	switch page.Status {
+	case PageStatusDraft, PageStatusPublished:
+	case PageStatusScheduled:
+		if page.PublishAt.IsZero() {
+			return fmt.Errorf("a scheduled page needs a publish time")
+		}
+	default:
+		return fmt.Errorf("invalid page status %d", page.Status)
+	}
+	if page.RedirectURL != "" {
+		if page.ThemePath != "" || page.TemplateConfigPath != "" {
+			return fmt.Errorf("a page cannot have both a template and a redirect")
+		}
+		if page.RedirectURL == page.URL {
+			return fmt.Errorf("page %s redirects to itself", page.URL)
+		}
+	}
+	return nil
+}
+
+// DeletePage deletes the page at url, if there is one.
+func (pm *PageManager) DeletePage(url string) error {
+	return pm.pages.DeletePage(url)
+}
+
+// previewToken is what a preview link is allowed to show, signed with the
+// KeyBox.
+type previewToken struct {
+	URL       string
+	ExpiresAt int64
+}
+
+// PreviewLink returns a link to the page at pageURL that shows the page with
+// its drafts, even if the page itself is unpublished, to anyone who has the
+// link until ttl has passed.
+func (pm *PageManager) PreviewLink(pageURL string, ttl time.Duration) (string, error) {
+	b, err := json.Marshal(previewToken{URL: pageURL, ExpiresAt: time.Now().Add(ttl).Unix()})
+	if err != nil {
+		return "", err
+	}
+	token, err := pm.keybox.HashEncode(b)
+	if err != nil {
+		return "", err
+	}
+	return pageURL + "?" + previewParam + "=" + url.QueryEscape(string(token)), nil
+}
+
+// validPreviewToken reports whether token is an unexpired preview token for
+// the page at pageURL.
+func (pm *PageManager) validPreviewToken(token, pageURL string) bool {
+	if token == "" {
+		return false
+	}
+	b, err := pm.keybox.HashDecode([]byte(token))
+	if err != nil {
+		return false
+	}
+	var preview previewToken
+	err = json.Unmarshal(b, &preview)
+	if err != nil {
+		return false
+	}
+	return preview.URL == pageURL && time.Now().Unix() < preview.ExpiresAt
+}
+
+// managePages lets users create, edit and delete the pages that they are
+// allowed to edit.
+func (pm *PageManager) managePages(w http.ResponseWriter, r *http.Request) {
+	if !isSuperadmin(r) && UserFromContext(r.Context()) == nil {
+		http.Redirect(w, r, loginURL, http.StatusSeeOther)
+		return
+	}
+	form := hyperforms.New(w, r)
+	if r.Method == http.MethodPost {
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		pageURL := r.FormValue("url")
+		if !pm.canEdit(r, pageURL) {
+			form.AddErrMsgs(fmt.Sprintf("you are not allowed to edit %s", pageURL))
+			form.Redirect(w, r, r.URL.Path)
+			return
+		}
+		var link string
+		switch r.FormValue("action") {
+		case "save":
+			err = pm.savePageForm(r)
+		case "delete":
+			err = pm.DeletePage(pageURL)
+		case "preview":
+			link, err = pm.PreviewLink(pageURL, previewLinkTTL)
+		default:
+			http.Error(w, "unknown action", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			form.AddErrMsgs(err.Error())
+			form.Redirect(w, r, r.URL.Path+"?url="+url.QueryEscape(pageURL))
+			return
+		}
+		if link != "" {
+			scheme := "http"
+			if r.TLS != nil {
+				scheme = "https"
+			}
+			err = hyperforms.SetCookieValue(w, flashCookieName, scheme+"://"+r.Host+link, &http.Cookie{HttpOnly: true, SameSite: http.SameSiteLaxMode})
+			if err != nil {
+				pm.errHandler(w, r, err)
+				return
+			}
+		}
+		http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+		return
+	}
+	var link string
+	_ = hyperforms.GetCookieValue(w, r, flashCookieName, &link)
+	pages, err := pm.pages.ListPages()
+	if err != nil {
+		pm.errHandler(w, r, err)
+		return
+	}
+	var rows hy.Elements
+	for _, page := range pages {
+		if !pm.canEdit(r, page.URL) {
+			continue
+		}
+		target := page.ThemePath + "/" + page.TemplateConfigPath
+		if page.RedirectURL != "" {
+			target = "redirects to " + page.RedirectURL
+		}
+		status := page.Status.String()
+		if page.Status == PageStatusScheduled {
+			status += " for " + page.PublishAt.UTC().Format(publishAtLayout) + " UTC"
+		}
+		rows.Append("tr", nil,
+			hy.H("td", nil, hy.H("a", hy.Attr{"href": page.URL}, hy.Txt(page.URL))),
+			hy.H("td", nil, hy.Txt(target)),
+			hy.H("td", nil, hy.Txt(status)),
+			hy.H("td", nil, hy.H("a", hy.Attr{"href": pagesURL + "?url=" + url.QueryEscape(page.URL)}, hy.Txt("Edit"))),
+			hy.H("td", nil, hy.H("form[method=post]", nil,
+				hy.H("input[type=hidden][name=url]", hy.Attr{"value": page.URL}),
+				hy.H("button[type=submit][name=action][value=preview]", nil, hy.Txt("Preview link")),
+				hy.H("button[type=submit][name=action][value=delete]", nil, hy.Txt("Delete")),
+			)),
+		)
+	}
+	page := &Page{}
+	if pageURL := r.URL.Query().Get("url"); pageURL != "" {
+		page, err = pm.pages.GetPage(pageURL)
+		if err != nil {
+			pm.errHandler(w, r, err)
+			return
+		}
+		if page == nil {
+			page = &Page{URL: pageURL}
+		}
+	}
+	// every template of every installed theme can be picked, even if some
+	// themes are broken
+	themes, _ := pm.tmpldir.ListThemes()
+	templates := []hyperforms.Option{{Value: "", Display: "(none, redirect instead)"}}
+	for _, theme := range themes {
+		group := hyperforms.Option{Optgroup: theme.Name}
+		for _, templateConfigPath := range theme.Templates {
+			group.Options = append(group.Options, hyperforms.Option{
+				Value:    theme.Path + "/" + templateConfigPath,
+				Display:  strings.TrimSuffix(templateConfigPath, ".config.js"),
+				Selected: theme.Path == page.ThemePath && templateConfigPath == page.TemplateConfigPath,
+			})
+		}
+		templates = append(templates, group)
+	}
+	template := form.Select("template", templates)
+	template.Set("#template", nil)
+	var statuses []hyperforms.Option
+	for _, status := range []PageStatus{PageStatusDraft, PageStatusPublished, PageStatusScheduled} {
+		statuses = append(statuses, hyperforms.Option{
+			Value:    strconv.Itoa(int(status)),
+			Display:  status.String(),
+			Selected: status == page.Status,
+		})
+	}
+	status := form.Select("status", statuses)
+	status.Set("#status", nil)
+	var publishAt string
+	if !page.PublishAt.IsZero() {
+		publishAt = page.PublishAt.UTC().Format(publishAtLayout)
+	}
+	form.SetAttribute("method", "post")
+	form.AppendElements(
+		formErrors(form.ErrMsgs),
+		hy.H("label[for=url]", nil, hy.Txt("URL")),
+		hy.H("input#url[type=text][name=url][required]", hy.Attr{"value": page.URL, "placeholder": "/about-me"}),
+		hy.H("label[for=template]", nil, hy.Txt("Template")), template,
+		hy.H("label[for=redirectURL]", nil, hy.Txt("Redirect to")),
+		hy.H("input#redirectURL[type=text][name=redirectURL]", hy.Attr{"value": page.RedirectURL}),
+		hy.H("label[for=status]", nil, hy.Txt("Status")), status,
+		hy.H("label[for=publishAt]", nil, hy.Txt("Publish at (UTC, scheduled pages only)")),
+		hy.H("input#publishAt[type=datetime-local][name=publishAt]", hy.Attr{"value": publishAt}),
+		hy.H("button[type=submit][name=action][value=save]", nil, hy.Txt("Save")),
+	)
+	var flash hy.Element
+	if link != "" {
+		flash = hy.H("p.flash", nil,
+			hy.Txt(fmt.Sprintf("Anyone with this link can preview the page for %d days:", int(previewLinkTTL.Hours()/24))),
+			hy.H("a", hy.Attr{"href": link}, hy.Txt(link)),
+		)
+	}
+	err = renderPage(w, "Pages",
+		hy.H("h1", nil, hy.Txt("Pages")),
+		flash,
+		hy.H("table", nil,
+			hy.H("tr", nil, hy.H("th", nil, hy.Txt("URL")), hy.H("th", nil, hy.Txt("Template")), hy.H("th", nil, hy.Txt("Status")), hy.H("th", nil), hy.H("th", nil)),
+			rows,
+		),
+		hy.H("h2", nil, hy.Txt("Create or edit a page")),
+		form,
+	)
+	if err != nil {
+		pm.errHandler(w, r, err)
+		return
+	}
+}
+
+func (pm *PageManager) savePageForm(r *http.Request) error {
+	page := Page{
+		URL:         r.FormValue("url"),
+		RedirectURL: r.FormValue("redirectURL"),
+	}
+	if template := r.FormValue("template"); template != "" {
+		page.ThemePath, page.TemplateConfigPath = path.Dir(template), path.Base(template)
+	}
+	status, err := strconv.Atoi(r.FormValue("status"))
+	if err != nil {
+		return fmt.Errorf("invalid page status %q", r.FormValue("status"))
+	}
+	page.Status = PageStatus(status)
+	if publishAt := r.FormValue("publishAt"); publishAt != "" {
+		page.PublishAt, err = time.Parse(publishAtLayout, publishAt)
+		if err != nil {
+			return fmt.Errorf("invalid publish time %q", publishAt)
+		}
+	}
+	return pm.SavePage(page)
+}
@@ -0,0 +1,135 @@
+package pagemanager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/bokwoon95/pagemanager/testutil"
+)
+
+func Test_Pages(t *testing.T) {
+	is := testutil.New(t)
+	themes := fstest.MapFS{
+		"blog/theme.config.js": {Data: []byte(`return { Name: "Blog" }`)},
+		"blog/index.config.js": {Data: []byte(`return { HTML: ["index.html"] }`)},
+		"blog/post.config.js":  {Data: []byte(`return { HTML: ["post.html"] }`)},
+		"blog/index.html":      {Data: []byte(`index`)},
+		"blog/post.html":       {Data: []byte(`post`)},
+	}
+	pm, err := New(DataDB(newTestDB(t), "sqlite3"), ThemesFS(themes))
+	is.NoErr(err)
+	is.NoErr(pm.pwbox.SetPassword([]byte("password123")))
+	is.NoErr(pm.users.CreateUser(User{UserID: "editor", LoginID: "editor", Status: UserStatusActive}))
+	is.NoErr(pm.SetUserPermissions("editor", RoleEditor, []string{"/blog/"}))
+	rr := httptest.NewRecorder()
+	is.NoErr(pm.startSession(rr, httptest.NewRequest("GET", "/", nil), "editor"))
+	editor := rr.Result().Cookies()[0]
+	get := func(path string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		pm.ServeHTTP(rr, r)
+		return rr
+	}
+
+	for _, page := range []Page{
+		{URL: "about-me", ThemePath: "blog", TemplateConfigPath: "index.config.js"},
+		{URL: "/pm-login", ThemePath: "blog", TemplateConfigPath: "index.config.js"},
+		{URL: "/about-me", ThemePath: "blog", TemplateConfigPath: "missing.config.js"},
+		{URL: "/about-me", ThemePath: "missing", TemplateConfigPath: "index.config.js"},
+		{URL: "/about-me", ThemePath: "blog", TemplateConfigPath: "index.config.js", Status: PageStatusScheduled},
+		{URL: "/about-me", ThemePath: "blog", TemplateConfigPath: "index.config.js", RedirectURL: "/"},
+		{URL: "/about-me", RedirectURL: "/about-me"},
+	} {
+		is.True(pm.SavePage(page) != nil)
+	}
+	is.NoErr(pm.SavePage(Page{URL: "/about-me", ThemePath: "blog", TemplateConfigPath: "index.config.js", Status: PageStatusPublished}))
+	is.NoErr(pm.SavePage(Page{URL: "/about", RedirectURL: "/about-me", Status: PageStatusPublished}))
+	is.NoErr(pm.SavePage(Page{URL: "/blog/draft", ThemePath: "blog", TemplateConfigPath: "post.config.js"}))
+	is.NoErr(pm.SavePage(Page{URL: "/blog/past", ThemePath: "blog", TemplateConfigPath: "post.config.js", Status: PageStatusScheduled, PublishAt: time.Now().Add(-time.Hour)}))
+	is.NoErr(pm.SavePage(Page{URL: "/blog/future", ThemePath: "blog", TemplateConfigPath: "post.config.js", Status: PageStatusScheduled, PublishAt: time.Now().Add(time.Hour)}))
+
+	is.Equal("index", get("/about-me", nil).Body.String())
+	rr = get("/about", nil)
+	is.Equal(http.StatusMovedPermanently, rr.Code)
+	is.Equal("/about-me", rr.Header().Get("Location"))
+	is.Equal("post", get("/blog/past", nil).Body.String())
+	// unpublished pages are only shown to users who can edit them
+	is.Equal(http.StatusNotFound, get("/blog/draft", nil).Code)
+	is.Equal(http.StatusNotFound, get("/blog/future", nil).Code)
+	rr = get("/blog/draft", editor)
+	is.Equal("post", rr.Body.String())
+	is.Equal("no-store", rr.Header().Get("Cache-Control"))
+	is.NoErr(pm.DeletePage("/about-me"))
+	is.Equal(http.StatusNotFound, get("/about-me", nil).Code)
+
+	// editors can only manage the pages within their grants
+	post := func(form url.Values) {
+		r := httptest.NewRequest("POST", pagesURL, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.AddCookie(editor)
+		pm.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	post(url.Values{"action": {"save"}, "url": {"/blog/new"}, "template": {"blog/post.config.js"}, "status": {"1"}})
+	post(url.Values{"action": {"save"}, "url": {"/new"}, "template": {"blog/post.config.js"}, "status": {"1"}})
+	is.Equal("post", get("/blog/new", nil).Body.String())
+	is.Equal(http.StatusNotFound, get("/new", nil).Code)
+	body := get(pagesURL, editor).Body.String()
+	is.True(strings.Contains(body, "/blog/new"))
+	is.True(!strings.Contains(body, `href="/about"`))
+	is.True(strings.Contains(body, `value="blog/post.config.js"`))
+	is.Equal(http.StatusSeeOther, get(pagesURL, nil).Code)
+}
+
+func Test_BufferPages(t *testing.T) {
+	is := testutil.New(t)
+	themes := fstest.MapFS{
+		"blog/theme.config.js":  {Data: []byte(`return { Name: "Blog" }`)},
+		"blog/index.config.js":  {Data: []byte(`return { HTML: ["index.html"] }`)},
+		"blog/broken.config.js": {Data: []byte(`return { HTML: ["broken.html"] }`)},
+		"blog/index.html":       {Data: []byte(`<p>index</p>`)},
+		"blog/broken.html":      {Data: []byte(`<p>partial</p>{{ .Missing }}`)},
+	}
+	db := newTestDB(t)
+	serve := func(pm *PageManager, path string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		for key, values := range header {
+			r.Header[key] = values
+		}
+		rr := httptest.NewRecorder()
+		pm.ServeHTTP(rr, r)
+		return rr
+	}
+
+	// pages are streamed by default
+	pm, err := New(DataDB(db, "sqlite3"), ThemesFS(themes))
+	is.NoErr(err)
+	is.NoErr(pm.SavePage(Page{URL: "/", ThemePath: "blog", TemplateConfigPath: "index.config.js", Status: PageStatusPublished}))
+	is.NoErr(pm.SavePage(Page{URL: "/broken", ThemePath: "blog", TemplateConfigPath: "broken.config.js", Status: PageStatusPublished}))
+	rr := serve(pm, "/", nil)
+	is.Equal("<p>index</p>", rr.Body.String())
+	is.True(rr.Flushed)
+	is.Equal("", rr.Header().Get("ETag"))
+	rr = serve(pm, "/broken", nil)
+	is.True(strings.HasPrefix(rr.Body.String(), "<p>partial</p>"))
+
+	pm, err = New(DataDB(db, "sqlite3"), ThemesFS(themes), BufferPages(true))
+	is.NoErr(err)
+	rr = serve(pm, "/", nil)
+	is.Equal("<p>index</p>", rr.Body.String())
+	is.True(!rr.Flushed)
+	etag := rr.Header().Get("ETag")
+	is.True(etag != "")
+	rr = serve(pm, "/", http.Header{"If-None-Match": {etag}})
+	is.Equal(http.StatusNotModified, rr.Code)
+	rr = serve(pm, "/broken", nil)
+	is.Equal(http.StatusInternalServerError, rr.Code)
+	is.True(!strings.Contains(rr.Body.String(), "partial"))
+}
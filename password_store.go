@@ -0,0 +1,150 @@
+package pagemanager
+
+import (
+	"database/sql"
+
+	"github.com/bokwoon95/pagemanager/cryptoutil"
+	"github.com/bokwoon95/pagemanager/erro"
+	"github.com/bokwoon95/pagemanager/sq"
+)
+
+// There is only ever one superadmin, stored in the row with ORDER_NUM 1.
+const superadminOrderNum = 1
+
+func getSuperadmin(dialect string, SUPERADMIN pm_SUPERADMIN) sq.Query {
+	if dialect == "postgres" {
+		return sq.Postgres.From(SUPERADMIN).Where(SUPERADMIN.ORDER_NUM.EqInt(superadminOrderNum))
+	}
+	return sq.SQLite.From(SUPERADMIN).Where(SUPERADMIN.ORDER_NUM.EqInt(superadminOrderNum))
+}
+
+func addSuperadmin(dialect string, SUPERADMIN pm_SUPERADMIN, metadata cryptoutil.PasswordMetadata) sq.Query {
+	mapper := func(col *sq.Column) error {
+		col.SetInt(SUPERADMIN.ORDER_NUM, superadminOrderNum)
+		col.SetString(SUPERADMIN.PASSWORD_HASH, string(metadata.PasswordHash))
+		col.SetString(SUPERADMIN.KEY_PARAMS, string(metadata.KeyParams))
+		return nil
+	}
+	if dialect == "postgres" {
+		return sq.Postgres.InsertInto(SUPERADMIN).Valuesx(mapper)
+	}
+	return sq.SQLite.InsertInto(SUPERADMIN).Valuesx(mapper)
+}
+
+func setPasswordMetadata(dialect string, SUPERADMIN pm_SUPERADMIN, metadata cryptoutil.PasswordMetadata) sq.Query {
+	assignments := []sq.Assignment{
+		SUPERADMIN.PASSWORD_HASH.SetString(string(metadata.PasswordHash)),
+		SUPERADMIN.KEY_PARAMS.SetString(string(metadata.KeyParams)),
+	}
+	if dialect == "postgres" {
+		return sq.Postgres.Update(SUPERADMIN).Set(assignments...).Where(SUPERADMIN.ORDER_NUM.EqInt(superadminOrderNum))
+	}
+	return sq.SQLite.Update(SUPERADMIN).Set(assignments...).Where(SUPERADMIN.ORDER_NUM.EqInt(superadminOrderNum))
+}
+
+func setLoginID(dialect string, SUPERADMIN pm_SUPERADMIN, loginID string) sq.Query {
+	assignments := []sq.Assignment{
+		SUPERADMIN.LOGIN_ID.SetString(loginID),
+	}
+	if dialect == "postgres" {
+		return sq.Postgres.Update(SUPERADMIN).Set(assignments...).Where(SUPERADMIN.ORDER_NUM.EqInt(superadminOrderNum))
+	}
+	return sq.SQLite.Update(SUPERADMIN).Set(assignments...).Where(SUPERADMIN.ORDER_NUM.EqInt(superadminOrderNum))
+}
+
+func passwordmapper(metadata *cryptoutil.PasswordMetadata, SUPERADMIN pm_SUPERADMIN) func(*sq.Row) error {
+	return func(row *sq.Row) error {
+		metadata.PasswordHash = row.Bytes(SUPERADMIN.PASSWORD_HASH)
+		metadata.KeyParams = row.Bytes(SUPERADMIN.KEY_PARAMS)
+		return sq.SkipRows
+	}
+}
+
+func queryPasswordMetadata(db sq.Queryer, dialect string) (*cryptoutil.PasswordMetadata, error) {
+	var metadata cryptoutil.PasswordMetadata
+	SUPERADMIN := new_SUPERADMIN("s")
+	rowCount, err := sq.Fetch(db, getSuperadmin(dialect, SUPERADMIN), passwordmapper(&metadata, SUPERADMIN))
+	if err != nil {
+		return nil, erro.Wrap(err)
+	}
+	if rowCount == 0 || len(metadata.PasswordHash) == 0 {
+		return nil, nil
+	}
+	return &metadata, nil
+}
+
+// execPasswordMetadata updates the superadmin row if it exists, otherwise it
+// inserts it. It must be called inside a transaction.
+func execPasswordMetadata(tx *sql.Tx, dialect string, metadata cryptoutil.PasswordMetadata) error {
+	SUPERADMIN := new_SUPERADMIN("s")
+	rowsAffected, _, err := sq.Exec(tx, setPasswordMetadata(dialect, SUPERADMIN, metadata), sq.ErowsAffected)
+	if err != nil {
+		return erro.Wrap(err)
+	}
+	if rowsAffected > 0 {
+		return nil
+	}
+	_, _, err = sq.Exec(tx, addSuperadmin(dialect, SUPERADMIN, metadata), 0)
+	return erro.Wrap(err)
+}
+
+type passwordstore struct {
+	db      *sql.DB
+	dialect string
+}
+
+type passwordstoretx struct {
+	tx      *sql.Tx
+	dialect string
+}
+
+func (store passwordstore) GetPasswordMetadata() (*cryptoutil.PasswordMetadata, error) {
+	return queryPasswordMetadata(store.db, store.dialect)
+}
+
+func (store passwordstore) SetPasswordMetadata(metadata cryptoutil.PasswordMetadata) error {
+	err := sq.WithTx(store.db, func(tx *sql.Tx) error {
+		return execPasswordMetadata(tx, store.dialect, metadata)
+	})
+	return erro.Wrap(err)
+}
+
+func (store passwordstore) BeginTx() (cryptoutil.PasswordStoreTx, error) {
+	tx, err := store.db.Begin()
+	return passwordstoretx{tx: tx, dialect: store.dialect}, err
+}
+
+// GetLoginID returns the login ID of the superadmin, or an empty string if
+// it has not been set up.
+func (store passwordstore) GetLoginID() (string, error) {
+	var loginID string
+	SUPERADMIN := new_SUPERADMIN("s")
+	_, err := sq.Fetch(store.db, getSuperadmin(store.dialect, SUPERADMIN), func(row *sq.Row) error {
+		loginID = row.String(SUPERADMIN.LOGIN_ID)
+		return sq.SkipRows
+	})
+	if err != nil {
+		return "", erro.Wrap(err)
+	}
+	return loginID, nil
+}
+
+// SetLoginID sets the login ID of the superadmin. The password must already
+// have been set.
+func (store passwordstore) SetLoginID(loginID string) error {
+	SUPERADMIN := new_SUPERADMIN("s")
+	_, _, err := sq.Exec(store.db, setLoginID(store.dialect, SUPERADMIN, loginID), 0)
+	return erro.Wrap(err)
+}
+
+func (tx passwordstoretx) GetPasswordMetadata() (*cryptoutil.PasswordMetadata, error) {
+	return queryPasswordMetadata(tx.tx, tx.dialect)
+}
+
+func (tx passwordstoretx) SetPasswordMetadata(metadata cryptoutil.PasswordMetadata) error {
+	return execPasswordMetadata(tx.tx, tx.dialect, metadata)
+}
+
+func (tx passwordstoretx) Commit() error { return tx.tx.Commit() }
+
+func (tx passwordstoretx) Rollback() error { return tx.tx.Rollback() }
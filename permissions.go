@@ -0,0 +1,100 @@
+package pagemanager
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Role decides what a user is allowed to do. The superadmin is not a user and
+// is allowed to do everything.
+type Role string
+
+const (
+	// RoleAdmin can edit every namespace.
+	RoleAdmin Role = "admin"
+	// RoleEditor can only edit the namespaces under one of their grants. A
+	// grant of "/blog" or "/blog/" covers "/blog" and "/blog/hello" but not
+	// "/blogroll". Values that a theme shares across pages live in the
+	// namespace of the theme, such as "bokwoon95/plainsimple", which is
+	// covered by a grant of the theme namespace itself or of a directory
+	// above it, such as "bokwoon95". A grant of "/" covers every page but no
+	// theme. Saving a page that shows theme values needs both grants.
+	RoleEditor Role = "editor"
+	// RoleViewer cannot edit anything.
+	RoleViewer Role = "viewer"
+)
+
+func (role Role) valid() bool {
+	return role == RoleAdmin || role == RoleEditor || role == RoleViewer
+}
+
+// SetUserPermissions sets a user's role and, for editors, the namespace
+// prefixes that they are allowed to edit. Grants are discarded for other
+// roles.
+func (pm *PageManager) SetUserPermissions(userID string, role Role, namespacePrefixes []string) error {
+	if !role.valid() {
+		return fmt.Errorf("invalid role %q", role)
+	}
+	user, err := pm.getUser(userID)
+	if err != nil {
+		return err
+	}
+	var grants []string
+	if role == RoleEditor {
+		for _, namespacePrefix := range namespacePrefixes {
+			namespacePrefix = strings.TrimSpace(namespacePrefix)
+			if namespacePrefix == "" {
+				return fmt.Errorf("grants cannot be empty")
+			}
+			grants = append(grants, namespacePrefix)
+		}
+	}
+	user.Role = role
+	err = pm.users.UpdateUser(*user)
+	if err != nil {
+		return err
+	}
+	return pm.users.SetGrants(userID, grants)
+}
+
+// canEdit reports whether the request is allowed to edit the values in
+// namespace. It is the edit permission check passed to the TemplateDir, which
+// uses it for both the save endpoint and for turning on EditMode.
+func (pm *PageManager) canEdit(r *http.Request, namespace string) bool {
+	if isSuperadmin(r) {
+		return true
+	}
+	user := UserFromContext(r.Context())
+	if user == nil {
+		return false
+	}
+	switch user.Role {
+	case RoleAdmin:
+		return true
+	case RoleEditor:
+		grants, err := pm.users.GetGrants(user.UserID)
+		if err != nil {
+			return false
+		}
+		for _, namespacePrefix := range grants {
+			if underNamespace(namespace, namespacePrefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// underNamespace reports whether namespace is namespacePrefix or is below it
+// by whole path segments.
+func underNamespace(namespace, namespacePrefix string) bool {
+	if namespacePrefix == "" {
+		return false
+	}
+	if namespace == namespacePrefix {
+		return true
+	}
+	namespacePrefix = strings.TrimSuffix(namespacePrefix, "/")
+	return (namespacePrefix != "" && namespace == namespacePrefix) || strings.HasPrefix(namespace, namespacePrefix+"/")
+}
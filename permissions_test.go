@@ -0,0 +1,112 @@
+package pagemanager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bokwoon95/pagemanager/testutil"
+)
+
+func Test_permissions(t *testing.T) {
+	is := testutil.New(t)
+	pm, err := New(DataDB(newTestDB(t), "sqlite3"))
+	is.NoErr(err)
+	is.NoErr(pm.pwbox.SetPassword([]byte("password123")))
+	login := func(userID string) *http.Cookie {
+		rr := httptest.NewRecorder()
+		is.NoErr(pm.startSession(rr, httptest.NewRequest("GET", "/", nil), userID))
+		return rr.Result().Cookies()[0]
+	}
+	save := func(namespace string, cookie *http.Cookie) int {
+		body := `{"LocaleCode": "en", "Values": {"` + namespace + `": {"title": "hello"}}}`
+		r := httptest.NewRequest("POST", "/pm-themes/save", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		pm.ServeHTTP(rr, r)
+		return rr.Code
+	}
+	for _, user := range []User{
+		{UserID: "admin", LoginID: "admin", Status: UserStatusActive, Role: RoleAdmin},
+		{UserID: "editor", LoginID: "editor", Status: UserStatusActive, Role: RoleViewer},
+		{UserID: "viewer", LoginID: "viewer", Status: UserStatusActive, Role: RoleViewer},
+	} {
+		is.NoErr(pm.users.CreateUser(user))
+	}
+	is.True(pm.SetUserPermissions("editor", RoleEditor, []string{"/blog/", " "}) != nil)
+	is.NoErr(pm.SetUserPermissions("editor", RoleEditor, []string{"/blog/", " plainsimple ", "/docs"}))
+	grants, err := pm.users.GetGrants("editor")
+	is.NoErr(err)
+	is.Equal([]string{"/blog/", "/docs", "plainsimple"}, grants)
+	is.True(pm.SetUserPermissions("viewer", Role("owner"), nil) != nil)
+
+	is.Equal(http.StatusForbidden, save("/blog/hello", nil))
+	is.Equal(http.StatusNoContent, save("/about", login(superadminUserID)))
+	is.Equal(http.StatusNoContent, save("/about", login("admin")))
+	editor := login("editor")
+	is.Equal(http.StatusNoContent, save("/blog/hello", editor))
+	is.Equal(http.StatusNoContent, save("plainsimple", editor))
+	is.Equal(http.StatusForbidden, save("/about", editor))
+	// grants only cover whole path segments
+	is.Equal(http.StatusNoContent, save("/blog", editor))
+	is.Equal(http.StatusNoContent, save("/docs/intro", editor))
+	is.Equal(http.StatusForbidden, save("/blog-admin", editor))
+	is.Equal(http.StatusForbidden, save("/docsearch", editor))
+	is.Equal(http.StatusForbidden, save("/blog/hello", login("viewer")))
+
+	// demoting an editor drops their grants
+	is.NoErr(pm.SetUserPermissions("editor", RoleViewer, []string{"/blog/"}))
+	grants, err = pm.users.GetGrants("editor")
+	is.NoErr(err)
+	is.Equal(0, len(grants))
+	is.Equal(http.StatusForbidden, save("/blog/hello", editor))
+
+	// an editor of a page using plainsimple can only save the page if they
+	// are also granted the namespace that plainsimple shares across pages
+	is.NoErr(pm.SetUserPermissions("editor", RoleEditor, []string{"/about"}))
+	is.NoErr(pm.SavePage(Page{URL: "/about", ThemePath: "plainsimple", TemplateConfigPath: "index.config.js", Status: PageStatusPublished}))
+	r := httptest.NewRequest("GET", "/about?editmode=1", nil)
+	r.AddCookie(editor)
+	rr := httptest.NewRecorder()
+	pm.ServeHTTP(rr, r)
+	is.True(strings.Contains(rr.Body.String(), `"EditMode":true`))
+	is.True(strings.Contains(rr.Body.String(), `data-pm.id="bokwoon95/plainsimple"`))
+	savePage := func() *httptest.ResponseRecorder {
+		body := `{
+			"Namespace": "/about",
+			"LocaleCode": "",
+			"Values": {"bokwoon95/plainsimple": {"title": "Taken over"}},
+			"Rows": {"/about": {"nav": [{"Values": {"title": "Home"}, "Hrefs": {"link": "/"}}]}}
+		}`
+		r := httptest.NewRequest("POST", "/pm-themes/save", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.AddCookie(editor)
+		rr := httptest.NewRecorder()
+		pm.ServeHTTP(rr, r)
+		return rr
+	}
+	rr = savePage()
+	is.Equal(http.StatusForbidden, rr.Code)
+	is.Equal(`not allowed to edit namespace "bokwoon95/plainsimple"`, strings.TrimSpace(rr.Body.String()))
+	_, ok, err := pm.valueStore.GetDraftRows("", "/about", "nav")
+	is.NoErr(err)
+	is.True(!ok)
+	// a grant of "/" covers every page but no theme
+	is.NoErr(pm.SetUserPermissions("editor", RoleEditor, []string{"/"}))
+	is.Equal(http.StatusForbidden, savePage().Code)
+	for _, grants := range [][]string{{"/about", "bokwoon95/plainsimple"}, {"/", "bokwoon95"}} {
+		is.NoErr(pm.SetUserPermissions("editor", RoleEditor, grants))
+		is.Equal(http.StatusNoContent, savePage().Code)
+	}
+	rows, ok, err := pm.valueStore.GetDraftRows("", "/about", "nav")
+	is.NoErr(err)
+	is.True(ok)
+	is.Equal([]map[string]interface{}{{"title": "Home", "link": "/"}}, rows)
+	value, err := pm.valueStore.GetDraftValue("", "bokwoon95/plainsimple", "title")
+	is.NoErr(err)
+	is.Equal("Taken over", value.Str)
+}
@@ -0,0 +1,177 @@
+package pagemanager
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/bokwoon95/pagemanager/sq"
+	"github.com/bokwoon95/pagemanager/templatedir"
+)
+
+const pluginsURLPrefix = "/pm-plugins/"
+
+// Plugin extends a PageManager with its own assets, handlers, database
+// tables and template funcs.
+type Plugin interface {
+	// Name identifies the plugin. It must be made of lowercase letters,
+	// digits and underscores, as it is used in the plugin's URL prefix and
+	// table names.
+	Name() string
+	// FS holds the plugin's assets, which are served under
+	// /pm-plugins/<name>/. It may be nil.
+	FS() fs.FS
+	// Routes are handlers served under /pm-plugins/<name>/, keyed by their
+	// path relative to that prefix (e.g. "comments/new"). They take
+	// precedence over assets in FS.
+	Routes() map[string]http.Handler
+	// Tables are created in the data database with sq.EnsureTables when
+	// the PageManager is created. Every table name must start with
+	// plugin_<name>_ so that plugins cannot clash with each other or with
+	// the pm_ tables.
+	Tables() []sq.Table
+	// Funcs are added to the template funcs of every theme template. They
+	// must not clash with the built in template funcs or with the funcs of
+	// another plugin.
+	Funcs() map[string]interface{}
+	// Assets are the stylesheets and scripts added to every page. Paths that
+	// are neither absolute nor full URLs are relative to the plugin's FS.
+	Assets() (css, js []string)
+	// ContentSecurityPolicy is added to the Content-Security-Policy of every
+	// page, so that the plugin's assets are allowed to load.
+	ContentSecurityPolicy() map[string][]string
+}
+
+// Plugins registers plugins with the PageManager.
+func Plugins(plugins ...Plugin) Option {
+	return func(pm *PageManager) { pm.plugins = append(pm.plugins, plugins...) }
+}
+
+var pluginNameRegexp = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// registerPlugins validates the plugins and creates their tables. It returns
+// the template funcs of every plugin merged together.
+func (pm *PageManager) registerPlugins() (funcMap map[string]interface{}, err error) {
+	pm.pluginsByName = make(map[string]Plugin)
+	funcMap = make(map[string]interface{})
+	funcOwners := make(map[string]string)
+	for _, plugin := range pm.plugins {
+		name := plugin.Name()
+		if !pluginNameRegexp.MatchString(name) {
+			return nil, fmt.Errorf("invalid plugin name %q", name)
+		}
+		if _, ok := pm.pluginsByName[name]; ok {
+			return nil, fmt.Errorf("plugin %q registered more than once", name)
+		}
+		pm.pluginsByName[name] = plugin
+		tables := plugin.Tables()
+		tablePrefix := "plugin_" + name + "_"
+		for _, table := range tables {
+			if !strings.HasPrefix(table.GetName(), tablePrefix) {
+				return nil, fmt.Errorf("plugin %q: table %q does not start with %s", name, table.GetName(), tablePrefix)
+			}
+		}
+		if len(tables) > 0 {
+			err = sq.EnsureTables(pm.dataDB, pm.dataDialect, tables...)
+			if err != nil {
+				return nil, fmt.Errorf("plugin %q: %w", name, err)
+			}
+		}
+		for funcName, fn := range plugin.Funcs() {
+			if owner, ok := funcOwners[funcName]; ok {
+				return nil, fmt.Errorf("plugin %q: template func %q is already defined by plugin %q", name, funcName, owner)
+			}
+			funcOwners[funcName] = name
+			funcMap[funcName] = fn
+		}
+	}
+	return funcMap, nil
+}
+
+// pluginServeOptions returns the assets and Content-Security-Policy that
+// every plugin adds to a page.
+func (pm *PageManager) pluginServeOptions() []templatedir.ServeOption {
+	var opts []templatedir.ServeOption
+	for _, plugin := range pm.plugins {
+		prefix := pluginsURLPrefix + plugin.Name() + "/"
+		css, js := plugin.Assets()
+		opts = append(opts,
+			templatedir.CSS(pluginURLs(prefix, css)...),
+			templatedir.JS(pluginURLs(prefix, js)...),
+		)
+		if csp := plugin.ContentSecurityPolicy(); len(csp) > 0 {
+			opts = append(opts, templatedir.ContentSecurityPolicy(csp))
+		}
+	}
+	return opts
+}
+
+func pluginURLs(prefix string, urls []string) []string {
+	result := make([]string, len(urls))
+	for i, url := range urls {
+		if strings.HasPrefix(url, "/") || strings.Contains(url, "://") {
+			result[i] = url
+		} else {
+			result[i] = prefix + url
+		}
+	}
+	return result
+}
+
+// servePlugin serves the routes and assets of the plugins under
+// /pm-plugins/<name>/.
+func (pm *PageManager) servePlugin(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, pluginsURLPrefix)
+	var rest string
+	if i := strings.Index(name, "/"); i >= 0 {
+		name, rest = name[:i], name[i+1:]
+	}
+	plugin, ok := pm.pluginsByName[name]
+	if !ok {
+		pm.notFound.ServeHTTP(w, r)
+		return
+	}
+	if handler, ok := plugin.Routes()[rest]; ok {
+		handler.ServeHTTP(w, r)
+		return
+	}
+	fsys := plugin.FS()
+	if fsys == nil || !fs.ValidPath(rest) || rest == "." {
+		pm.notFound.ServeHTTP(w, r)
+		return
+	}
+	f, err := fsys.Open(rest)
+	if errors.Is(err, fs.ErrNotExist) {
+		pm.notFound.ServeHTTP(w, r)
+		return
+	}
+	if err != nil {
+		pm.errHandler(w, r, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		pm.errHandler(w, r, err)
+		return
+	}
+	if info.IsDir() {
+		pm.notFound.ServeHTTP(w, r)
+		return
+	}
+	rs, ok := f.(io.ReadSeeker)
+	if !ok {
+		b, err := io.ReadAll(f)
+		if err != nil {
+			pm.errHandler(w, r, err)
+			return
+		}
+		rs = bytes.NewReader(b)
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), rs)
+}
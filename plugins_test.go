@@ -0,0 +1,119 @@
+package pagemanager
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/bokwoon95/pagemanager/sq"
+	"github.com/bokwoon95/pagemanager/testutil"
+)
+
+type plugin_COMMENTS struct {
+	sq.TableInfo
+	COMMENT_ID sq.NumberField `sq:"type=INTEGER misc=PRIMARY_KEY"`
+	BODY       sq.StringField
+}
+
+func new_plugin_COMMENTS(name string) plugin_COMMENTS {
+	tbl := plugin_COMMENTS{}
+	tbl.TableInfo.Name = name
+	_ = sq.ReflectTable(&tbl)
+	return tbl
+}
+
+type testPlugin struct {
+	name   string
+	tables []sq.Table
+	funcs  map[string]interface{}
+}
+
+func (p testPlugin) Name() string { return p.name }
+
+func (p testPlugin) FS() fs.FS {
+	return fstest.MapFS{
+		"comments.css":     {Data: []byte(`.comment {}`)},
+		"static/README.md": {Data: []byte(`readme`)},
+	}
+}
+
+func (p testPlugin) Routes() map[string]http.Handler {
+	return map[string]http.Handler{
+		"comments/new": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("new comment"))
+		}),
+	}
+}
+
+func (p testPlugin) Tables() []sq.Table { return p.tables }
+
+func (p testPlugin) Funcs() map[string]interface{} { return p.funcs }
+
+func (p testPlugin) Assets() (css, js []string) {
+	return []string{"comments.css"}, []string{"https://comments.example.com/embed.js"}
+}
+
+func (p testPlugin) ContentSecurityPolicy() map[string][]string {
+	return map[string][]string{"script-src": {"https://comments.example.com"}}
+}
+
+func Test_Plugins(t *testing.T) {
+	is := testutil.New(t)
+	themes := fstest.MapFS{
+		"blog/theme.config.js": {Data: []byte(`return { Name: "blog" }`)},
+		"blog/index.config.js": {Data: []byte(`return { HTML: ["index.html"] }`)},
+		"blog/index.html":      {Data: []byte(`{{ .CSS }}{{ .JS }}{{ shout "hello" }}`)},
+	}
+	plugin := testPlugin{
+		name:   "comments",
+		tables: []sq.Table{new_plugin_COMMENTS("plugin_comments_comments")},
+		funcs: map[string]interface{}{
+			"shout": func(s string) string { return strings.ToUpper(s) },
+		},
+	}
+	db := newTestDB(t)
+	pm, err := New(DataDB(db, "sqlite3"), ThemesFS(themes), Plugins(plugin))
+	is.NoErr(err)
+	_, err = db.Exec("INSERT INTO plugin_comments_comments (body) VALUES ('first')")
+	is.NoErr(err)
+	get := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		pm.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		return rr
+	}
+	rr := get("/pm-plugins/comments/comments.css")
+	is.Equal(http.StatusOK, rr.Code)
+	is.Equal(".comment {}", rr.Body.String())
+	rr = get("/pm-plugins/comments/comments/new")
+	is.Equal(http.StatusOK, rr.Code)
+	is.Equal("new comment", rr.Body.String())
+	is.Equal(http.StatusNotFound, get("/pm-plugins/comments/static").Code)
+	is.Equal(http.StatusNotFound, get("/pm-plugins/comments/missing.css").Code)
+	is.Equal(http.StatusNotFound, get("/pm-plugins/other/comments.css").Code)
+
+	is.NoErr(pm.SavePage(Page{URL: "/", ThemePath: "blog", TemplateConfigPath: "index.config.js", Status: PageStatusPublished}))
+	rr = get("/")
+	is.Equal(http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	is.True(strings.Contains(body, `href="/pm-plugins/comments/comments.css"`))
+	is.True(strings.Contains(body, `src="https://comments.example.com/embed.js"`))
+	is.True(strings.Contains(body, "HELLO"))
+	is.True(strings.Contains(rr.Header().Get("Content-Security-Policy"), "https://comments.example.com"))
+
+	for _, plugins := range [][]Plugin{
+		{testPlugin{name: "Comments"}},
+		{testPlugin{name: "comments"}, testPlugin{name: "comments"}},
+		{testPlugin{name: "comments", tables: []sq.Table{new_plugin_COMMENTS("comments")}}},
+		{testPlugin{name: "comments", funcs: map[string]interface{}{"getValue": strings.ToUpper}}},
+		{
+			testPlugin{name: "a", funcs: map[string]interface{}{"shout": strings.ToUpper}},
+			testPlugin{name: "b", funcs: map[string]interface{}{"shout": strings.ToUpper}},
+		},
+	} {
+		_, err := New(DataDB(newTestDB(t), "sqlite3"), ThemesFS(themes), Plugins(plugins...))
+		is.True(err != nil)
+	}
+}
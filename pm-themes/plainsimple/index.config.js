@@ -1,7 +1,6 @@
 return {
   HTML: ["index.html", "navbar.html"],
   CSS: ["index.css", "/pm-plugins/pagemanager/tachyons.css"],
-  JS: ["index.js"],
   Vars: $CONFIG.Vars,
   ContentSecurityPolicy: $CONFIG.ContentSecurityPolicy,
 }
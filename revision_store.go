@@ -0,0 +1,111 @@
+package pagemanager
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/bokwoon95/pagemanager/erro"
+	"github.com/bokwoon95/pagemanager/sq"
+	"github.com/bokwoon95/pagemanager/templatedir"
+)
+
+// Revision is a single change to a value or to rows, recorded by the default
+// ValueStore whenever it writes something different from what was there.
+type Revision struct {
+	RevisionID int64
+	// UserID is the user that made the change, or superadminUserID for the
+	// superadmin. It is empty if the change was not made through a session.
+	UserID     string
+	LocaleCode string
+	Namespace  string
+	Name       string
+	// IsRows reports whether the change was to rows rather than to a value.
+	// Rows are stored in OldValue and NewValue as a JSON array.
+	IsRows bool
+	// OldValue is not valid if there was nothing there before.
+	OldValue  templatedir.NullString
+	NewValue  string
+	CreatedAt time.Time
+}
+
+func getRevisions(dialect string, REVISIONS pm_REVISIONS, predicates ...sq.Predicate) sq.Query {
+	if dialect == "postgres" {
+		return sq.Postgres.From(REVISIONS).Where(predicates...).OrderBy(REVISIONS.REVISION_ID.Desc())
+	}
+	return sq.SQLite.From(REVISIONS).Where(predicates...).OrderBy(REVISIONS.REVISION_ID.Desc())
+}
+
+func addRevision(dialect string, REVISIONS pm_REVISIONS, revision Revision) sq.Query {
+	mapper := func(col *sq.Column) error {
+		col.SetString(REVISIONS.USER_ID, revision.UserID)
+		col.SetString(REVISIONS.LOCALE_CODE, revision.LocaleCode)
+		col.SetString(REVISIONS.NAMESPACE, revision.Namespace)
+		col.SetString(REVISIONS.NAME, revision.Name)
+		col.SetBool(REVISIONS.IS_ROWS, revision.IsRows)
+		col.Set(REVISIONS.OLD_VALUE, sql.NullString{String: revision.OldValue.Str, Valid: revision.OldValue.Valid})
+		col.SetString(REVISIONS.NEW_VALUE, revision.NewValue)
+		col.SetTime(REVISIONS.CREATED_AT, revision.CreatedAt)
+		return nil
+	}
+	if dialect == "postgres" {
+		return sq.Postgres.InsertInto(REVISIONS).Valuesx(mapper)
+	}
+	return sq.SQLite.InsertInto(REVISIONS).Valuesx(mapper)
+}
+
+func revisionmapper(revision *Revision, REVISIONS pm_REVISIONS) func(*sq.Row) error {
+	return func(row *sq.Row) error {
+		revision.RevisionID = row.Int64(REVISIONS.REVISION_ID)
+		revision.UserID = row.String(REVISIONS.USER_ID)
+		revision.LocaleCode = row.String(REVISIONS.LOCALE_CODE)
+		revision.Namespace = row.String(REVISIONS.NAMESPACE)
+		revision.Name = row.String(REVISIONS.NAME)
+		revision.IsRows = row.Bool(REVISIONS.IS_ROWS)
+		oldValue := row.NullString(REVISIONS.OLD_VALUE)
+		revision.OldValue = templatedir.NullString{Valid: oldValue.Valid, Str: oldValue.String}
+		revision.NewValue = row.String(REVISIONS.NEW_VALUE)
+		revision.CreatedAt = row.Time(REVISIONS.CREATED_AT)
+		return sq.SkipRows
+	}
+}
+
+func revisionsmapper(revisions *[]Revision, REVISIONS pm_REVISIONS) func(*sq.Row) error {
+	return func(row *sq.Row) error {
+		var revision Revision
+		_ = revisionmapper(&revision, REVISIONS)(row)
+		return row.Accumulate(func() error {
+			*revisions = append(*revisions, revision)
+			return nil
+		})
+	}
+}
+
+type revisionstore struct {
+	db      *sql.DB
+	dialect string
+}
+
+// GetRevision returns nil if the revision does not exist.
+func (store revisionstore) GetRevision(revisionID int64) (*Revision, error) {
+	var revision Revision
+	REVISIONS := new_REVISIONS("r")
+	rowCount, err := sq.Fetch(store.db, getRevisions(store.dialect, REVISIONS, REVISIONS.REVISION_ID.EqInt64(revisionID)), revisionmapper(&revision, REVISIONS))
+	if err != nil {
+		return nil, erro.Wrap(err)
+	}
+	if rowCount == 0 {
+		return nil, nil
+	}
+	return &revision, nil
+}
+
+// ListRevisions returns the revisions matching the predicates, newest first.
+func (store revisionstore) ListRevisions(predicates ...sq.Predicate) ([]Revision, error) {
+	var revisions []Revision
+	REVISIONS := new_REVISIONS("r")
+	_, err := sq.Fetch(store.db, getRevisions(store.dialect, REVISIONS, predicates...), revisionsmapper(&revisions, REVISIONS))
+	if err != nil {
+		return nil, erro.Wrap(err)
+	}
+	return revisions, nil
+}
@@ -0,0 +1,179 @@
+package pagemanager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/bokwoon95/pagemanager/erro"
+)
+
+// ListRevisions returns the revisions of the value or rows called name in a
+// namespace and locale, newest first. Only the default ValueStore records
+// revisions.
+func (pm *PageManager) ListRevisions(localeCode, namespace, name string) ([]Revision, error) {
+	REVISIONS := new_REVISIONS("r")
+	return pm.revisions.ListRevisions(
+		REVISIONS.LOCALE_CODE.EqString(localeCode),
+		REVISIONS.NAMESPACE.EqString(namespace),
+		REVISIONS.NAME.EqString(name),
+	)
+}
+
+// ListPageRevisions returns the revisions of every value and rows in a
+// namespace across all locales, newest first.
+func (pm *PageManager) ListPageRevisions(namespace string) ([]Revision, error) {
+	REVISIONS := new_REVISIONS("r")
+	return pm.revisions.ListRevisions(REVISIONS.NAMESPACE.EqString(namespace))
+}
+
+func (pm *PageManager) getRevision(revisionID int64) (*Revision, error) {
+	revision, err := pm.revisions.GetRevision(revisionID)
+	if err != nil {
+		return nil, err
+	}
+	if revision == nil {
+		return nil, fmt.Errorf("revision %d does not exist", revisionID)
+	}
+	return revision, nil
+}
+
+// DiffLine is a line of the difference between two revisions.
+type DiffLine struct {
+	// Op is ' ' for a line in both revisions, '-' for a line only in the old
+	// revision and '+' for a line only in the new revision.
+	Op   byte
+	Text string
+}
+
+// DiffRevisions compares the content as it was right after oldRevisionID with
+// the content as it was right after newRevisionID, line by line. Both must be
+// revisions of the same value or rows. If oldRevisionID is 0, newRevisionID is
+// compared with the content that it replaced.
+func (pm *PageManager) DiffRevisions(oldRevisionID, newRevisionID int64) ([]DiffLine, error) {
+	newRevision, err := pm.getRevision(newRevisionID)
+	if err != nil {
+		return nil, err
+	}
+	oldValue := newRevision.OldValue.Str
+	if oldRevisionID != 0 {
+		oldRevision, err := pm.getRevision(oldRevisionID)
+		if err != nil {
+			return nil, err
+		}
+		if oldRevision.LocaleCode != newRevision.LocaleCode || oldRevision.Namespace != newRevision.Namespace ||
+			oldRevision.Name != newRevision.Name || oldRevision.IsRows != newRevision.IsRows {
+			return nil, fmt.Errorf("revisions %d and %d are not of the same content", oldRevisionID, newRevisionID)
+		}
+		oldValue = oldRevision.NewValue
+	}
+	oldLines, err := revisionLines(newRevision.IsRows, oldValue)
+	if err != nil {
+		return nil, err
+	}
+	newLines, err := revisionLines(newRevision.IsRows, newRevision.NewValue)
+	if err != nil {
+		return nil, err
+	}
+	return diffLines(oldLines, newLines), nil
+}
+
+// revisionLines splits the content of a revision into the lines that are
+// compared. Rows are indented so that every field is on its own line.
+func revisionLines(isRows bool, value string) ([]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if isRows {
+		var rows []interface{}
+		err := json.Unmarshal([]byte(value), &rows)
+		if err != nil {
+			return nil, erro.Wrap(err)
+		}
+		b, err := json.MarshalIndent(rows, "", "  ")
+		if err != nil {
+			return nil, erro.Wrap(err)
+		}
+		value = string(b)
+	}
+	return strings.Split(value, "\n"), nil
+}
+
+// diffLines returns the shortest edit from oldLines to newLines, using the
+// longest common subsequence of the two.
+func diffLines(oldLines, newLines []string) []DiffLine {
+	// lcs[i][j] is the length of the longest common subsequence of
+	// oldLines[i:] and newLines[j:]
+	lcs := make([][]int, len(oldLines)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(newLines)+1)
+	}
+	for i := len(oldLines) - 1; i >= 0; i-- {
+		for j := len(newLines) - 1; j >= 0; j-- {
+			if oldLines[i] == newLines[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var diff []DiffLine
+	i, j := 0, 0
+	for i < len(oldLines) && j < len(newLines) {
+		switch {
+		case oldLines[i] == newLines[j]:
+			diff = append(diff, DiffLine{Op: ' ', Text: oldLines[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, DiffLine{Op: '-', Text: oldLines[i]})
+			i++
+		default:
+			diff = append(diff, DiffLine{Op: '+', Text: newLines[j]})
+			j++
+		}
+	}
+	for ; i < len(oldLines); i++ {
+		diff = append(diff, DiffLine{Op: '-', Text: oldLines[i]})
+	}
+	for ; j < len(newLines); j++ {
+		diff = append(diff, DiffLine{Op: '+', Text: newLines[j]})
+	}
+	return diff
+}
+
+// RestoreRevision puts the content back to how it was right after revisionID,
+// in a single transaction. The restore is recorded as a revision of its own
+// made by the user in ctx, so it can be undone in turn.
+func (pm *PageManager) RestoreRevision(ctx context.Context, revisionID int64) (err error) {
+	revision, err := pm.getRevision(revisionID)
+	if err != nil {
+		return err
+	}
+	tx, err := pm.valueStore.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	if revision.IsRows {
+		var rows []map[string]interface{}
+		err = json.Unmarshal([]byte(revision.NewValue), &rows)
+		if err != nil {
+			return erro.Wrap(err)
+		}
+		err = tx.SetRows(revision.LocaleCode, revision.Namespace, revision.Name, rows)
+	} else {
+		err = tx.SetValue(revision.LocaleCode, revision.Namespace, revision.Name, revision.NewValue)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,78 @@
+package pagemanager
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bokwoon95/pagemanager/templatedir"
+	"github.com/bokwoon95/pagemanager/testutil"
+)
+
+func Test_revisions(t *testing.T) {
+	is := testutil.New(t)
+	pm, err := New(DataDB(newTestDB(t), "sqlite3"))
+	is.NoErr(err)
+	write := func(userID string, fn func(tx templatedir.ValueStoreTx)) {
+		ctx := context.WithValue(context.Background(), ctxKeySession, &Session{UserID: userID})
+		tx, err := pm.valueStore.BeginTx(ctx)
+		is.NoErr(err)
+		fn(tx)
+		is.NoErr(tx.Commit())
+	}
+	write("alice", func(tx templatedir.ValueStoreTx) {
+		is.NoErr(tx.SetValue("en", "/about", "title", "About\nme"))
+		is.NoErr(tx.SetRows("en", "/about", "links", []map[string]interface{}{{"href": "/a"}}))
+	})
+	write("bob", func(tx templatedir.ValueStoreTx) {
+		is.NoErr(tx.SetValue("en", "/about", "title", "About\nyou"))
+		// unchanged content is not recorded
+		is.NoErr(tx.SetRows("en", "/about", "links", []map[string]interface{}{{"href": "/a"}}))
+		is.NoErr(tx.SetValue("fr", "/about", "title", "À propos"))
+		is.NoErr(tx.SetValue("en", "/other", "title", "Other"))
+	})
+
+	revisions, err := pm.ListRevisions("en", "/about", "title")
+	is.NoErr(err)
+	is.Equal(2, len(revisions))
+	is.Equal("bob", revisions[0].UserID)
+	is.Equal(templatedir.NullString{Valid: true, Str: "About\nme"}, revisions[0].OldValue)
+	is.Equal("About\nyou", revisions[0].NewValue)
+	is.Equal("alice", revisions[1].UserID)
+	is.True(!revisions[1].OldValue.Valid)
+	pageRevisions, err := pm.ListPageRevisions("/about")
+	is.NoErr(err)
+	is.Equal(4, len(pageRevisions))
+
+	diff, err := pm.DiffRevisions(revisions[1].RevisionID, revisions[0].RevisionID)
+	is.NoErr(err)
+	is.Equal([]DiffLine{{' ', "About"}, {'-', "me"}, {'+', "you"}}, diff)
+	diff, err = pm.DiffRevisions(0, revisions[1].RevisionID)
+	is.NoErr(err)
+	is.Equal([]DiffLine{{'+', "About"}, {'+', "me"}}, diff)
+	_, err = pm.DiffRevisions(pageRevisions[0].RevisionID, revisions[0].RevisionID)
+	is.True(err != nil)
+
+	// restoring is a revision of its own
+	is.NoErr(pm.RestoreRevision(context.Background(), revisions[1].RevisionID))
+	value, err := pm.valueStore.GetValue("en", "/about", "title")
+	is.NoErr(err)
+	is.Equal("About\nme", value.Str)
+	revisions, err = pm.ListRevisions("en", "/about", "title")
+	is.NoErr(err)
+	is.Equal(3, len(revisions))
+	is.Equal("", revisions[0].UserID)
+
+	write("alice", func(tx templatedir.ValueStoreTx) {
+		is.NoErr(tx.SetRows("en", "/about", "links", nil))
+	})
+	revisions, err = pm.ListRevisions("en", "/about", "links")
+	is.NoErr(err)
+	is.Equal(2, len(revisions))
+	is.True(revisions[0].IsRows)
+	is.Equal("[]", revisions[0].NewValue)
+	is.NoErr(pm.RestoreRevision(context.Background(), revisions[1].RevisionID))
+	rows, err := pm.valueStore.GetRows("en", "/about", "links")
+	is.NoErr(err)
+	is.Equal([]map[string]interface{}{{"href": "/a"}}, rows)
+	is.True(pm.RestoreRevision(context.Background(), 1000) != nil)
+}
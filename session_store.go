@@ -0,0 +1,125 @@
+package pagemanager
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/bokwoon95/pagemanager/erro"
+	"github.com/bokwoon95/pagemanager/sq"
+)
+
+type Session struct {
+	SessionID    string
+	UserID       string
+	CreatedAt    time.Time
+	LastActiveAt time.Time
+}
+
+// SessionStore stores server-side sessions. Expiry is decided by the
+// PageManager, the store only needs to record when a session was created and
+// when it was last active.
+type SessionStore interface {
+	CreateSession(session Session) error
+	// GetSession returns nil if the session does not exist.
+	GetSession(sessionID string) (*Session, error)
+	TouchSession(sessionID string, lastActiveAt time.Time) error
+	RevokeSession(sessionID string) error
+	RevokeUserSessions(userID string) error
+}
+
+func getSession(dialect string, SESSIONS pm_SESSIONS, sessionID string) sq.Query {
+	if dialect == "postgres" {
+		return sq.Postgres.From(SESSIONS).Where(SESSIONS.SESSION_ID.EqString(sessionID))
+	}
+	return sq.SQLite.From(SESSIONS).Where(SESSIONS.SESSION_ID.EqString(sessionID))
+}
+
+func addSession(dialect string, SESSIONS pm_SESSIONS, session Session) sq.Query {
+	mapper := func(col *sq.Column) error {
+		col.SetString(SESSIONS.SESSION_ID, session.SessionID)
+		col.SetString(SESSIONS.USER_ID, session.UserID)
+		col.SetTime(SESSIONS.CREATED_AT, session.CreatedAt)
+		col.SetTime(SESSIONS.LAST_ACTIVE_AT, session.LastActiveAt)
+		return nil
+	}
+	if dialect == "postgres" {
+		return sq.Postgres.InsertInto(SESSIONS).Valuesx(mapper)
+	}
+	return sq.SQLite.InsertInto(SESSIONS).Valuesx(mapper)
+}
+
+func touchSession(dialect string, SESSIONS pm_SESSIONS, sessionID string, lastActiveAt time.Time) sq.Query {
+	assignments := []sq.Assignment{
+		SESSIONS.LAST_ACTIVE_AT.SetTime(lastActiveAt),
+	}
+	if dialect == "postgres" {
+		return sq.Postgres.Update(SESSIONS).Set(assignments...).Where(SESSIONS.SESSION_ID.EqString(sessionID))
+	}
+	return sq.SQLite.Update(SESSIONS).Set(assignments...).Where(SESSIONS.SESSION_ID.EqString(sessionID))
+}
+
+func deleteSession(dialect string, SESSIONS pm_SESSIONS, sessionID string) sq.Query {
+	if dialect == "postgres" {
+		return sq.Postgres.DeleteFrom(SESSIONS).Where(SESSIONS.SESSION_ID.EqString(sessionID))
+	}
+	return sq.SQLite.DeleteFrom(SESSIONS).Where(SESSIONS.SESSION_ID.EqString(sessionID))
+}
+
+func deleteUserSessions(dialect string, SESSIONS pm_SESSIONS, userID string) sq.Query {
+	if dialect == "postgres" {
+		return sq.Postgres.DeleteFrom(SESSIONS).Where(SESSIONS.USER_ID.EqString(userID))
+	}
+	return sq.SQLite.DeleteFrom(SESSIONS).Where(SESSIONS.USER_ID.EqString(userID))
+}
+
+func sessionmapper(session *Session, SESSIONS pm_SESSIONS) func(*sq.Row) error {
+	return func(row *sq.Row) error {
+		session.SessionID = row.String(SESSIONS.SESSION_ID)
+		session.UserID = row.String(SESSIONS.USER_ID)
+		session.CreatedAt = row.Time(SESSIONS.CREATED_AT)
+		session.LastActiveAt = row.Time(SESSIONS.LAST_ACTIVE_AT)
+		return sq.SkipRows
+	}
+}
+
+type sessionstore struct {
+	db      *sql.DB
+	dialect string
+}
+
+func (store sessionstore) CreateSession(session Session) error {
+	SESSIONS := new_SESSIONS("s")
+	_, _, err := sq.Exec(store.db, addSession(store.dialect, SESSIONS, session), 0)
+	return erro.Wrap(err)
+}
+
+func (store sessionstore) GetSession(sessionID string) (*Session, error) {
+	var session Session
+	SESSIONS := new_SESSIONS("s")
+	rowCount, err := sq.Fetch(store.db, getSession(store.dialect, SESSIONS, sessionID), sessionmapper(&session, SESSIONS))
+	if err != nil {
+		return nil, erro.Wrap(err)
+	}
+	if rowCount == 0 {
+		return nil, nil
+	}
+	return &session, nil
+}
+
+func (store sessionstore) TouchSession(sessionID string, lastActiveAt time.Time) error {
+	SESSIONS := new_SESSIONS("s")
+	_, _, err := sq.Exec(store.db, touchSession(store.dialect, SESSIONS, sessionID, lastActiveAt), 0)
+	return erro.Wrap(err)
+}
+
+func (store sessionstore) RevokeSession(sessionID string) error {
+	SESSIONS := new_SESSIONS("s")
+	_, _, err := sq.Exec(store.db, deleteSession(store.dialect, SESSIONS, sessionID), 0)
+	return erro.Wrap(err)
+}
+
+func (store sessionstore) RevokeUserSessions(userID string) error {
+	SESSIONS := new_SESSIONS("s")
+	_, _, err := sq.Exec(store.db, deleteUserSessions(store.dialect, SESSIONS, userID), 0)
+	return erro.Wrap(err)
+}
@@ -0,0 +1,155 @@
+package pagemanager
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"time"
+)
+
+const (
+	sessionCookieName = "pm-session"
+	// sessionTouchInterval limits how often a session's LastActiveAt is
+	// written back to the SessionStore.
+	sessionTouchInterval = time.Minute
+)
+
+type ctxKey string
+
+const ctxKeySession ctxKey = "session"
+
+// SessionFromContext returns the session put into the context by
+// LoadSession, or nil if the request has no valid session.
+func SessionFromContext(ctx context.Context) *Session {
+	session, _ := ctx.Value(ctxKeySession).(*Session)
+	return session
+}
+
+// LoadSession is a middleware that looks up the session of the request and
+// puts it into the request context, where it can be retrieved with
+// SessionFromContext. The user that the session belongs to can be retrieved
+// with UserFromContext. Sessions that have been idle for longer than the idle
+// timeout or that are older than the max age are revoked, as are sessions of
+// users that have been deleted or disabled.
+func (pm *PageManager) LoadSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, err := pm.getSession(w, r)
+		if err != nil {
+			pm.errHandler(w, r, err)
+			return
+		}
+		var user *User
+		if session != nil && session.UserID != superadminUserID {
+			user, err = pm.users.GetUser(session.UserID)
+			if err != nil {
+				pm.errHandler(w, r, err)
+				return
+			}
+			if user == nil || user.Status != UserStatusActive {
+				clearSessionCookie(w)
+				err = pm.sessions.RevokeSession(session.SessionID)
+				if err != nil {
+					pm.errHandler(w, r, err)
+					return
+				}
+				session, user = nil, nil
+			}
+		}
+		if session != nil {
+			r = r.WithContext(context.WithValue(r.Context(), ctxKeySession, session))
+		}
+		if user != nil {
+			r = r.WithContext(context.WithValue(r.Context(), ctxKeyUser, user))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (pm *PageManager) getSession(w http.ResponseWriter, r *http.Request) (*Session, error) {
+	c, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return nil, nil
+	}
+	sessionID, err := pm.sessionKeybox.HashDecode([]byte(c.Value))
+	if err != nil {
+		clearSessionCookie(w)
+		return nil, nil
+	}
+	session, err := pm.sessions.GetSession(string(sessionID))
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		clearSessionCookie(w)
+		return nil, nil
+	}
+	// The superadmin is only logged in while the site is unlocked. After a
+	// restart their cookie is kept, so that it becomes valid again once the
+	// superadmin password has been entered.
+	if session.UserID == superadminUserID && !pm.pwbox.PasswordEntered() {
+		return nil, nil
+	}
+	now := time.Now()
+	if now.Sub(session.LastActiveAt) > pm.sessionIdleTimeout || now.Sub(session.CreatedAt) > pm.sessionMaxAge {
+		clearSessionCookie(w)
+		return nil, pm.sessions.RevokeSession(session.SessionID)
+	}
+	if now.Sub(session.LastActiveAt) > sessionTouchInterval {
+		err = pm.sessions.TouchSession(session.SessionID, now)
+		if err != nil {
+			return nil, err
+		}
+		session.LastActiveAt = now
+	}
+	return session, nil
+}
+
+// startSession creates a new session for userID and sets the session cookie.
+func (pm *PageManager) startSession(w http.ResponseWriter, r *http.Request, userID string) error {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	session := Session{
+		SessionID:    base64.RawURLEncoding.EncodeToString(b),
+		UserID:       userID,
+		CreatedAt:    now,
+		LastActiveAt: now,
+	}
+	value, err := pm.sessionKeybox.HashEncode([]byte(session.SessionID))
+	if err != nil {
+		return err
+	}
+	err = pm.sessions.CreateSession(session)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    string(value),
+		Path:     "/",
+		Expires:  now.Add(pm.sessionMaxAge),
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// endSession revokes the session of the request (if any) and clears the
+// session cookie.
+func (pm *PageManager) endSession(w http.ResponseWriter, r *http.Request) error {
+	clearSessionCookie(w)
+	session := SessionFromContext(r.Context())
+	if session == nil {
+		return nil
+	}
+	return pm.sessions.RevokeSession(session.SessionID)
+}
+
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: sessionCookieName, Path: "/", MaxAge: -1})
+}
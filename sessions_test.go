@@ -0,0 +1,115 @@
+package pagemanager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bokwoon95/pagemanager/testutil"
+)
+
+func Test_LoadSession(t *testing.T) {
+	runDialects(t, func(t *testing.T, dialect string) {
+		is := testutil.New(t)
+		pm, err := New(DataDB(newDialectDB(t, dialect), dialect), SessionTimeouts(time.Hour, 24*time.Hour))
+		is.NoErr(err)
+		is.NoErr(pm.pwbox.SetPassword([]byte("password123")))
+		for _, userID := range []string{"user1", "user2"} {
+			is.NoErr(pm.users.CreateUser(User{UserID: userID, LoginID: userID, Status: UserStatusActive}))
+		}
+		newSession := func(userID string) *http.Cookie {
+			rr := httptest.NewRecorder()
+			is.NoErr(pm.startSession(rr, httptest.NewRequest("GET", "/", nil), userID))
+			cookies := rr.Result().Cookies()
+			is.Equal(1, len(cookies))
+			return cookies[0]
+		}
+		load := func(cookie *http.Cookie) *Session {
+			var session *Session
+			r := httptest.NewRequest("GET", "/", nil)
+			r.AddCookie(cookie)
+			pm.LoadSession(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				session = SessionFromContext(r.Context())
+			})).ServeHTTP(httptest.NewRecorder(), r)
+			return session
+		}
+
+		cookie := newSession("user1")
+		session := load(cookie)
+		is.True(session != nil)
+		is.Equal("user1", session.UserID)
+
+		// tampered cookies are rejected
+		is.True(load(&http.Cookie{Name: cookie.Name, Value: cookie.Value + "x"}) == nil)
+
+		// idle sessions expire
+		is.NoErr(pm.sessions.TouchSession(session.SessionID, time.Now().Add(-2*time.Hour)))
+		is.True(load(cookie) == nil)
+		stored, err := pm.sessions.GetSession(session.SessionID)
+		is.NoErr(err)
+		is.True(stored == nil)
+
+		// sessions older than the max age expire even if active
+		cookie = newSession("user1")
+		session = load(cookie)
+		is.NoErr(pm.sessions.RevokeSession(session.SessionID))
+		session.CreatedAt = time.Now().Add(-48 * time.Hour)
+		is.NoErr(pm.sessions.CreateSession(*session))
+		is.True(load(cookie) == nil)
+
+		// revoking all sessions of a user leaves other users alone
+		cookie1, cookie2, cookie3 := newSession("user1"), newSession("user1"), newSession("user2")
+		is.NoErr(pm.sessions.RevokeUserSessions("user1"))
+		is.True(load(cookie1) == nil)
+		is.True(load(cookie2) == nil)
+		is.True(load(cookie3) != nil)
+
+		// sessions of disabled users are revoked
+		is.NoErr(pm.users.UpdateUser(User{UserID: "user2", LoginID: "user2", Status: UserStatusDisabled}))
+		is.True(load(cookie3) == nil)
+	})
+}
+
+func Test_LoadSessionLocked(t *testing.T) {
+	is := testutil.New(t)
+	dataDB, superadminDB := newTestDB(t), newTestDB(t)
+	pm, err := New(DataDB(dataDB, "sqlite3"), SuperadminDB(superadminDB, "sqlite3"))
+	is.NoErr(err)
+	is.NoErr(pm.pwbox.SetPassword([]byte("password123")))
+	is.NoErr(pm.users.CreateUser(User{UserID: "user1", LoginID: "user1", Status: UserStatusActive}))
+	newSession := func(userID string) *http.Cookie {
+		rr := httptest.NewRecorder()
+		is.NoErr(pm.startSession(rr, httptest.NewRequest("GET", "/", nil), userID))
+		return rr.Result().Cookies()[0]
+	}
+	userCookie, superadminCookie := newSession("user1"), newSession(superadminUserID)
+
+	// a restarted PageManager is locked until the superadmin password is
+	// entered again, but users stay logged in
+	pm, err = New(DataDB(dataDB, "sqlite3"), SuperadminDB(superadminDB, "sqlite3"))
+	is.NoErr(err)
+	is.True(!pm.pwbox.PasswordEntered())
+	load := func(cookie *http.Cookie) (*Session, *httptest.ResponseRecorder) {
+		var session *Session
+		r := httptest.NewRequest("GET", "/", nil)
+		r.AddCookie(cookie)
+		rr := httptest.NewRecorder()
+		pm.LoadSession(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			session = SessionFromContext(r.Context())
+		})).ServeHTTP(rr, r)
+		return session, rr
+	}
+	session, _ := load(userCookie)
+	is.True(session != nil)
+	is.Equal("user1", session.UserID)
+
+	// the superadmin is logged out but keeps their cookie
+	session, rr := load(superadminCookie)
+	is.True(session == nil)
+	is.Equal(0, len(rr.Result().Cookies()))
+	is.NoErr(pm.pwbox.EnterPassword([]byte("password123")))
+	session, _ = load(superadminCookie)
+	is.True(session != nil)
+	is.Equal(superadminUserID, session.UserID)
+}
@@ -118,7 +118,7 @@ func fetchContext(ctx context.Context, db Queryer, q Query, rowmapper func(*Row)
 		logger.LogQueryStats(ctx, stats)
 	}()
 	err = rowmapper(r)
-	if err != nil {
+	if err != nil && !errors.Is(err, SkipRows) {
 		return 0, err
 	}
 	q, err = q.SetFetchableFields(r.fields) // Queries must handle the case when len(r.fields) == 0. For example, SelectQuery must default to SELECT 1 in case the rowmapper does nothing
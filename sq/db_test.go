@@ -138,6 +138,18 @@ func Test_Fetch2(t *testing.T) {
 		is.Equal(int64(6), rowCount)
 	})
 
+	t.Run("SkipRows", func(t *testing.T) {
+		is := testutil.New(t)
+		var name string
+		rowCount, err := Fetch(db, SQLite.From(tbl).Where(tbl.ID.GtInt(2)), func(row *Row) error {
+			name = row.String(tbl.NAME)
+			return SkipRows
+		})
+		is.NoErr(err)
+		is.Equal(int64(1), rowCount)
+		is.Equal("c", name)
+	})
+
 	t.Run("wrapScanError", func(t *testing.T) {
 		is := testutil.New(t)
 		rowCount, err := Fetch(sqldb, SQLite.From(tbl).Where(tbl.ID.GtInt(2)), func(row *Row) error {
@@ -55,6 +55,9 @@ func EnsureTables(db Queryer, dialect string, tables ...Table) error {
 			if tbl.name == "" {
 				tbl.name = strings.ToLower(typ.Name())
 			}
+			// a tag like `sq:"unique=a,b"` on the TableInfo makes the
+			// columns a and b unique together
+			tbl.unique = m["unique"]
 			break
 		}
 		for i := 0; i < T.NumField(); i++ {
@@ -72,31 +75,24 @@ func EnsureTables(db Queryer, dialect string, tables ...Table) error {
 			if m.Get("name") != "" {
 				col.name = m.Get("name")
 			}
-			switch fieldValue.(type) {
-			case BlobField:
-				col.typ = "BLOB"
-			case BooleanField:
-				col.typ = "BOOLEAN"
-			case JSONField:
-				col.typ = "JSON"
-			case NumberField:
-				col.typ = "INTEGER"
-			case StringField:
-				col.typ = "TEXT"
-			case TimeField:
-				col.typ = "DATETIME"
-			}
+			col.typ = columnType(dialect, fieldValue)
 			if m.Get("type") != "" {
 				col.typ = m.Get("type")
 			}
 			for _, constraint := range m["misc"] {
 				col.constraints = append(col.constraints, strings.ReplaceAll(constraint, "_", " "))
 			}
+			// SQLite turns an INTEGER PRIMARY KEY into an alias for the rowid,
+			// which fills itself in when left out of an INSERT. Postgres needs
+			// an identity column for the same behaviour.
+			if dialect == "postgres" && strings.EqualFold(col.typ, "INTEGER") && hasConstraint(col.constraints, "PRIMARY KEY") {
+				col.typ = "BIGINT GENERATED BY DEFAULT AS IDENTITY"
+			}
 			tbl.columns = append(tbl.columns, col)
 		}
 		tbls = append(tbls, tbl)
 	}
-	err = loadtables(db, tbls)
+	err = loadtables(db, dialect, tbls)
 	if err != nil {
 		return erro.Wrap(err)
 	}
@@ -106,13 +102,67 @@ func EnsureTables(db Queryer, dialect string, tables ...Table) error {
 	return nil
 }
 
-func loadtables(db Queryer, tables []htable) error {
+// columnType returns the default column type of a field for the dialect.
+func columnType(dialect string, field interface{}) string {
+	if dialect == "postgres" {
+		switch field.(type) {
+		case BlobField:
+			return "BYTEA"
+		case BooleanField:
+			return "BOOLEAN"
+		case JSONField:
+			return "JSONB"
+		case NumberField:
+			return "INTEGER"
+		case StringField:
+			return "TEXT"
+		case TimeField:
+			return "TIMESTAMPTZ"
+		}
+		return ""
+	}
+	switch field.(type) {
+	case BlobField:
+		return "BLOB"
+	case BooleanField:
+		return "BOOLEAN"
+	case JSONField:
+		return "JSON"
+	case NumberField:
+		return "INTEGER"
+	case StringField:
+		return "TEXT"
+	case TimeField:
+		return "DATETIME"
+	}
+	return ""
+}
+
+func hasConstraint(constraints []string, constraint string) bool {
+	for _, c := range constraints {
+		if strings.EqualFold(c, constraint) {
+			return true
+		}
+	}
+	return false
+}
+
+func loadtables(db Queryer, dialect string, tables []htable) error {
+	// SQLite keeps its schema in sqlite_master and pragma_table_info, while
+	// Postgres exposes it through information_schema (scoped to the current
+	// schema, i.e. the first entry of the search_path).
+	existsQuery := "SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE name = ?)"
+	columnsQuery := "SELECT name FROM pragma_table_info(?)"
+	if dialect == "postgres" {
+		existsQuery = "SELECT EXISTS(SELECT 1 FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1)"
+		columnsQuery = "SELECT column_name FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1"
+	}
 	var rows *sql.Rows
 	var err error
 	for _, table := range tables {
 		// does table exist?
 		var exists sql.NullBool
-		rows, err = db.Query("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE name = ?)", table.name)
+		rows, err = db.Query(existsQuery, table.name)
 		if err != nil {
 			return erro.Wrap(err)
 		}
@@ -134,7 +184,7 @@ func loadtables(db Queryer, tables []htable) error {
 		}
 		// do columns exist?
 		columnset := make(map[string]struct{})
-		rows, err = db.Query("SELECT name FROM pragma_table_info(?)", table.name)
+		rows, err = db.Query(columnsQuery, table.name)
 		if err != nil {
 			return erro.Wrap(err)
 		}
@@ -155,7 +205,7 @@ func loadtables(db Queryer, tables []htable) error {
 			}
 			query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table.name, column.name, column.typ)
 			if len(column.constraints) > 0 {
-				query = query + strings.Join(column.constraints, " ")
+				query = query + " " + strings.Join(column.constraints, " ")
 			}
 			_, err = db.Exec(query)
 			if err != nil {
@@ -163,6 +213,19 @@ func loadtables(db Queryer, tables []htable) error {
 			}
 		}
 	}
+	// unique keys are created as indexes so that they can be added to
+	// tables that already exist
+	for _, table := range tables {
+		if len(table.unique) == 0 {
+			continue
+		}
+		query := fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s_%s_key ON %s (%s)",
+			table.name, strings.Join(table.unique, "_"), table.name, strings.Join(table.unique, ", "))
+		_, err = db.Exec(query)
+		if err != nil {
+			return erro.Wrap(err)
+		}
+	}
 	return nil
 }
 
@@ -261,6 +324,7 @@ type htable struct {
 	name        string
 	columns     []hcolumn
 	constraints []string
+	unique      []string
 }
 
 type hcolumn struct {
@@ -2,7 +2,11 @@ package sq
 
 import (
 	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/bokwoon95/pagemanager/testutil"
 	_ "github.com/mattn/go-sqlite3"
@@ -20,3 +24,97 @@ func Test_EnsureTables(t *testing.T) {
 	is.NoErr(err)
 	sqldb.Close()
 }
+
+func Test_EnsureTablesPostgres(t *testing.T) {
+	is := testutil.New(t)
+	sqldb := newPostgresTestDB(t)
+	u, a := NEW_USERS("u"), NEW_APPLICATIONS("a")
+	err := WithTx(sqldb, func(tx *sql.Tx) error {
+		return EnsureTables(tx, "postgres", u, a)
+	})
+	is.NoErr(err)
+	// running it a second time must find the existing tables and columns
+	err = WithTx(sqldb, func(tx *sql.Tx) error {
+		return EnsureTables(tx, "postgres", u, a)
+	})
+	is.NoErr(err)
+	var count int
+	err = sqldb.QueryRow(
+		"SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name IN ($1, $2)",
+		u.GetName(), a.GetName(),
+	).Scan(&count)
+	is.NoErr(err)
+	is.Equal(2, count)
+}
+
+// newPostgresTestDB connects to the database in PM_TEST_POSTGRES_URL (a
+// postgres:// URL) inside a fresh schema that is dropped when the test ends.
+// The test is skipped if PM_TEST_POSTGRES_URL is not set.
+func newPostgresTestDB(t *testing.T) *sql.DB {
+	dsn := os.Getenv("PM_TEST_POSTGRES_URL")
+	if dsn == "" {
+		t.Skip("PM_TEST_POSTGRES_URL not set")
+	}
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	_, err = admin.Exec("CREATE SCHEMA " + schema)
+	if err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+	sqldb, err := sql.Open("postgres", u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		sqldb.Close()
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		admin.Close()
+	})
+	return sqldb
+}
+
+func Test_EnsureTablesUnique(t *testing.T) {
+	type KEYVALUES struct {
+		TableInfo `sq:"unique=namespace,name"`
+		NAMESPACE StringField
+		NAME      StringField
+		VALUE     StringField
+	}
+	for _, dialect := range []string{"sqlite3", "postgres"} {
+		dialect := dialect
+		t.Run(dialect, func(t *testing.T) {
+			is := testutil.New(t)
+			var sqldb *sql.DB
+			if dialect == "postgres" {
+				sqldb = newPostgresTestDB(t)
+			} else {
+				var err error
+				sqldb, err = sql.Open("sqlite3", ":memory:")
+				is.NoErr(err)
+				defer sqldb.Close()
+			}
+			tbl := KEYVALUES{TableInfo: TableInfo{Name: "keyvalues"}}
+			is.NoErr(ReflectTable(&tbl))
+			// ensuring the tables twice adds the unique key only once
+			is.NoErr(EnsureTables(sqldb, dialect, tbl))
+			is.NoErr(EnsureTables(sqldb, dialect, tbl))
+			_, err := sqldb.Exec("INSERT INTO keyvalues (namespace, name, value) VALUES ('a', 'b', 'c')")
+			is.NoErr(err)
+			_, err = sqldb.Exec("INSERT INTO keyvalues (namespace, name, value) VALUES ('a', 'c', 'c')")
+			is.NoErr(err)
+			_, err = sqldb.Exec("INSERT INTO keyvalues (namespace, name, value) VALUES ('a', 'b', 'd')")
+			is.True(err != nil)
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package sq
+
+import "bytes"
+
+type PostgresDeleteQuery struct {
+	// WITH
+	CTEs CTEs
+	// DELETE FROM
+	FromTable BaseTable
+	// USING
+	UsingTable Table
+	JoinTables JoinTables
+	// WHERE
+	WherePredicate VariadicPredicate
+}
+
+func (q PostgresDeleteQuery) AppendSQL(dialect string, buf *bytes.Buffer, args *[]interface{}, params map[string]int) error {
+	var err error
+	// WITH
+	if len(q.CTEs) > 0 {
+		err = q.CTEs.AppendCTEs(dialect, buf, args, params, q.UsingTable, q.JoinTables)
+		if err != nil {
+			return err
+		}
+	}
+	// DELETE FROM
+	buf.WriteString("DELETE FROM ")
+	if q.FromTable == nil {
+		buf.WriteString("NULL")
+	} else {
+		err = q.FromTable.AppendSQL("", buf, args, params)
+		if err != nil {
+			return err
+		}
+		alias := q.FromTable.GetAlias()
+		if alias != "" {
+			buf.WriteString(" AS ")
+			buf.WriteString(alias)
+		}
+	}
+	// USING
+	if q.UsingTable != nil {
+		buf.WriteString(" USING ")
+		switch v := q.UsingTable.(type) {
+		case Query:
+			buf.WriteString("(")
+			err = v.AppendSQL("", buf, args, nil)
+			if err != nil {
+				return err
+			}
+			buf.WriteString(")")
+		default:
+			err = v.AppendSQL("", buf, args, nil)
+			if err != nil {
+				return err
+			}
+		}
+		alias := q.UsingTable.GetAlias()
+		if alias != "" {
+			buf.WriteString(" AS ")
+			buf.WriteString(alias)
+		}
+	}
+	// JOIN
+	if len(q.JoinTables) > 0 {
+		buf.WriteString(" ")
+		err = q.JoinTables.AppendSQL("", buf, args, nil)
+		if err != nil {
+			return err
+		}
+	}
+	// WHERE
+	if len(q.WherePredicate.Predicates) > 0 {
+		buf.WriteString(" WHERE ")
+		q.WherePredicate.Toplevel = true
+		err = q.WherePredicate.AppendSQLExclude("", buf, args, nil, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (q PostgresDeleteQuery) ToSQL() (query string, args []interface{}, params map[string]int, err error) {
+	buf := bufpool.Get().(*bytes.Buffer)
+	defer func() {
+		buf.Reset()
+		bufpool.Put(buf)
+	}()
+	params = make(map[string]int)
+	err = q.AppendSQL("", buf, &args, params)
+	if err != nil {
+		return query, args, params, err
+	}
+	query = buf.String()
+	return query, args, params, nil
+}
+
+func (q PostgresDeleteQuery) SetFetchableFields(fields []Field) (Query, error) {
+	return nil, ErrUnsupported
+}
+
+func (q PostgresDeleteQuery) GetFetchableFields() ([]Field, error) {
+	return nil, ErrUnsupported
+}
+
+func (q PostgresDeleteQuery) Dialect() string { return "postgres" }
+
+func (_ PostgresDialect) DeleteWith(ctes ...CTE) PostgresDeleteQuery {
+	return PostgresDeleteQuery{CTEs: ctes}
+}
+
+func (_ PostgresDialect) DeleteFrom(table BaseTable) PostgresDeleteQuery {
+	return PostgresDeleteQuery{FromTable: table}
+}
+
+func (q PostgresDeleteQuery) With(ctes ...CTE) PostgresDeleteQuery {
+	q.CTEs = append(q.CTEs, ctes...)
+	return q
+}
+
+func (q PostgresDeleteQuery) DeleteFrom(table BaseTable) PostgresDeleteQuery {
+	q.FromTable = table
+	return q
+}
+
+func (q PostgresDeleteQuery) Using(table Table) PostgresDeleteQuery {
+	q.UsingTable = table
+	return q
+}
+
+func (q PostgresDeleteQuery) Join(table Table, predicate Predicate, predicates ...Predicate) PostgresDeleteQuery {
+	predicates = append([]Predicate{predicate}, predicates...)
+	q.JoinTables = append(q.JoinTables, Join(table, predicates...))
+	return q
+}
+
+func (q PostgresDeleteQuery) LeftJoin(table Table, predicate Predicate, predicates ...Predicate) PostgresDeleteQuery {
+	predicates = append([]Predicate{predicate}, predicates...)
+	q.JoinTables = append(q.JoinTables, LeftJoin(table, predicates...))
+	return q
+}
+
+func (q PostgresDeleteQuery) Where(predicates ...Predicate) PostgresDeleteQuery {
+	q.WherePredicate.Predicates = append(q.WherePredicate.Predicates, predicates...)
+	return q
+}
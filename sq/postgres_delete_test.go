@@ -0,0 +1,49 @@
+package sq
+
+import (
+	"testing"
+
+	"github.com/bokwoon95/pagemanager/testutil"
+)
+
+func TestPostgresDeleteQuery_ToSQL(t *testing.T) {
+	type USERS struct {
+		TableInfo
+		USER_ID NumberField
+		NAME    StringField
+		EMAIL   StringField
+		AGE     NumberField
+	}
+	u := USERS{TableInfo: TableInfo{Schema: "db1", Alias: "u"}}
+	ReflectTable(&u)
+
+	assert := func(t *testing.T, q PostgresDeleteQuery, wantQuery string, wantArgs []interface{}) {
+		is := testutil.New(t, testutil.Parallel, testutil.FailFast)
+		var _ Query = q
+		gotQuery, gotArgs, _, err := q.ToSQL()
+		is.NoErr(err)
+		is.Equal("postgres", q.Dialect())
+		is.Equal(wantQuery, gotQuery)
+		is.Equal(wantArgs, gotArgs)
+	}
+	t.Run("empty", func(t *testing.T) {
+		q := PostgresDeleteQuery{}
+		wantQuery := "DELETE FROM NULL"
+		assert(t, q, wantQuery, nil)
+	})
+	t.Run("From", func(t *testing.T) {
+		u := USERS{TableInfo: TableInfo{Schema: "db1"}}
+		ReflectTable(&u)
+		q := Postgres.DeleteFrom(u).Where(u.USER_ID.In([]int{1, 2}))
+		wantQuery := "DELETE FROM db1.users WHERE users.user_id IN (?, ?)"
+		wantArgs := []interface{}{1, 2}
+		assert(t, q, wantQuery, wantArgs)
+	})
+	t.Run("Using", func(t *testing.T) {
+		u2 := USERS{TableInfo: TableInfo{Schema: "db1", Alias: "u2"}}
+		ReflectTable(&u2)
+		q := Postgres.DeleteFrom(u).Using(u2).Where(u.EMAIL.Eq(u2.EMAIL), u.USER_ID.Gt(u2.USER_ID))
+		wantQuery := "DELETE FROM db1.users AS u USING db1.users AS u2 WHERE u.email = u2.email AND u.user_id > u2.user_id"
+		assert(t, q, wantQuery, nil)
+	})
+}
@@ -0,0 +1,81 @@
+package sq
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bokwoon95/pagemanager/testutil"
+)
+
+func TestPostgresInsertQuery_ToSQL(t *testing.T) {
+	type USERS struct {
+		TableInfo
+		USER_ID NumberField
+		NAME    StringField
+		EMAIL   StringField
+		AGE     NumberField
+	}
+	u := USERS{TableInfo: TableInfo{Schema: "db1", Alias: "u"}}
+	ReflectTable(&u)
+
+	assert := func(t *testing.T, q PostgresInsertQuery, wantQuery string, wantArgs []interface{}) {
+		is := testutil.New(t, testutil.Parallel, testutil.FailFast)
+		var _ Query = q
+		gotQuery, gotArgs, _, err := q.ToSQL()
+		is.NoErr(err)
+		is.Equal("postgres", q.Dialect())
+		is.Equal(wantQuery, gotQuery)
+		is.Equal(wantArgs, gotArgs)
+	}
+	t.Run("empty", func(t *testing.T) {
+		q := PostgresInsertQuery{}
+		wantQuery := "INSERT INTO NULL"
+		assert(t, q, wantQuery, nil)
+	})
+	t.Run("Valuesx", func(t *testing.T) {
+		q := Postgres.InsertInto(u).Valuesx(func(col *Column) error {
+			col.SetString(u.NAME, "Bob")
+			col.SetInt(u.AGE, 22)
+			return nil
+		})
+		wantQuery := "INSERT INTO db1.users AS u (name, age) VALUES (?, ?)"
+		wantArgs := []interface{}{"Bob", 22}
+		assert(t, q, wantQuery, wantArgs)
+	})
+	t.Run("upsert", func(t *testing.T) {
+		q := Postgres.InsertInto(u).
+			Columns(u.USER_ID, u.NAME, u.EMAIL).
+			Values(1, "a", "a@email.com").
+			OnConflict(u.USER_ID).
+			DoUpdateSet(SetExcluded(u.NAME), SetExcluded(u.EMAIL)).
+			Where(u.EMAIL.IsNotNull())
+		wantQuery := "INSERT INTO db1.users AS u" +
+			" (user_id, name, email)" +
+			" VALUES (?, ?, ?)" +
+			" ON CONFLICT (user_id)" +
+			" DO UPDATE SET name = EXCLUDED.name, email = EXCLUDED.email" +
+			" WHERE u.email IS NOT NULL"
+		wantArgs := []interface{}{1, "a", "a@email.com"}
+		assert(t, q, wantQuery, wantArgs)
+	})
+	t.Run("INSERT SELECT DO NOTHING", func(t *testing.T) {
+		q := Postgres.InsertInto(u).
+			Columns(u.USER_ID, u.NAME).
+			Select(Postgres.Select(u.USER_ID, u.NAME).From(u).Where(u.AGE.GtInt(30))).
+			OnConflict().DoNothing()
+		wantQuery := "INSERT INTO db1.users AS u" +
+			" (user_id, name)" +
+			" SELECT u.user_id, u.name FROM db1.users AS u WHERE u.age > ?" +
+			" ON CONFLICT DO NOTHING"
+		wantArgs := []interface{}{30}
+		assert(t, q, wantQuery, wantArgs)
+	})
+	t.Run("FetchableFields", func(t *testing.T) {
+		is := testutil.New(t, testutil.Parallel, testutil.FailFast)
+		q := Postgres.InsertInto(u)
+		_, err := q.GetFetchableFields()
+		is.True(errors.Is(err, ErrUnsupported))
+		_, err = q.SetFetchableFields(Fields{})
+		is.True(errors.Is(err, ErrUnsupported))
+	})
+}
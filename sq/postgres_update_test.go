@@ -0,0 +1,49 @@
+package sq
+
+import (
+	"testing"
+
+	"github.com/bokwoon95/pagemanager/testutil"
+)
+
+func TestPostgresUpdateQuery_ToSQL(t *testing.T) {
+	type USERS struct {
+		TableInfo
+		USER_ID NumberField
+		NAME    StringField
+		EMAIL   StringField
+		AGE     NumberField
+	}
+	u := USERS{TableInfo: TableInfo{Schema: "db1", Alias: "u"}}
+	ReflectTable(&u)
+
+	assert := func(t *testing.T, q PostgresUpdateQuery, wantQuery string, wantArgs []interface{}) {
+		is := testutil.New(t, testutil.Parallel, testutil.FailFast)
+		var _ Query = q
+		gotQuery, gotArgs, _, err := q.ToSQL()
+		is.NoErr(err)
+		is.Equal("postgres", q.Dialect())
+		is.Equal(wantQuery, gotQuery)
+		is.Equal(wantArgs, gotArgs)
+	}
+	t.Run("empty", func(t *testing.T) {
+		q := PostgresUpdateQuery{}
+		wantQuery := "UPDATE NULL"
+		assert(t, q, wantQuery, nil)
+	})
+	t.Run("Set", func(t *testing.T) {
+		q := Postgres.Update(u).Set(u.NAME.SetString("bob"), u.AGE.SetInt(5)).Where(u.USER_ID.EqInt(1))
+		wantQuery := "UPDATE db1.users AS u SET name = ?, age = ? WHERE u.user_id = ?"
+		wantArgs := []interface{}{"bob", 5, 1}
+		assert(t, q, wantQuery, wantArgs)
+	})
+	t.Run("Setx", func(t *testing.T) {
+		q := Postgres.Update(u).Setx(func(col *Column) error {
+			col.SetString(u.EMAIL, "bob@email.com")
+			return nil
+		}).Where(u.USER_ID.EqInt(1))
+		wantQuery := "UPDATE db1.users AS u SET email = ? WHERE u.user_id = ?"
+		wantArgs := []interface{}{"bob@email.com", 1}
+		assert(t, q, wantQuery, wantArgs)
+	})
+}
@@ -0,0 +1,255 @@
+package pagemanager
+
+import (
+	"crypto/subtle"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/bokwoon95/pagemanager/cryptoutil"
+	"github.com/bokwoon95/pagemanager/hyperforms"
+	hy "github.com/bokwoon95/pagemanager/hypergo"
+)
+
+const superadminURLPrefix = "/pm-superadmin/"
+
+// superadminUserID is the UserID of the superadmin's sessions.
+const superadminUserID = "superadmin"
+
+func (pm *PageManager) superadmin(w http.ResponseWriter, r *http.Request) {
+	switch strings.TrimPrefix(r.URL.Path, superadminURLPrefix) {
+	case "":
+		pm.superadminDashboard(w, r)
+	case "setup":
+		pm.superadminSetup(w, r)
+	case "login":
+		pm.superadminLogin(w, r)
+	case "logout":
+		pm.superadminLogout(w, r)
+	case "users":
+		pm.superadminUsers(w, r)
+	case "themes":
+		pm.superadminThemes(w, r)
+	case "export":
+		pm.superadminExport(w, r)
+	case "import":
+		pm.superadminImport(w, r)
+	default:
+		pm.notFound.ServeHTTP(w, r)
+	}
+}
+
+// isSuperadmin reports whether the request belongs to a superadmin session.
+func isSuperadmin(r *http.Request) bool {
+	session := SessionFromContext(r.Context())
+	return session != nil && session.UserID == superadminUserID
+}
+
+func (pm *PageManager) superadminSetup(w http.ResponseWriter, r *http.Request) {
+	metadata, err := pm.passwords.GetPasswordMetadata()
+	if err != nil {
+		pm.errHandler(w, r, err)
+		return
+	}
+	if metadata != nil {
+		http.Redirect(w, r, superadminURLPrefix+"login", http.StatusSeeOther)
+		return
+	}
+	form := hyperforms.New(w, r)
+	loginID := form.Text("loginID", "")
+	loginID.Set("#loginID[required][autocomplete=username]", nil)
+	password := form.Input("password", "password", "")
+	password.Set("#password[required][autocomplete=new-password]", nil)
+	confirmPassword := form.Input("password", "confirmPassword", "")
+	confirmPassword.Set("#confirmPassword[required][autocomplete=new-password]", nil)
+	if r.Method == http.MethodPost {
+		err = r.ParseForm()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		loginID.Validate(hyperforms.Required, hyperforms.LengthLe(64))
+		password.Validate(hyperforms.Required, hyperforms.LengthGe(8))
+		if password.Value() != confirmPassword.Value() {
+			form.AddErrMsgs("passwords do not match")
+		}
+		if len(form.ErrMsgs) > 0 || len(form.InputErrMsgs) > 0 {
+			form.Redirect(w, r, r.URL.Path)
+			return
+		}
+		err = pm.pwbox.SetPassword([]byte(password.Value()))
+		if err != nil {
+			pm.errHandler(w, r, err)
+			return
+		}
+		err = pm.passwords.SetLoginID(loginID.Value())
+		if err != nil {
+			pm.errHandler(w, r, err)
+			return
+		}
+		err = pm.startSession(w, r, superadminUserID)
+		if err != nil {
+			pm.errHandler(w, r, err)
+			return
+		}
+		http.Redirect(w, r, superadminURLPrefix, http.StatusSeeOther)
+		return
+	}
+	form.SetAttribute("method", "post")
+	form.AppendElements(
+		formErrors(form.ErrMsgs),
+		hy.H("label[for=loginID]", nil, hy.Txt("Login ID")), formErrors(loginID.ErrMsgs()), loginID,
+		hy.H("label[for=password]", nil, hy.Txt("Password")), formErrors(password.ErrMsgs()), password,
+		hy.H("label[for=confirmPassword]", nil, hy.Txt("Confirm password")), confirmPassword,
+		hy.H("button[type=submit]", nil, hy.Txt("Set up")),
+	)
+	err = renderPage(w, "Superadmin setup", hy.H("h1", nil, hy.Txt("Superadmin setup")), form)
+	if err != nil {
+		pm.errHandler(w, r, err)
+		return
+	}
+}
+
+func (pm *PageManager) superadminLogin(w http.ResponseWriter, r *http.Request) {
+	metadata, err := pm.passwords.GetPasswordMetadata()
+	if err != nil {
+		pm.errHandler(w, r, err)
+		return
+	}
+	if metadata == nil {
+		http.Redirect(w, r, superadminURLPrefix+"setup", http.StatusSeeOther)
+		return
+	}
+	form := hyperforms.New(w, r)
+	loginID := form.Text("loginID", "")
+	loginID.Set("#loginID[required][autocomplete=username]", nil)
+	password := form.Input("password", "password", "")
+	password.Set("#password[required][autocomplete=current-password]", nil)
+	if r.Method == http.MethodPost {
+		err = r.ParseForm()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		storedLoginID, err := pm.passwords.GetLoginID()
+		if err != nil {
+			pm.errHandler(w, r, err)
+			return
+		}
+		// the login ID is checked first so that a wrong one never unlocks
+		// the password box, but the password is still compared against its
+		// hash so that a wrong login ID takes as long to reject as a wrong
+		// password
+		if subtle.ConstantTimeCompare([]byte(loginID.Value()), []byte(storedLoginID)) != 1 {
+			_ = cryptoutil.CompareHashAndPassword(metadata.PasswordHash, []byte(password.Value()))
+			form.AddErrMsgs("invalid login ID or password")
+			form.Redirect(w, r, r.URL.Path)
+			return
+		}
+		err = pm.pwbox.EnterPassword([]byte(password.Value()))
+		if err != nil {
+			form.AddErrMsgs("invalid login ID or password")
+			form.Redirect(w, r, r.URL.Path)
+			return
+		}
+		err = pm.startSession(w, r, superadminUserID)
+		if err != nil {
+			pm.errHandler(w, r, err)
+			return
+		}
+		http.Redirect(w, r, superadminURLPrefix, http.StatusSeeOther)
+		return
+	}
+	form.SetAttribute("method", "post")
+	form.AppendElements(
+		formErrors(form.ErrMsgs),
+		hy.H("label[for=loginID]", nil, hy.Txt("Login ID")), loginID,
+		hy.H("label[for=password]", nil, hy.Txt("Password")), password,
+		hy.H("button[type=submit]", nil, hy.Txt("Log in")),
+	)
+	err = renderPage(w, "Superadmin login", hy.H("h1", nil, hy.Txt("Superadmin login")), form)
+	if err != nil {
+		pm.errHandler(w, r, err)
+		return
+	}
+}
+
+func (pm *PageManager) superadminLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	var err error
+	if r.FormValue("all") != "" && isSuperadmin(r) {
+		clearSessionCookie(w)
+		err = pm.sessions.RevokeUserSessions(superadminUserID)
+	} else {
+		err = pm.endSession(w, r)
+	}
+	if err != nil {
+		pm.errHandler(w, r, err)
+		return
+	}
+	http.Redirect(w, r, superadminURLPrefix+"login", http.StatusSeeOther)
+}
+
+func (pm *PageManager) superadminDashboard(w http.ResponseWriter, r *http.Request) {
+	if !isSuperadmin(r) {
+		http.Redirect(w, r, superadminURLPrefix+"login", http.StatusSeeOther)
+		return
+	}
+	loginID, err := pm.passwords.GetLoginID()
+	if err != nil {
+		pm.errHandler(w, r, err)
+		return
+	}
+	err = renderPage(w, "Superadmin",
+		hy.H("h1", nil, hy.Txt("Superadmin")),
+		hy.H("p", nil, hy.Txt("Logged in as", loginID)),
+		hy.H("p", nil, hy.H("a", hy.Attr{"href": superadminURLPrefix + "users"}, hy.Txt("Users"))),
+		hy.H("p", nil, hy.H("a", hy.Attr{"href": superadminURLPrefix + "themes"}, hy.Txt("Themes"))),
+		hy.H("p", nil, hy.H("a", hy.Attr{"href": pagesURL}, hy.Txt("Pages"))),
+		hy.H("p", nil,
+			hy.H("a", hy.Attr{"href": superadminURLPrefix + "export"}, hy.Txt("Export site")), hy.Txt(" "),
+			hy.H("a", hy.Attr{"href": superadminURLPrefix + "import"}, hy.Txt("Import site")),
+		),
+		hy.H("form[method=post]", hy.Attr{"action": superadminURLPrefix + "logout"},
+			hy.H("button[type=submit]", nil, hy.Txt("Log out")),
+			hy.H("button[type=submit][name=all][value=1]", nil, hy.Txt("Log out everywhere")),
+		),
+	)
+	if err != nil {
+		pm.errHandler(w, r, err)
+		return
+	}
+}
+
+func formErrors(errMsgs []string) hy.Element {
+	if len(errMsgs) == 0 {
+		return nil
+	}
+	var list hy.Elements
+	for _, errMsg := range errMsgs {
+		list.Append("li", nil, hy.Txt(errMsg))
+	}
+	return hy.H("ul.errors", nil, list)
+}
+
+func renderPage(w http.ResponseWriter, title string, body ...hy.Element) error {
+	page := hy.H("html[lang=en]", nil,
+		hy.H("head", nil,
+			hy.H("meta[charset=utf-8]", nil),
+			hy.H("meta[name=viewport]", hy.Attr{"content": "width=device-width, initial-scale=1"}),
+			hy.H("title", nil, hy.Txt(title)),
+		),
+		hy.H("body", nil, body...),
+	)
+	output, err := hy.Marshal(hy.AllowTags("head"), page)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, err = io.WriteString(w, "<!DOCTYPE html>"+string(output))
+	return err
+}
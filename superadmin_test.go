@@ -0,0 +1,88 @@
+package pagemanager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/bokwoon95/pagemanager/testutil"
+)
+
+func Test_superadmin(t *testing.T) {
+	is := testutil.New(t)
+	db := newTestDB(t)
+	pm, err := New(DataDB(db, "sqlite3"))
+	is.NoErr(err)
+	do := func(pm *PageManager, method, path string, form url.Values, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		if form != nil {
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		rr := httptest.NewRecorder()
+		pm.ServeHTTP(rr, r)
+		return rr
+	}
+	sessionCookie := func(rr *httptest.ResponseRecorder) *http.Cookie {
+		for _, c := range rr.Result().Cookies() {
+			if c.Name == sessionCookieName {
+				return c
+			}
+		}
+		return nil
+	}
+
+	// before setup, everything leads to the setup page
+	rr := do(pm, "GET", "/pm-superadmin/", nil)
+	is.Equal("/pm-superadmin/login", rr.Header().Get("Location"))
+	rr = do(pm, "GET", "/pm-superadmin/login", nil)
+	is.Equal("/pm-superadmin/setup", rr.Header().Get("Location"))
+	rr = do(pm, "GET", "/pm-superadmin/setup", nil)
+	is.Equal(http.StatusOK, rr.Code)
+
+	// mismatched passwords are rejected
+	rr = do(pm, "POST", "/pm-superadmin/setup", url.Values{
+		"loginID": {"admin"}, "password": {"password123"}, "confirmPassword": {"password456"},
+	})
+	is.Equal("/pm-superadmin/setup", rr.Header().Get("Location"))
+	is.True(sessionCookie(rr) == nil)
+
+	rr = do(pm, "POST", "/pm-superadmin/setup", url.Values{
+		"loginID": {"admin"}, "password": {"password123"}, "confirmPassword": {"password123"},
+	})
+	is.Equal("/pm-superadmin/", rr.Header().Get("Location"))
+	cookie := sessionCookie(rr)
+	is.True(cookie != nil)
+	rr = do(pm, "GET", "/pm-superadmin/", nil, cookie)
+	is.Equal(http.StatusOK, rr.Code)
+	is.True(strings.Contains(rr.Body.String(), "admin"))
+	rr = do(pm, "GET", "/pm-superadmin/setup", nil)
+	is.Equal("/pm-superadmin/login", rr.Header().Get("Location"))
+
+	// a restarted PageManager is locked until the password is entered again
+	pm, err = New(DataDB(db, "sqlite3"))
+	is.NoErr(err)
+	rr = do(pm, "GET", "/pm-superadmin/", nil, cookie)
+	is.Equal("/pm-superadmin/login", rr.Header().Get("Location"))
+	rr = do(pm, "POST", "/pm-superadmin/login", url.Values{"loginID": {"admin"}, "password": {"wrong password"}})
+	is.Equal("/pm-superadmin/login", rr.Header().Get("Location"))
+	is.True(sessionCookie(rr) == nil)
+	// the right password with the wrong login ID leaves the box locked
+	rr = do(pm, "POST", "/pm-superadmin/login", url.Values{"loginID": {"root"}, "password": {"password123"}})
+	is.True(sessionCookie(rr) == nil)
+	is.True(!pm.pwbox.PasswordEntered())
+	rr = do(pm, "POST", "/pm-superadmin/login", url.Values{"loginID": {"admin"}, "password": {"password123"}})
+	is.Equal("/pm-superadmin/", rr.Header().Get("Location"))
+	cookie = sessionCookie(rr)
+	is.True(cookie != nil)
+	rr = do(pm, "GET", "/pm-superadmin/", nil, cookie)
+	is.Equal(http.StatusOK, rr.Code)
+
+	rr = do(pm, "POST", "/pm-superadmin/logout", url.Values{}, cookie)
+	is.Equal("/pm-superadmin/login", rr.Header().Get("Location"))
+	is.Equal(-1, sessionCookie(rr).MaxAge)
+}
@@ -31,3 +31,160 @@ func new_KEYS(alias string) pm_KEYS {
 	_ = sq.ReflectTable(&tbl)
 	return tbl
 }
+
+// new_SESSION_KEYS returns the table of keys that session cookies are signed
+// with. It has the same columns as pm_keys, but its keys are stored
+// unencrypted so that sessions can be checked before the superadmin has
+// unlocked the site.
+func new_SESSION_KEYS(alias string) pm_KEYS {
+	tbl := pm_KEYS{TableInfo: sq.TableInfo{Alias: alias}}
+	tbl.TableInfo.Name = "pm_session_keys"
+	_ = sq.ReflectTable(&tbl)
+	return tbl
+}
+
+type pm_PAGES struct {
+	sq.TableInfo
+	URL                  sq.StringField `sq:"type=TEXT misc=PRIMARY_KEY"`
+	THEME_PATH           sq.StringField
+	TEMPLATE_CONFIG_PATH sq.StringField
+	STATUS               sq.NumberField
+	PUBLISH_AT           sq.TimeField
+	REDIRECT_URL         sq.StringField
+}
+
+func new_PAGES(alias string) pm_PAGES {
+	tbl := pm_PAGES{TableInfo: sq.TableInfo{Alias: alias}}
+	tbl.TableInfo.Name = "pm_pages"
+	_ = sq.ReflectTable(&tbl)
+	return tbl
+}
+
+type pm_VALUES struct {
+	sq.TableInfo `sq:"unique=locale_code,namespace,name"`
+	LOCALE_CODE  sq.StringField
+	NAMESPACE    sq.StringField
+	NAME         sq.StringField
+	VALUE        sq.StringField
+}
+
+func new_VALUES(alias string) pm_VALUES {
+	tbl := pm_VALUES{TableInfo: sq.TableInfo{Alias: alias}}
+	tbl.TableInfo.Name = "pm_values"
+	_ = sq.ReflectTable(&tbl)
+	return tbl
+}
+
+type pm_ROWS struct {
+	sq.TableInfo `sq:"unique=locale_code,namespace,name,row_num"`
+	LOCALE_CODE  sq.StringField
+	NAMESPACE    sq.StringField
+	NAME         sq.StringField
+	ROW_NUM      sq.NumberField
+	DATA         sq.JSONField
+}
+
+func new_ROWS(alias string) pm_ROWS {
+	tbl := pm_ROWS{TableInfo: sq.TableInfo{Alias: alias}}
+	tbl.TableInfo.Name = "pm_rows"
+	_ = sq.ReflectTable(&tbl)
+	return tbl
+}
+
+type pm_DRAFTS struct {
+	sq.TableInfo
+	LOCALE_CODE sq.StringField
+	NAMESPACE   sq.StringField
+	NAME        sq.StringField
+	IS_ROWS     sq.BooleanField
+	VALUE       sq.StringField
+}
+
+func new_DRAFTS(alias string) pm_DRAFTS {
+	tbl := pm_DRAFTS{TableInfo: sq.TableInfo{Alias: alias}}
+	tbl.TableInfo.Name = "pm_drafts"
+	_ = sq.ReflectTable(&tbl)
+	return tbl
+}
+
+type pm_REVISIONS struct {
+	sq.TableInfo
+	REVISION_ID sq.NumberField `sq:"type=INTEGER misc=PRIMARY_KEY"`
+	USER_ID     sq.StringField
+	LOCALE_CODE sq.StringField
+	NAMESPACE   sq.StringField
+	NAME        sq.StringField
+	IS_ROWS     sq.BooleanField
+	OLD_VALUE   sq.StringField
+	NEW_VALUE   sq.StringField
+	CREATED_AT  sq.TimeField
+}
+
+func new_REVISIONS(alias string) pm_REVISIONS {
+	tbl := pm_REVISIONS{TableInfo: sq.TableInfo{Alias: alias}}
+	tbl.TableInfo.Name = "pm_revisions"
+	_ = sq.ReflectTable(&tbl)
+	return tbl
+}
+
+type pm_SESSIONS struct {
+	sq.TableInfo
+	SESSION_ID     sq.StringField `sq:"type=TEXT misc=PRIMARY_KEY"`
+	USER_ID        sq.StringField
+	CREATED_AT     sq.TimeField
+	LAST_ACTIVE_AT sq.TimeField
+}
+
+func new_SESSIONS(alias string) pm_SESSIONS {
+	tbl := pm_SESSIONS{TableInfo: sq.TableInfo{Alias: alias}}
+	tbl.TableInfo.Name = "pm_sessions"
+	_ = sq.ReflectTable(&tbl)
+	return tbl
+}
+
+type pm_USERS struct {
+	sq.TableInfo
+	USER_ID          sq.StringField `sq:"type=TEXT misc=PRIMARY_KEY"`
+	LOGIN_ID         sq.StringField `sq:"type=TEXT misc=UNIQUE"`
+	DISPLAY_NAME     sq.StringField
+	PASSWORD_HASH    sq.StringField
+	STATUS           sq.NumberField
+	ROLE             sq.StringField
+	TOKEN_HASH       sq.StringField
+	TOKEN_EXPIRES_AT sq.TimeField
+	CREATED_AT       sq.TimeField
+}
+
+func new_USERS(alias string) pm_USERS {
+	tbl := pm_USERS{TableInfo: sq.TableInfo{Alias: alias}}
+	tbl.TableInfo.Name = "pm_users"
+	_ = sq.ReflectTable(&tbl)
+	return tbl
+}
+
+type pm_GRANTS struct {
+	sq.TableInfo
+	USER_ID          sq.StringField
+	NAMESPACE_PREFIX sq.StringField
+}
+
+func new_GRANTS(alias string) pm_GRANTS {
+	tbl := pm_GRANTS{TableInfo: sq.TableInfo{Alias: alias}}
+	tbl.TableInfo.Name = "pm_grants"
+	_ = sq.ReflectTable(&tbl)
+	return tbl
+}
+
+type pm_LOCALES struct {
+	sq.TableInfo
+	LOCALE_CODE  sq.StringField `sq:"type=TEXT misc=PRIMARY_KEY"`
+	DISPLAY_NAME sq.StringField
+	IS_DEFAULT   sq.BooleanField
+}
+
+func new_LOCALES(alias string) pm_LOCALES {
+	tbl := pm_LOCALES{TableInfo: sq.TableInfo{Alias: alias}}
+	tbl.TableInfo.Name = "pm_locales"
+	_ = sq.ReflectTable(&tbl)
+	return tbl
+}
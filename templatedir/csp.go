@@ -0,0 +1,129 @@
+package templatedir
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"html/template"
+	"sort"
+	"strings"
+)
+
+// baseCSP is the policy that every template starts from. Directives from the
+// theme, the template and the ServeOptions are added on top of it.
+var baseCSP = map[string][]string{
+	"default-src": {"'self'"},
+	"script-src":  {"'self'"},
+	"style-src":   {"'self'"},
+	"img-src":     {"'self'"},
+	"object-src":  {"'none'"},
+	"base-uri":    {"'self'"},
+}
+
+// newNonce returns a random base64 nonce for the script tags of a single
+// response.
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// mergeCSP adds the sources in src to the directives in dst, skipping
+// sources that a directive already has.
+func mergeCSP(dst, src map[string][]string) {
+	for directive, sources := range src {
+		for _, source := range sources {
+			if !containsString(dst[directive], source) {
+				dst[directive] = append(dst[directive], source)
+			}
+		}
+		if _, ok := dst[directive]; !ok {
+			dst[directive] = nil
+		}
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// unmarshalCSP converts a ContentSecurityPolicy object exported from goja,
+// which maps directives to arrays of sources, into a map[string][]string.
+func unmarshalCSP(v interface{}) map[string][]string {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	csp := make(map[string][]string)
+	for directive, __sources__ := range m {
+		var sources []string
+		switch __sources__ := __sources__.(type) {
+		case []interface{}:
+			for _, __source__ := range __sources__ {
+				if source, ok := __source__.(string); ok {
+					sources = append(sources, source)
+				}
+			}
+		case string:
+			sources = strings.Fields(__sources__)
+		}
+		csp[directive] = sources
+	}
+	return csp
+}
+
+// buildCSP returns the Content-Security-Policy of a response: baseCSP with
+// the sources in csp added to it. Fetch directives (those ending in -src)
+// always allow 'self', 'none' is dropped from any directive that was given
+// other sources, and script-src also allows the nonce.
+func buildCSP(csp map[string][]string, nonce string) string {
+	policy := make(map[string][]string)
+	mergeCSP(policy, baseCSP)
+	for directive := range csp {
+		if _, ok := policy[directive]; !ok && strings.HasSuffix(directive, "-src") {
+			policy[directive] = []string{"'self'"}
+		}
+	}
+	mergeCSP(policy, csp)
+	if nonce != "" {
+		mergeCSP(policy, map[string][]string{"script-src": {"'nonce-" + nonce + "'"}})
+	}
+	directives := make([]string, 0, len(policy))
+	for directive := range policy {
+		directives = append(directives, directive)
+	}
+	sort.Strings(directives)
+	buf := &strings.Builder{}
+	for _, directive := range directives {
+		sources := policy[directive]
+		if len(sources) > 1 && sources[0] == "'none'" {
+			sources = sources[1:]
+		}
+		if buf.Len() > 0 {
+			buf.WriteString("; ")
+		}
+		buf.WriteString(directive)
+		for _, source := range sources {
+			buf.WriteString(" " + source)
+		}
+	}
+	return buf.String()
+}
+
+// ContentSecurityPolicy returns a meta tag holding the page's
+// Content-Security-Policy. It is empty if the policy was already sent in the
+// Content-Security-Policy header or if CSP is disabled, so templates can call
+// it unconditionally in their <head>.
+func (data templateData) ContentSecurityPolicy() (template.HTML, error) {
+	if data.cspDisabled || data.cspHeaderSent {
+		return "", nil
+	}
+	return template.HTML(`<meta http-equiv="Content-Security-Policy" content="` + template.HTMLEscapeString(buildCSP(data.csp, data.nonce)) + `">`), nil
+}
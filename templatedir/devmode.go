@@ -0,0 +1,124 @@
+package templatedir
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// watchInterval is how often the fs.FS is polled for changes while there are
+// pages listening for reloads.
+const watchInterval = 500 * time.Millisecond
+
+// watcher polls the fs.FS of a TemplateDir in DevMode. Polling only happens
+// while at least one page is subscribed to the reload endpoint, so a
+// TemplateDir with no open pages does no work. Changes are detected through
+// the modification times and sizes reported by the fs.FS, which os.DirFS
+// provides.
+type watcher struct {
+	mu          sync.Mutex
+	subscribers map[chan struct{}]struct{}
+	stop        chan struct{}
+}
+
+// subscribe returns a channel that receives a value whenever the fs.FS
+// changes, starting the poller if this is the first subscriber.
+func (dir *TemplateDir) subscribe() chan struct{} {
+	dir.watcher.mu.Lock()
+	defer dir.watcher.mu.Unlock()
+	ch := make(chan struct{}, 1)
+	if dir.watcher.subscribers == nil {
+		dir.watcher.subscribers = make(map[chan struct{}]struct{})
+	}
+	dir.watcher.subscribers[ch] = struct{}{}
+	if dir.watcher.stop == nil {
+		dir.watcher.stop = make(chan struct{})
+		go dir.poll(dir.watcher.stop)
+	}
+	return ch
+}
+
+// unsubscribe removes a subscriber, stopping the poller if it was the last.
+func (dir *TemplateDir) unsubscribe(ch chan struct{}) {
+	dir.watcher.mu.Lock()
+	defer dir.watcher.mu.Unlock()
+	delete(dir.watcher.subscribers, ch)
+	if len(dir.watcher.subscribers) == 0 && dir.watcher.stop != nil {
+		close(dir.watcher.stop)
+		dir.watcher.stop = nil
+	}
+}
+
+func (dir *TemplateDir) poll(stop chan struct{}) {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	prev := dir.snapshot()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		curr := dir.snapshot()
+		if curr == prev {
+			continue
+		}
+		prev = curr
+		_ = dir.Reload()
+		dir.watcher.mu.Lock()
+		for ch := range dir.watcher.subscribers {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+		dir.watcher.mu.Unlock()
+	}
+}
+
+// snapshot returns a fingerprint of the name, size and modification time of
+// every file in the fs.FS.
+func (dir *TemplateDir) snapshot() string {
+	var buf []byte
+	_ = fs.WalkDir(dir.fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		buf = append(buf, fmt.Sprintf("%s %d %d\n", name, info.Size(), info.ModTime().UnixNano())...)
+		return nil
+	})
+	return string(buf)
+}
+
+// reload is a Server-Sent Events endpoint that sends a reload event to pages
+// in DevMode whenever their theme changes. It is not found outside DevMode.
+func (dir *TemplateDir) reload(w http.ResponseWriter, r *http.Request) {
+	if !dir.devMode {
+		dir.assetNotFound(w, r)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	ch := dir.subscribe()
+	defer dir.unsubscribe(ch)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": watching for changes\n\n")
+	flusher.Flush()
+	select {
+	case <-r.Context().Done():
+	case <-ch:
+		fmt.Fprint(w, "event: reload\ndata: {}\n\n")
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,11 @@
+document.addEventListener("DOMContentLoaded", function () {
+  const reloadURL = window.ENV("ReloadURL");
+  if (!reloadURL) {
+    return;
+  }
+  const source = new EventSource(reloadURL);
+  source.addEventListener("reload", function () {
+    source.close();
+    window.location.reload();
+  });
+});
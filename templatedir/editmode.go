@@ -0,0 +1,354 @@
+package templatedir
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	hy "github.com/bokwoon95/pagemanager/hypergo"
+)
+
+// maxSaveBytes is the largest save payload that will be accepted from
+// editmode.js.
+const maxSaveBytes = 8 << 20
+
+var (
+	localeCodeRegexp = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+	nameRegexp       = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_-]{0,63}$`)
+)
+
+// savePayload is the JSON body sent by editmode.js to the save endpoint.
+// Values and Rows are keyed by namespace, then by name.
+type savePayload struct {
+	// Namespace is the namespace of the page being edited. If it is set, the
+	// request must be allowed to edit it, just as for turning on EditMode.
+	Namespace  string
+	LocaleCode string
+	Values     map[string]map[string]string
+	Rows       map[string]map[string][]saveRow
+}
+
+// saveRow is a single row of a data-pm.row. Values holds the innerHTML of
+// every data-pm.row.key and Hrefs holds the href of every data-pm.row.href.
+type saveRow struct {
+	Values map[string]string
+	Hrefs  map[string]string
+}
+
+// namespaces returns every namespace that the payload writes to.
+func (payload savePayload) namespaces() []string {
+	var namespaces []string
+	for namespace := range payload.Values {
+		namespaces = append(namespaces, namespace)
+	}
+	for namespace := range payload.Rows {
+		if _, ok := payload.Values[namespace]; !ok {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	return namespaces
+}
+
+func validateNamespace(namespace string) error {
+	if namespace == "" {
+		return fmt.Errorf("namespace cannot be empty")
+	}
+	if len(namespace) > 256 {
+		return fmt.Errorf("namespace %.20q... is too long", namespace)
+	}
+	for _, c := range namespace {
+		if unicode.IsSpace(c) || unicode.IsControl(c) {
+			return fmt.Errorf("namespace %q contains whitespace or control characters", namespace)
+		}
+	}
+	return nil
+}
+
+func validateName(name string) error {
+	if !nameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid name %q", name)
+	}
+	return nil
+}
+
+func (dir *TemplateDir) validate(payload *savePayload) error {
+	if payload.LocaleCode != "" && !localeCodeRegexp.MatchString(payload.LocaleCode) {
+		return fmt.Errorf("invalid localeCode %q", payload.LocaleCode)
+	}
+	var err error
+	if payload.Namespace != "" {
+		if err = validateNamespace(payload.Namespace); err != nil {
+			return err
+		}
+	}
+	for namespace, values := range payload.Values {
+		if err = validateNamespace(namespace); err != nil {
+			return err
+		}
+		for name, value := range values {
+			if err = validateName(name); err != nil {
+				return err
+			}
+			values[name], err = hy.SanitizeHTML(dir.sanitizer, value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for namespace, rowsets := range payload.Rows {
+		if err = validateNamespace(namespace); err != nil {
+			return err
+		}
+		for name, rows := range rowsets {
+			if err = validateName(name); err != nil {
+				return err
+			}
+			for _, row := range rows {
+				for key, value := range row.Values {
+					if err = validateName(key); err != nil {
+						return err
+					}
+					row.Values[key], err = hy.SanitizeHTML(dir.sanitizer, value)
+					if err != nil {
+						return err
+					}
+				}
+				for key, href := range row.Hrefs {
+					if err = validateName(key); err != nil {
+						return err
+					}
+					if !dir.sanitizer("a", "href", href) {
+						return fmt.Errorf("unsafe href %q", href)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// authorize fails if the request may not edit pageNamespace (the namespace
+// of the page itself, if set) or any of the namespaces that a payload writes
+// to, such as the namespace of a theme shared with pages that an editor was
+// not granted. A payload is either written in full or not at all, so the
+// error lists every namespace that is not allowed.
+func (dir *TemplateDir) authorize(r *http.Request, pageNamespace string, namespaces []string) error {
+	var denied []string
+	if pageNamespace != "" && !dir.editPermission(r, pageNamespace) {
+		denied = append(denied, strconv.Quote(pageNamespace))
+	}
+	for _, namespace := range namespaces {
+		if namespace != pageNamespace && !dir.editPermission(r, namespace) {
+			denied = append(denied, strconv.Quote(namespace))
+		}
+	}
+	if len(denied) > 0 {
+		sort.Strings(denied)
+		return fmt.Errorf("not allowed to edit namespace %s", strings.Join(denied, ", "))
+	}
+	return nil
+}
+
+// save handles the POST requests made by editmode.js. The payload is
+// validated and sanitized in full before anything is written, and all writes
+// happen inside a single ValueStoreTx. Everything is saved as drafts, which
+// only become visible to the public once they are published. Nothing is saved
+// if the request may not edit any one of the namespaces in the payload.
+func (dir *TemplateDir) save(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if mediaType := r.Header.Get("Content-Type"); !strings.HasPrefix(mediaType, "application/json") {
+		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	var payload savePayload
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSaveBytes)).Decode(&payload)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = dir.validate(&payload)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = dir.authorize(r, payload.Namespace, payload.namespaces())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	err = dir.saveTx(r.Context(), payload)
+	if err != nil {
+		dir.assetErrHandler(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (dir *TemplateDir) saveTx(ctx context.Context, payload savePayload) (err error) {
+	tx, err := dir.store.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	for namespace, values := range payload.Values {
+		for name, value := range values {
+			err = tx.SetDraftValue(payload.LocaleCode, namespace, name, value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for namespace, rowsets := range payload.Rows {
+		for name, rows := range rowsets {
+			data := make([]map[string]interface{}, len(rows))
+			for i, row := range rows {
+				data[i] = make(map[string]interface{})
+				for key, value := range row.Values {
+					data[i][key] = value
+				}
+				for key, href := range row.Hrefs {
+					data[i][key] = href
+				}
+			}
+			err = tx.SetDraftRows(payload.LocaleCode, namespace, name, data)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// publishPayload is the JSON body sent by editmode.js to the publish
+// endpoint. Values and Rows hold the names of the values and rows shown on the
+// page, keyed by namespace. Only their drafts in LocaleCode are published, so
+// that publishing one page does not publish the drafts of other pages that
+// share a namespace with it, such as the namespace of their theme.
+type publishPayload struct {
+	// Namespace is the namespace of the page being published. If it is set,
+	// the request must be allowed to edit it.
+	Namespace  string
+	LocaleCode string
+	Values     map[string][]string
+	Rows       map[string][]string
+}
+
+// namespaces returns every namespace that the payload publishes.
+func (payload publishPayload) namespaces() []string {
+	var namespaces []string
+	for namespace := range payload.Values {
+		namespaces = append(namespaces, namespace)
+	}
+	for namespace := range payload.Rows {
+		if _, ok := payload.Values[namespace]; !ok {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	return namespaces
+}
+
+func (payload publishPayload) validate() error {
+	if payload.LocaleCode != "" && !localeCodeRegexp.MatchString(payload.LocaleCode) {
+		return fmt.Errorf("invalid localeCode %q", payload.LocaleCode)
+	}
+	var err error
+	if payload.Namespace != "" {
+		if err = validateNamespace(payload.Namespace); err != nil {
+			return err
+		}
+	}
+	for _, keys := range []map[string][]string{payload.Values, payload.Rows} {
+		for namespace, names := range keys {
+			if err = validateNamespace(namespace); err != nil {
+				return err
+			}
+			for _, name := range names {
+				if err = validateName(name); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// publish handles the POST requests made by editmode.js to publish the drafts
+// of a page. The drafts are published in a single ValueStoreTx, so either all
+// of them are published or none are. Like save, nothing is published if the
+// request may not edit any one of the namespaces in the payload.
+func (dir *TemplateDir) publish(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if mediaType := r.Header.Get("Content-Type"); !strings.HasPrefix(mediaType, "application/json") {
+		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	var payload publishPayload
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSaveBytes)).Decode(&payload)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = payload.validate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = dir.authorize(r, payload.Namespace, payload.namespaces())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	err = dir.publishTx(r.Context(), payload)
+	if err != nil {
+		dir.assetErrHandler(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (dir *TemplateDir) publishTx(ctx context.Context, payload publishPayload) (err error) {
+	tx, err := dir.store.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	for namespace, names := range payload.Values {
+		for _, name := range names {
+			err = tx.PublishDraftValue(payload.LocaleCode, namespace, name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for namespace, names := range payload.Rows {
+		for _, name := range names {
+			err = tx.PublishDraftRows(payload.LocaleCode, namespace, name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
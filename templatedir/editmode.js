@@ -97,6 +97,17 @@ document.addEventListener("DOMContentLoaded", function main() {
       return Object.assign(attributes, { class: "pm-toolbar-button" });
     };
     const labelAttributes = { class: "pm-toolbar-button-label" };
+    // reportErrors alerts the user if an action fails, so that a rejected
+    // save is never mistaken for a successful one.
+    const reportErrors = function (action) {
+      return async function () {
+        try {
+          await action();
+        } catch (err) {
+          alert(err.message);
+        }
+      };
+    };
     const deleteButton = pmCreateElement(
       "button",
       buttonAttributes({ title: "delete element under caret", onclick: deleteElement }),
@@ -276,7 +287,9 @@ document.addEventListener("DOMContentLoaded", function main() {
       // Delete
       deleteButton,
       // Save
-      pmCreateElement("button", buttonAttributes({ title: "save changes to page", onclick: save }), "Save"),
+      pmCreateElement("button", buttonAttributes({ title: "save changes to page", onclick: reportErrors(save) }), "Save"),
+      // Publish
+      pmCreateElement("button", buttonAttributes({ title: "save and publish changes to page", onclick: reportErrors(publish) }), "Publish"),
     );
     const toolbarPadding = pmCreateElement("div", { class: "pm-toolbar-padding" });
     document.querySelector("body")?.append(toolbar, toolbarPadding);
@@ -621,65 +634,118 @@ document.addEventListener("DOMContentLoaded", function main() {
     }
 
     async function save() {
-      const data = {};
+      const namespace = window.ENV("Namespace");
       const indextracker = {};
-      const pageID = window.Env("PageID").replace(/\/edit$/, "");
+      const payload = {
+        Namespace: namespace,
+        LocaleCode: window.ENV("LocaleCode") || "",
+        Values: {},
+        Rows: {},
+      };
       for (const node of document.querySelectorAll("[data-pm\\.row]")) {
+        const ID = node.getAttribute("data-pm.id") || namespace;
+        const rowname = node.getAttribute("data-pm.row");
+        // make sure a row name whose rows were all deleted is still sent
+        // as an empty list, so that the server clears it.
+        set(payload.Rows, [ID, rowname], payload.Rows[ID]?.[rowname] || []);
         if (node.getAttribute("hidden") !== null) {
           continue;
         }
-        const rowname = node.getAttribute("data-pm.row");
         const index = indextracker[rowname] || 0;
         node.setAttribute("data-pm.rowindex", `${index}`);
         indextracker[rowname] = index + 1;
       }
       for (const node of document.querySelectorAll("[data-pm\\.key]")) {
-        const ID = node.getAttribute("data-pm.id") || pageID;
+        const ID = node.getAttribute("data-pm.id") || namespace;
         const key = node.getAttribute("data-pm.key");
-        const value = node.innerHTML;
-        set(data, [ID, key], value);
+        set(payload.Values, [ID, key], node.innerHTML);
       }
       for (const node of document.querySelectorAll("[data-pm\\.row\\.key],[data-pm\\.row\\.href]")) {
         const row = getRowDetails(node);
         if (!row) {
           continue;
         }
-        const ID = node.getAttribute("data-pm.id") || pageID;
+        const rowNode = node.closest("[data-pm\\.row]");
+        const ID = (rowNode && rowNode.getAttribute("data-pm.id")) || namespace;
         const key = node.getAttribute("data-pm.row.key");
         const hrefKey = node.getAttribute("data-pm.row.href");
-        const value = node.innerHTML;
-        const hrefValue = node.getAttribute("href");
+        const rows = payload.Rows[ID][row.name];
+        rows[row.index] = rows[row.index] || { Values: {}, Hrefs: {} };
         if (key) {
-          set(data, [ID, row.name, row.index, key], value);
+          rows[row.index].Values[key] = node.innerHTML;
         }
         if (hrefKey) {
-          set(data, [ID, row.name, row.index, hrefKey], hrefValue);
+          rows[row.index].Hrefs[hrefKey] = node.getAttribute("href") || "";
         }
       }
-      const imgs = [];
-      for (const canvas of document.querySelectorAll("canvas[data-pm\\.img\\.upload]")) {
-        const url = canvas.getAttribute("data-pm.img.upload");
-        const blob = await new Promise((resolve) => canvas.toBlob(resolve));
-        imgs.push({ url, blob });
+      for (const rows of Object.values(payload.Rows).flatMap(Object.values)) {
+        for (let i = 0; i < rows.length; i++) {
+          rows[i] = rows[i] || { Values: {}, Hrefs: {} };
+        }
       }
-      console.log(data);
-      console.log(imgs);
-      const formdata = new FormData();
-      for (const [key, value] of Object.entries(data)) {
-        formdata.append(key, JSON.stringify(value));
+      const res = await fetch(window.ENV("SaveURL"), {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify(payload),
+      });
+      // the server rejects the whole save if this user is not allowed to
+      // edit any one of its namespaces, such as a theme namespace shared
+      // with other pages.
+      if (res.status === 403) {
+        throw new Error(`Nothing was saved: ${await res.text()}`);
       }
-      for (const img of imgs) {
-        formdata.append("imgs[]", img.blob, img.url);
+      if (!res.ok) {
+        throw new Error(`save failed: ${res.status} ${await res.text()}`);
       }
-      // Display the key/value pairs
-      for (const [key, value] of formdata.entries()) {
-        console.log(key + ", " + value);
+      const uploadURL = window.ENV("UploadURL");
+      const canvases = document.querySelectorAll("canvas[data-pm\\.img\\.upload]");
+      if (!uploadURL || canvases.length === 0) {
+        return payload;
       }
-      const res = await fetch("/upload", {
+      const formdata = new FormData();
+      for (const canvas of canvases) {
+        const url = canvas.getAttribute("data-pm.img.upload");
+        const ext = url.split(".").pop().toLowerCase();
+        const mimeType = { jpg: "image/jpeg", jpeg: "image/jpeg", webp: "image/webp" }[ext] || "image/png";
+        const blob = await new Promise((resolve) => canvas.toBlob(resolve, mimeType));
+        // multipart filenames are stripped of their directories by the
+        // server, so the destination path is sent as a separate field.
+        formdata.append("paths[]", url);
+        formdata.append("images[]", blob, url.split("/").pop());
+      }
+      const uploadRes = await fetch(uploadURL, {
         method: "POST",
         body: formdata,
       });
-      console.log(res);
+      if (!uploadRes.ok) {
+        throw new Error(`upload failed: ${uploadRes.status} ${await uploadRes.text()}`);
+      }
+      return payload;
+    }
+
+    // publish saves the page and then publishes the drafts of the values and
+    // rows on it in the current locale, so that the public sees what the
+    // editor sees. Drafts of other pages are left alone, even if they share a
+    // namespace with this one.
+    async function publish() {
+      const payload = await save();
+      const keys = (m) => Object.fromEntries(Object.entries(m).map(([ID, names]) => [ID, Object.keys(names)]));
+      const res = await fetch(window.ENV("PublishURL"), {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({
+          Namespace: payload.Namespace,
+          LocaleCode: payload.LocaleCode,
+          Values: keys(payload.Values),
+          Rows: keys(payload.Rows),
+        }),
+      });
+      if (res.status === 403) {
+        throw new Error(`Nothing was published: ${await res.text()}`);
+      }
+      if (!res.ok) {
+        throw new Error(`publish failed: ${res.status} ${await res.text()}`);
+      }
     }
 
     function pathToKeys(path) {
@@ -0,0 +1,97 @@
+package templatedir
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// immutableCacheControl is sent for fingerprinted asset URLs. The URL changes
+// whenever the file does, so the file can be cached for as long as browsers
+// allow.
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// assetFingerprint is the SHA-256 digest of an asset in the fs.FS, along with
+// the modification time of the file it was computed from.
+type assetFingerprint struct {
+	hex       string
+	integrity string
+	modTime   time.Time
+}
+
+// fingerprint returns the assetFingerprint of the file name in dir.fsys, or
+// nil if the file cannot be read. Fingerprints are cached until the file's
+// modification time changes.
+func (dir *TemplateDir) fingerprint(name string) *assetFingerprint {
+	cacheKey := "templatedir:fingerprint:" + name
+	modTime := dir.modTime(name)
+	if !dir.devMode {
+		if cached, ok := dir.cache.Get(cacheKey); ok {
+			if fp, ok := cached.(*assetFingerprint); ok && fp.modTime.Equal(modTime) {
+				return fp
+			}
+		}
+	}
+	b, err := fs.ReadFile(dir.fsys, name)
+	if err != nil {
+		return nil
+	}
+	sum := sha256.Sum256(b)
+	fp := &assetFingerprint{
+		hex:       hex.EncodeToString(sum[:]),
+		integrity: "sha256-" + base64.StdEncoding.EncodeToString(sum[:]),
+		modTime:   modTime,
+	}
+	if !dir.devMode {
+		dir.cache.Set(cacheKey, fp, 0)
+	}
+	return fp
+}
+
+// fingerprintedName inserts a fingerprint before the file extension of name,
+// so index.css becomes index.sha256-<hex>.css. TemplateDir.Assets strips the
+// fingerprint back out.
+func fingerprintedName(name, hex string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + ".sha256-" + hex + ext
+}
+
+// splitFingerprint is the inverse of fingerprintedName. If name has no
+// fingerprint it is returned unchanged with an empty hex.
+func splitFingerprint(name string) (unfingerprinted, hex string) {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	i := strings.LastIndex(base, ".")
+	if i < 0 || !strings.HasPrefix(base[i+1:], "sha256-") {
+		return name, ""
+	}
+	return base[:i] + ext, strings.TrimPrefix(base[i+1:], "sha256-")
+}
+
+// assetURL returns the URL and Subresource Integrity value for an asset listed
+// in a template config. Assets with absolute paths or full URLs are not served
+// by the TemplateDir and are returned as they are, without an integrity value.
+func (data templateData) assetURL(name string) (url, integrity string) {
+	if strings.HasPrefix(name, "/") || strings.Contains(name, "://") {
+		return name, ""
+	}
+	if data.fingerprint == nil {
+		return data.assetURLPrefix + name, ""
+	}
+	fp := data.fingerprint(name)
+	if fp == nil {
+		return data.assetURLPrefix + name, ""
+	}
+	return data.assetURLPrefix + fingerprintedName(name, fp.hex), fp.integrity
+}
+
+func integrityAttr(integrity string) string {
+	if integrity == "" {
+		return ""
+	}
+	return ` integrity="` + integrity + `"`
+}
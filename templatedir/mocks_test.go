@@ -1,22 +1,27 @@
 package templatedir
 
 import (
+	"context"
 	"sync"
 )
 
 type vkey struct{ localeCode, namespace, name string }
 
 type vstore struct {
-	mu     *sync.RWMutex
-	values map[vkey]NullString
-	rows   map[vkey][]map[string]interface{}
+	mu          *sync.RWMutex
+	values      map[vkey]NullString
+	rows        map[vkey][]map[string]interface{}
+	draftValues map[vkey]NullString
+	draftRows   map[vkey][]map[string]interface{}
 }
 
 func newVstore() *vstore {
 	return &vstore{
-		mu:     &sync.RWMutex{},
-		values: make(map[vkey]NullString),
-		rows:   make(map[vkey][]map[string]interface{}),
+		mu:          &sync.RWMutex{},
+		values:      make(map[vkey]NullString),
+		rows:        make(map[vkey][]map[string]interface{}),
+		draftValues: make(map[vkey]NullString),
+		draftRows:   make(map[vkey][]map[string]interface{}),
 	}
 }
 
@@ -34,20 +39,39 @@ func (store *vstore) GetRows(localeCode, namespace, name string) (rows []map[str
 	return store.rows[vkey{localeCode: localeCode, namespace: namespace, name: name}], nil
 }
 
-func (store *vstore) BeginTx() (ValueStoreTx, error) {
+func (store *vstore) GetDraftValue(localeCode, namespace, name string) (value NullString, err error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return store.draftValues[vkey{localeCode: localeCode, namespace: namespace, name: name}], nil
+}
+
+func (store *vstore) GetDraftRows(localeCode, namespace, name string) (rows []map[string]interface{}, ok bool, err error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	rows, ok = store.draftRows[vkey{localeCode: localeCode, namespace: namespace, name: name}]
+	return rows, ok, nil
+}
+
+func (store *vstore) BeginTx(ctx context.Context) (ValueStoreTx, error) {
 	return &vstoretx{
-		mu:     &sync.RWMutex{},
-		values: make(map[vkey]NullString),
-		rows:   make(map[vkey][]map[string]interface{}),
-		store:  store,
+		mu:          &sync.RWMutex{},
+		values:      make(map[vkey]NullString),
+		rows:        make(map[vkey][]map[string]interface{}),
+		draftValues: make(map[vkey]NullString),
+		draftRows:   make(map[vkey][]map[string]interface{}),
+		store:       store,
 	}, nil
 }
 
 type vstoretx struct {
-	mu     *sync.RWMutex
-	values map[vkey]NullString
-	rows   map[vkey][]map[string]interface{}
-	store  *vstore
+	mu            *sync.RWMutex
+	values        map[vkey]NullString
+	rows          map[vkey][]map[string]interface{}
+	draftValues   map[vkey]NullString
+	draftRows     map[vkey][]map[string]interface{}
+	publishValues []vkey
+	publishRows   []vkey
+	store         *vstore
 }
 
 func (tx *vstoretx) SetValue(localeCode, namespace, name string, value string) error {
@@ -64,6 +88,34 @@ func (tx *vstoretx) SetRows(localeCode, namespace, name string, rows []map[strin
 	return nil
 }
 
+func (tx *vstoretx) SetDraftValue(localeCode, namespace, name string, value string) error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	tx.draftValues[vkey{localeCode: localeCode, namespace: namespace, name: name}] = NullString{Valid: true, Str: value}
+	return nil
+}
+
+func (tx *vstoretx) SetDraftRows(localeCode, namespace, name string, rows []map[string]interface{}) error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	tx.draftRows[vkey{localeCode: localeCode, namespace: namespace, name: name}] = rows
+	return nil
+}
+
+func (tx *vstoretx) PublishDraftValue(localeCode, namespace, name string) error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	tx.publishValues = append(tx.publishValues, vkey{localeCode: localeCode, namespace: namespace, name: name})
+	return nil
+}
+
+func (tx *vstoretx) PublishDraftRows(localeCode, namespace, name string) error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	tx.publishRows = append(tx.publishRows, vkey{localeCode: localeCode, namespace: namespace, name: name})
+	return nil
+}
+
 func (tx *vstoretx) Commit() error {
 	tx.mu.RLock()
 	defer tx.mu.RUnlock()
@@ -75,6 +127,24 @@ func (tx *vstoretx) Commit() error {
 	for k, v := range tx.rows {
 		tx.store.rows[k] = v
 	}
+	for k, v := range tx.draftValues {
+		tx.store.draftValues[k] = v
+	}
+	for k, v := range tx.draftRows {
+		tx.store.draftRows[k] = v
+	}
+	for _, k := range tx.publishValues {
+		if v, ok := tx.store.draftValues[k]; ok {
+			tx.store.values[k] = v
+			delete(tx.store.draftValues, k)
+		}
+	}
+	for _, k := range tx.publishRows {
+		if v, ok := tx.store.draftRows[k]; ok {
+			tx.store.rows[k] = v
+			delete(tx.store.draftRows, k)
+		}
+	}
 	return nil
 }
 
@@ -87,5 +157,35 @@ func (tx *vstoretx) Rollback() error {
 	for k := range tx.rows {
 		delete(tx.rows, k)
 	}
+	for k := range tx.draftValues {
+		delete(tx.draftValues, k)
+	}
+	for k := range tx.draftRows {
+		delete(tx.draftRows, k)
+	}
+	tx.publishValues = nil
+	tx.publishRows = nil
 	return nil
 }
+
+// pagedVstore is a vstore that is also a RowsPager, and records how many
+// rows it returned.
+type pagedVstore struct {
+	*vstore
+	rowsFetched int
+}
+
+func (store *pagedVstore) GetRows(localeCode, namespace, name string) (rows []map[string]interface{}, err error) {
+	rows, err = store.vstore.GetRows(localeCode, namespace, name)
+	store.rowsFetched += len(rows)
+	return rows, err
+}
+
+func (store *pagedVstore) GetRowsPage(localeCode, namespace, name string, limit, offset int) (rows []map[string]interface{}, total int, err error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	all := store.rows[vkey{localeCode: localeCode, namespace: namespace, name: name}]
+	rows = pageRows(all, limit, offset)
+	store.rowsFetched += len(rows)
+	return rows, len(all), nil
+}
@@ -0,0 +1,79 @@
+package templatedir
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// pageRows returns at most limit rows starting from offset. A limit of 0 or
+// less means no limit. getRowsPaged uses it for drafts and for stores that
+// are not a RowsPager.
+func pageRows(rows []map[string]interface{}, limit, offset int) []map[string]interface{} {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= len(rows) {
+		return nil
+	}
+	rows = rows[offset:]
+	if limit > 0 && limit < len(rows) {
+		rows = rows[:limit]
+	}
+	return rows
+}
+
+// sortRows returns a copy of rows sorted by column, in descending order if
+// column starts with "-". Rows missing the column sort first, numbers are
+// compared as numbers and everything else is compared as strings.
+func sortRows(rows []map[string]interface{}, column string) []map[string]interface{} {
+	desc := strings.HasPrefix(column, "-")
+	column = strings.TrimPrefix(column, "-")
+	sorted := make([]map[string]interface{}, len(rows))
+	copy(sorted, rows)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if desc {
+			return compareValues(sorted[j][column], sorted[i][column]) < 0
+		}
+		return compareValues(sorted[i][column], sorted[j][column]) < 0
+	})
+	return sorted
+}
+
+func compareValues(a, b interface{}) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		default:
+			return 1
+		}
+	}
+	x, xok := toFloat(a)
+	y, yok := toFloat(b)
+	if xok && yok {
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		default:
+			return 0
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
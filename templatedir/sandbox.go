@@ -0,0 +1,143 @@
+package templatedir
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// Config files come from theme authors, so they are evaluated in a runtime
+// that can only compute a value: it has no globals beyond the pure ones in
+// allowedGlobals, a limited call stack and a wall-clock budget.
+const (
+	defaultConfigTimeout = time.Second
+	maxCallStackSize     = 1000
+)
+
+// allowedGlobals are the only globals left in the runtime that config files
+// are evaluated in. Notably eval, Function, Proxy, Reflect and the typed
+// arrays are removed, since eval and Function compile arbitrary strings.
+var allowedGlobals = map[string]bool{
+	"Object": true, "Array": true, "String": true,
+	"Number": true, "Boolean": true, "Symbol": true, "Date": true,
+	"RegExp": true, "Math": true, "JSON": true, "Map": true, "Set": true,
+	"Error": true, "TypeError": true, "ReferenceError": true,
+	"SyntaxError": true, "RangeError": true,
+	"globalThis": true, "NaN": true, "undefined": true, "Infinity": true,
+	"isNaN": true, "isFinite": true, "parseInt": true, "parseFloat": true,
+	"encodeURI": true, "encodeURIComponent": true,
+	"decodeURI": true, "decodeURIComponent": true,
+}
+
+// configPrefix wraps a config file in a function so that it can use return.
+// It adds no newlines, so only columns on the first line are shifted.
+const configPrefix = `(function(){`
+
+// ConfigError is an error from evaluating a config file, pointing at where
+// in the file it happened. Line and Column are 0 if the position is unknown.
+type ConfigError struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+	// Err is the underlying error, which is ErrConfigTimeout or
+	// ErrConfigStackOverflow if the config file exceeded its budget.
+	Err error
+}
+
+func (e *ConfigError) Error() string {
+	if e.Line == 0 {
+		return e.File + ": " + e.Message
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Message)
+}
+
+func (e *ConfigError) Unwrap() error { return e.Err }
+
+// ErrConfigTimeout is the error of a config file that ran out of time.
+var ErrConfigTimeout = errors.New("config took too long to evaluate")
+
+// ErrConfigStackOverflow is the error of a config file that recursed too
+// deeply.
+var ErrConfigStackOverflow = errors.New("maximum call stack size exceeded")
+
+// runJS evaluates the config file filename in a sandboxed runtime with the
+// given globals set, and returns its exported return value. Every error is a
+// *ConfigError.
+func (dir *TemplateDir) runJS(filename, src string, globals map[string]interface{}) (interface{}, error) {
+	vm := goja.New()
+	vm.SetMaxCallStackSize(maxCallStackSize)
+	// the Function constructor is also reachable from any function, so it is
+	// removed from their prototype along with the global
+	names, err := vm.RunString(`
+		Object.defineProperty(Function.prototype, "constructor", { value: undefined });
+		Object.getOwnPropertyNames(globalThis);
+	`)
+	if err != nil {
+		return nil, &ConfigError{File: filename, Message: err.Error(), Err: err}
+	}
+	global := vm.GlobalObject()
+	for _, name := range names.Export().([]interface{}) {
+		if name, ok := name.(string); ok && !allowedGlobals[name] {
+			global.Delete(name)
+		}
+	}
+	for name, value := range globals {
+		vm.Set(name, value)
+	}
+	timer := time.AfterFunc(dir.configTimeout, func() { vm.Interrupt(ErrConfigTimeout) })
+	defer timer.Stop()
+	val, err := vm.RunScript(filename, configPrefix+src+"\n})()")
+	if err != nil {
+		return nil, newConfigError(filename, err)
+	}
+	return val.Export(), nil
+}
+
+var (
+	exceptionPosition   = regexp.MustCompile(` at [^\s()]*?:(\d+):(\d+)\(\d+\)`)
+	syntaxErrorPosition = regexp.MustCompile(`: Line (\d+):(\d+) `)
+)
+
+// newConfigError converts an error returned by goja into a *ConfigError. goja
+// does not export the positions of its errors, so they are parsed out of the
+// error message.
+func newConfigError(filename string, err error) *ConfigError {
+	configErr := &ConfigError{File: filename, Message: err.Error(), Err: err}
+	switch e := err.(type) {
+	case *goja.InterruptedError:
+		// the position of an interrupt is wherever the runtime happened to
+		// be when the time ran out, which is not useful
+		if value, ok := e.Value().(error); ok {
+			configErr.Err = value
+			configErr.Message = value.Error()
+		}
+		return configErr
+	case *goja.StackOverflowError:
+		configErr.Err = ErrConfigStackOverflow
+		configErr.Message = ErrConfigStackOverflow.Error()
+		return configErr
+	}
+	msg := err.Error()
+	if match := exceptionPosition.FindStringSubmatchIndex(msg); match != nil {
+		configErr.Line, _ = strconv.Atoi(msg[match[2]:match[3]])
+		configErr.Column, _ = strconv.Atoi(msg[match[4]:match[5]])
+		configErr.Message = strings.TrimSpace(msg[:match[0]])
+	} else if match := syntaxErrorPosition.FindStringSubmatchIndex(msg); match != nil {
+		configErr.Line, _ = strconv.Atoi(msg[match[2]:match[3]])
+		configErr.Column, _ = strconv.Atoi(msg[match[4]:match[5]])
+		configErr.Message = "SyntaxError: " + strings.TrimSpace(msg[match[1]:])
+	}
+	if configErr.Line == 1 {
+		configErr.Column -= len(configPrefix)
+		if configErr.Column < 1 {
+			configErr.Column = 1
+		}
+	}
+	return configErr
+}
@@ -1,8 +1,12 @@
 package templatedir
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"database/sql/driver"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -18,8 +22,8 @@ import (
 	"sync"
 	"time"
 
-	"github.com/davecgh/go-spew/spew"
-	"github.com/dop251/goja"
+	"github.com/bokwoon95/pagemanager/cache"
+	hy "github.com/bokwoon95/pagemanager/hypergo"
 )
 
 var bufpool = sync.Pool{New: func() interface{} { return &bytes.Buffer{} }}
@@ -41,12 +45,21 @@ type TemplateDir struct {
 	assetFilter      func(path string) (allow bool)
 	assetNotFound    func(w http.ResponseWriter, r *http.Request)
 	assetErrHandler  func(w http.ResponseWriter, r *http.Request, err error)
+	sanitizer        hy.Sanitizer
+	uploadURL        string
+	editPermission   func(r *http.Request, namespace string) (allow bool)
+	localeFallbacks  func(localeCode string) []string
 	fallbackAssets   map[string]string
 	fallbackAssetsMu *sync.RWMutex
-	configCache      map[string]templateConfig
-	configCacheMu    *sync.RWMutex
-	tmplCache        map[string]*template.Template
-	tmplCacheMu      *sync.RWMutex
+	// cache holds compiled templates and asset fingerprints. They are Go
+	// values that cannot be serialized, so they are always kept in the
+	// process rather than in a shared cache.Cache.
+	cache          *cache.LRU
+	devMode        bool
+	validateThemes bool
+	configTimeout  time.Duration
+	funcMap        map[string]interface{}
+	watcher        *watcher
 }
 
 type Option func(*TemplateDir)
@@ -67,6 +80,67 @@ func AssetErrHandler(errhandler func(w http.ResponseWriter, r *http.Request, err
 	return func(dir *TemplateDir) { dir.assetErrHandler = errhandler }
 }
 
+// Sanitizer sets the sanitizer used to clean HTML submitted by editmode.js
+// before it is saved. It defaults to hy.DefaultSanitizer.
+func Sanitizer(sanitizer hy.Sanitizer) Option {
+	return func(dir *TemplateDir) { dir.sanitizer = sanitizer }
+}
+
+// UploadURL sets the URL that editmode.js uploads edited images to. If it is
+// not set, edited images are not uploaded.
+func UploadURL(url string) Option {
+	return func(dir *TemplateDir) { dir.uploadURL = url }
+}
+
+// EditPermission sets the check that decides whether a request may edit the
+// values in a namespace. It is consulted by the save endpoint for every
+// namespace in the payload, and by ServeTemplate before turning on EditMode.
+// If it is not set, no request may edit anything.
+func EditPermission(allow func(r *http.Request, namespace string) bool) Option {
+	return func(dir *TemplateDir) { dir.editPermission = allow }
+}
+
+// LocaleFallbacks sets the locales that getValue and getRows fall back to, in
+// order, when a value or rows are missing in the requested locale. If it is
+// not set, there is no fallback.
+func LocaleFallbacks(fallbacks func(localeCode string) []string) Option {
+	return func(dir *TemplateDir) { dir.localeFallbacks = fallbacks }
+}
+
+// DevMode makes ServeTemplate evaluate config files and parse templates on
+// every request, bypassing the cache. Pages served in DevMode also reload
+// themselves whenever a file in the fs.FS changes.
+func DevMode(devMode bool) Option {
+	return func(dir *TemplateDir) { dir.devMode = devMode }
+}
+
+// FuncMap adds template funcs to every template, on top of the built in ones
+// like getValue and getRows. New fails if a func would replace a built in
+// one. It can be passed more than once.
+func FuncMap(funcMap map[string]interface{}) Option {
+	return func(dir *TemplateDir) {
+		if dir.funcMap == nil {
+			dir.funcMap = make(map[string]interface{})
+		}
+		for name, fn := range funcMap {
+			dir.funcMap[name] = fn
+		}
+	}
+}
+
+// ConfigTimeout sets how long a config file may take to evaluate before it
+// is aborted with ErrConfigTimeout. It defaults to one second.
+func ConfigTimeout(timeout time.Duration) Option {
+	return func(dir *TemplateDir) { dir.configTimeout = timeout }
+}
+
+// ValidateThemes makes New load every theme and compile every template up
+// front, failing with all of the errors found as ThemeErrors. By default
+// broken themes are only discovered when one of their templates is served.
+func ValidateThemes(validateThemes bool) Option {
+	return func(dir *TemplateDir) { dir.validateThemes = validateThemes }
+}
+
 func New(fsys fs.FS, store ValueStore, opts ...Option) (*TemplateDir, error) {
 	if fsys == nil {
 		return nil, fmt.Errorf("dir cannot be nil")
@@ -79,6 +153,7 @@ func New(fsys fs.FS, store ValueStore, opts ...Option) (*TemplateDir, error) {
 		store:            store,
 		fallbackAssets:   make(map[string]string),
 		fallbackAssetsMu: &sync.RWMutex{},
+		watcher:          &watcher{},
 	}
 	for _, opt := range opts {
 		opt(dir)
@@ -101,31 +176,74 @@ func New(fsys fs.FS, store ValueStore, opts ...Option) (*TemplateDir, error) {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 	}
+	if dir.sanitizer == nil {
+		dir.sanitizer = hy.DefaultSanitizer
+	}
+	if dir.editPermission == nil {
+		dir.editPermission = func(r *http.Request, namespace string) bool { return false }
+	}
+	dir.cache = cache.NewLRU(1000)
+	if len(dir.funcMap) > 0 {
+		funcMap := dir.funcMap
+		dir.funcMap = nil
+		for name := range dir.funcs() {
+			if _, ok := funcMap[name]; ok {
+				return nil, fmt.Errorf("FuncMap: %q is a built in template func", name)
+			}
+		}
+		dir.funcMap = funcMap
+	}
+	if dir.configTimeout <= 0 {
+		dir.configTimeout = defaultConfigTimeout
+	}
+	if dir.localeFallbacks == nil {
+		dir.localeFallbacks = func(localeCode string) []string { return nil }
+	}
+	err := dir.loadThemes()
+	if err != nil {
+		return nil, err
+	}
 	return dir, nil
 }
 
+// OpenFallbackAsset opens the theme file registered as the fallback for
+// urlPath. It returns an error satisfying errors.Is(err, os.ErrNotExist) if
+// there is none.
+func (dir *TemplateDir) OpenFallbackAsset(urlPath string) (fs.File, error) {
+	dir.fallbackAssetsMu.RLock()
+	fallbackFile, ok := dir.fallbackAssets[urlPath]
+	dir.fallbackAssetsMu.RUnlock()
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return dir.fsys.Open(fallbackFile)
+}
+
 func (dir *TemplateDir) Assets(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !strings.HasPrefix(r.URL.Path, dir.assetURLPrefix) {
 			next.ServeHTTP(w, r)
 			return
 		}
-		path := func() string {
-			path := strings.TrimPrefix(r.URL.Path, dir.assetURLPrefix)
-			ext := filepath.Ext(path)
-			name := strings.TrimSuffix(path, ext)
-			i := strings.LastIndex(name, ".")
-			if i < 0 || !strings.HasPrefix(name[i+1:], "sha256-") {
-				return path
-			}
-			return name[:i] + ext
-		}()
+		path, hex := splitFingerprint(strings.TrimPrefix(r.URL.Path, dir.assetURLPrefix))
+		if path == "save" {
+			dir.save(w, r)
+			return
+		}
+		if path == "publish" {
+			dir.publish(w, r)
+			return
+		}
+		if path == "reload" {
+			dir.reload(w, r)
+			return
+		}
 		basepath := filepath.Base(path)
 		if basepath == "config.js" || strings.HasSuffix(basepath, ".config.js") {
 			dir.assetNotFound(w, r)
 			return
 		}
-		if basepath == "editmode.js" || basepath == "editmode.css" || basepath == "env.js" {
+		if basepath == "editmode.js" || basepath == "editmode.css" || basepath == "env.js" || basepath == "devmode.js" {
 			f, err := internalFS.Open(path)
 			dir.serveFile(w, r, path, f, err)
 			return
@@ -136,16 +254,15 @@ func (dir *TemplateDir) Assets(next http.Handler) http.Handler {
 		}
 		f, err := dir.fsys.Open(path)
 		if errors.Is(err, os.ErrNotExist) {
-			f, err = func() (fs.File, error) {
-				missingFile := "/" + path
-				dir.fallbackAssetsMu.RLock()
-				defer dir.fallbackAssetsMu.RUnlock()
-				fallbackFile, ok := dir.fallbackAssets[missingFile]
-				if !ok {
-					return nil, os.ErrNotExist
-				}
-				return dir.fsys.Open(strings.TrimPrefix(fallbackFile, "/"))
-			}()
+			f, err = dir.OpenFallbackAsset("/" + path)
+		} else if hex != "" {
+			// a stale fingerprint still gets the current file, but it must
+			// not be cached as if it were the file that the URL refers to
+			if fp := dir.fingerprint(path); fp != nil && fp.hex == hex {
+				w.Header().Set("Cache-Control", immutableCacheControl)
+			} else {
+				w.Header().Set("Cache-Control", "no-cache")
+			}
 		}
 		dir.serveFile(w, r, path, f, err)
 	})
@@ -185,15 +302,42 @@ func (dir *TemplateDir) serveFile(w http.ResponseWriter, r *http.Request, path s
 	http.ServeContent(w, r, path, info.ModTime(), fseeker)
 }
 
+// ValueStore holds the published values and rows that everyone sees, as well
+// as the drafts that editors have saved but not yet published.
 type ValueStore interface {
 	GetValue(localeCode, namespace, name string) (value NullString, err error)
 	GetRows(localeCode, namespace, name string) (rows []map[string]interface{}, err error)
-	BeginTx() (ValueStoreTx, error)
+	// GetDraftValue returns an invalid NullString if the value has no draft.
+	GetDraftValue(localeCode, namespace, name string) (value NullString, err error)
+	// GetDraftRows returns ok=false if the rows have no draft. A draft may
+	// have no rows at all if every row was deleted.
+	GetDraftRows(localeCode, namespace, name string) (rows []map[string]interface{}, ok bool, err error)
+	// BeginTx begins a transaction for the writes of the request that ctx
+	// belongs to, so that the store can record who made them.
+	BeginTx(ctx context.Context) (ValueStoreTx, error)
+}
+
+// RowsPager is an optional interface of a ValueStore. If the store implements
+// it, getRowsPaged fetches only the page of published rows that it shows
+// instead of every row. Drafts are always paged in memory.
+type RowsPager interface {
+	// GetRowsPage returns at most limit rows starting from offset, in the
+	// same order as GetRows, along with the total number of rows. A limit of
+	// 0 or less means no limit.
+	GetRowsPage(localeCode, namespace, name string, limit, offset int) (rows []map[string]interface{}, total int, err error)
 }
 
 type ValueStoreTx interface {
 	SetValue(localeCode, namespace, name string, value string) error
 	SetRows(localeCode, namespace, name string, rows []map[string]interface{}) error
+	SetDraftValue(localeCode, namespace, name string, value string) error
+	SetDraftRows(localeCode, namespace, name string, rows []map[string]interface{}) error
+	// PublishDraftValue replaces the value with its draft and deletes the
+	// draft. It does nothing if the value has no draft.
+	PublishDraftValue(localeCode, namespace, name string) error
+	// PublishDraftRows replaces the rows with their draft and deletes the
+	// draft. It does nothing if the rows have no draft.
+	PublishDraftRows(localeCode, namespace, name string) error
 	Commit() error
 	Rollback() error
 }
@@ -204,18 +348,27 @@ type templateData struct {
 	LocaleCode     string
 	EditMode       bool
 	Vars           map[string]interface{}
+	preview        bool
 	css            []string
 	js             []string
 	csp            map[string][]string
 	fsys           fs.FS
 	assetURLPrefix string
+	uploadURL      string
+	devMode        bool
+	nonce          string
+	cspDisabled    bool
+	cspHeaderSent  bool
+	fingerprint    func(name string) *assetFingerprint
 	// CSS/JS are methods that can either return just the path or inline the script entirely (because templatedata retains a reference to the fs.FS). This means there is no need for a Data []byte.
 }
 
 type serveConfig struct {
 	disableCSP     bool
+	csp            map[string][]string
 	localeCode     string
 	editMode       bool
+	preview        bool
 	css            []string
 	js             []string
 	bufferResponse bool
@@ -224,74 +377,360 @@ type serveConfig struct {
 
 type ServeOption func(*serveConfig)
 
+// LocaleCode sets the locale that the template's values are fetched in.
+func LocaleCode(localeCode string) ServeOption {
+	return func(config *serveConfig) { config.localeCode = localeCode }
+}
+
+// DisableCSP stops ServeTemplate from sending a Content-Security-Policy.
+func DisableCSP(disableCSP bool) ServeOption {
+	return func(config *serveConfig) { config.disableCSP = disableCSP }
+}
+
+// ContentSecurityPolicy adds directives to the template's
+// Content-Security-Policy, on top of the ones from the theme's config.js and
+// the template config. It can be passed more than once.
+func ContentSecurityPolicy(csp map[string][]string) ServeOption {
+	return func(config *serveConfig) {
+		if config.csp == nil {
+			config.csp = make(map[string][]string)
+		}
+		mergeCSP(config.csp, csp)
+	}
+}
+
+// BufferResponse renders the whole template before writing any of it, instead
+// of streaming it. A template that fails then leaves the response untouched
+// so that a clean error page can be written, and responses get a
+// Content-Length and an ETag that is checked against If-None-Match.
+func BufferResponse(bufferResponse bool) ServeOption {
+	return func(config *serveConfig) { config.bufferResponse = bufferResponse }
+}
+
+// CacheConfig sets whether the compiled template config and templates may be
+// taken from and kept in the cache. It defaults to true.
+func CacheConfig(cacheConfig bool) ServeOption {
+	return func(config *serveConfig) { config.cacheConfig = cacheConfig }
+}
+
+// CSS adds stylesheets to the template, before the ones from its template
+// config. URLs that are neither absolute paths nor full URLs are relative to
+// the TemplateDir's AssetURLPrefix.
+func CSS(urls ...string) ServeOption {
+	return func(config *serveConfig) { config.css = append(config.css, urls...) }
+}
+
+// JS adds scripts to the template, before the ones from its template config.
+// URLs that are neither absolute paths nor full URLs are relative to the
+// TemplateDir's AssetURLPrefix.
+func JS(urls ...string) ServeOption {
+	return func(config *serveConfig) { config.js = append(config.js, urls...) }
+}
+
+// EditMode turns on edit mode for the template, if the request passes the
+// EditPermission check for the page's namespace.
+func EditMode(editMode bool) ServeOption {
+	return func(config *serveConfig) { config.editMode = editMode }
+}
+
+// Preview makes the template show the drafts of values and rows in place of
+// their published versions, wherever there is a draft. Edit mode always
+// shows drafts, since that is where edits are saved.
+func Preview(preview bool) ServeOption {
+	return func(config *serveConfig) { config.preview = preview }
+}
+
+// ServeTemplate executes the template described by the template config
+// subDir/templateConfigPath. Unless DisableCSP is passed, the template gets a
+// Content-Security-Policy with a per-request nonce for the scripts added by
+// templateData.JS. The policy is sent as a header if w is an
+// http.ResponseWriter, otherwise templateData.ContentSecurityPolicy renders it
+// as a meta tag.
 func (dir *TemplateDir) ServeTemplate(w io.Writer, r *http.Request, subDir, templateConfigPath string, opts ...ServeOption) error {
 	var data templateData
-	var config serveConfig
+	config := serveConfig{cacheConfig: true}
 	for _, opt := range opts {
 		opt(&config)
 	}
 	data.URL = r.URL.Path
 	data.Namespace = r.URL.Path
 	data.LocaleCode = config.localeCode
-	data.EditMode = config.editMode
+	data.EditMode = config.editMode && dir.editPermission(r, data.Namespace)
+	data.preview = config.preview || data.EditMode
 	data.css = append(data.css, config.css...)
 	data.js = append(data.js, config.js...)
 	data.fsys = dir.fsys
 	data.assetURLPrefix = dir.assetURLPrefix
+	data.uploadURL = dir.uploadURL
+	data.devMode = dir.devMode
+	data.fingerprint = dir.fingerprint
 	subDir = strings.TrimPrefix(strings.TrimSuffix(subDir, "/"), "/")
-	var configjs interface{}
-	b, err := fs.ReadFile(dir.fsys, subDir+"/config.js")
+	compiled, err := dir.compile(subDir, templateConfigPath, config.cacheConfig)
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			configjs = map[string]interface{}{}
-		} else {
-			return err
-		}
-	} else {
-		vm := goja.New()
-		val, err := vm.RunString(`(function(){` + string(b) + `})()`)
+		return err
+	}
+	data.css = append(data.css, compiled.config.css...)
+	data.js = append(data.js, compiled.config.js...)
+	data.Vars = compiled.config.vars
+	data.cspDisabled = config.disableCSP
+	if !data.cspDisabled {
+		data.nonce, err = newNonce()
 		if err != nil {
 			return err
 		}
-		configjs = val.Export()
+		data.csp = make(map[string][]string)
+		mergeCSP(data.csp, compiled.config.contentSecurityPolicy)
+		mergeCSP(data.csp, config.csp)
+		if rw, ok := w.(http.ResponseWriter); ok {
+			rw.Header().Set("Content-Security-Policy", buildCSP(data.csp, data.nonce))
+			data.cspHeaderSent = true
+		}
+	}
+	if config.bufferResponse {
+		return dir.executeBuffered(w, r, compiled, data)
 	}
-	b, err = fs.ReadFile(dir.fsys, subDir+"/"+templateConfigPath)
+	return dir.executeStreaming(w, compiled, data)
+}
+
+// executeBuffered renders the template into a buffer before writing any of
+// it, so that a template that fails halfway leaves w untouched for the caller
+// to write an error page. If w is an http.ResponseWriter it also gets a
+// Content-Length and a weak ETag, and requests whose If-None-Match matches
+// the ETag get a 304 Not Modified.
+func (dir *TemplateDir) executeBuffered(w io.Writer, r *http.Request, compiled *compiledTemplate, data templateData) error {
+	buf := bufpool.Get().(*bytes.Buffer)
+	defer func() {
+		buf.Reset()
+		bufpool.Put(buf)
+	}()
+	rw, isResponseWriter := w.(http.ResponseWriter)
+	err := compiled.tmpl.ExecuteTemplate(buf, compiled.name, data)
 	if err != nil {
+		if isResponseWriter {
+			rw.Header().Del("Content-Security-Policy")
+		}
 		return err
 	}
-	vm := goja.New()
-	vm.Set("$CONFIG", configjs)
-	val, err := vm.RunString(`(function(){` + string(b) + `})()`)
-	if err != nil {
+	if !isResponseWriter {
+		_, err = buf.WriteTo(w)
 		return err
 	}
-	var tconfig templateConfig
-	err = tconfig.Unmarshal(subDir, val.Export())
+	etag := weakETag(buf.Bytes(), data.nonce)
+	rw.Header().Set("ETag", etag)
+	if (r.Method == http.MethodGet || r.Method == http.MethodHead) && etagMatch(r.Header.Get("If-None-Match"), etag) {
+		// the browser will reuse its cached body, whose scripts carry the
+		// nonce of the cached Content-Security-Policy. Sending this
+		// response's policy would replace that and block them.
+		rw.Header().Del("Content-Security-Policy")
+		rw.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	rw.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// executeStreaming renders the template straight into w. If w is an
+// http.Flusher, output is flushed every time a few kilobytes have been
+// rendered so that the browser can start on the page early.
+func (dir *TemplateDir) executeStreaming(w io.Writer, compiled *compiledTemplate, data templateData) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return compiled.tmpl.ExecuteTemplate(w, compiled.name, data)
+	}
+	bw := bufio.NewWriterSize(flushWriter{w: w, flusher: flusher}, 4096)
+	err := compiled.tmpl.ExecuteTemplate(bw, compiled.name, data)
+	flushErr := bw.Flush()
 	if err != nil {
 		return err
 	}
-	data.css = append(data.css, tconfig.css...)
-	data.js = append(data.js, tconfig.js...)
-	data.Vars = tconfig.vars
-	data.csp = tconfig.contentSecurityPolicy
-	spew.Dump(data)
-	if len(tconfig.html) == 0 {
-		return fmt.Errorf("no files provided")
+	return flushErr
+}
+
+type flushWriter struct {
+	w       io.Writer
+	flusher http.Flusher
+}
+
+func (fw flushWriter) Write(p []byte) (n int, err error) {
+	n, err = fw.w.Write(p)
+	fw.flusher.Flush()
+	return n, err
+}
+
+// weakETag returns a weak ETag for a rendered page. The page's nonce is left
+// out of the hash, otherwise no two responses would ever share an ETag.
+func weakETag(body []byte, nonce string) string {
+	h := sha256.New()
+	if nonce == "" {
+		h.Write(body)
+	} else {
+		h.Write(bytes.ReplaceAll(body, []byte(nonce), nil))
+	}
+	return `W/"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// etagMatch reports whether an If-None-Match header matches etag, using the
+// weak comparison.
+func etagMatch(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
 	}
-	t := template.New("").Funcs(dir.funcs())
-	for _, html := range tconfig.html {
+	return false
+}
+
+// compiledTemplate is an evaluated template config together with its parsed
+// HTML files. modTimes holds the modification time of every file it was
+// compiled from, so that it can be recompiled when any of them changes.
+type compiledTemplate struct {
+	config   templateConfig
+	tmpl     *template.Template
+	name     string
+	modTimes map[string]time.Time
+}
+
+// compile returns the compiledTemplate for subDir/templateConfigPath, from
+// the cache if none of its files have changed since it was compiled. Nothing
+// is cached in DevMode or if useCache is false.
+func (dir *TemplateDir) compile(subDir, templateConfigPath string, useCache bool) (*compiledTemplate, error) {
+	cacheKey := "templatedir:template:" + subDir + "/" + templateConfigPath
+	useCache = useCache && !dir.devMode
+	if useCache {
+		if cached, ok := dir.cache.Get(cacheKey); ok {
+			if compiled, ok := cached.(*compiledTemplate); ok && !dir.modified(compiled.modTimes) {
+				return compiled, nil
+			}
+		}
+	}
+	compiled := &compiledTemplate{modTimes: make(map[string]time.Time)}
+	chain, err := dir.themeChain(subDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, themePath := range chain {
+		compiled.modTimes[themePath+"/theme.config.js"] = dir.modTime(themePath + "/theme.config.js")
+	}
+	// files are looked up child theme first, and the modification times of
+	// every file that was looked for are recorded so that adding an
+	// overriding file to a child theme also recompiles the template
+	resolve := func(name string) string {
+		for _, themePath := range chain {
+			file := themePath + "/" + name
+			info, err := fs.Stat(dir.fsys, file)
+			if err != nil {
+				compiled.modTimes[file] = time.Time{}
+				continue
+			}
+			compiled.modTimes[file] = info.ModTime()
+			return file
+		}
+		return subDir + "/" + name
+	}
+	themeConfig, v, err := dir.runConfigFiles(resolve("config.js"), resolve(templateConfigPath))
+	if err != nil {
+		return nil, err
+	}
+	err = compiled.config.Unmarshal(resolve, v)
+	if err != nil {
+		return nil, err
+	}
+	// the theme's ContentSecurityPolicy applies to all of its templates, even
+	// if a template config does not pass it along from $CONFIG
+	if m, ok := themeConfig.(map[string]interface{}); ok {
+		themeCSP := unmarshalCSP(m["ContentSecurityPolicy"])
+		if compiled.config.contentSecurityPolicy == nil && themeCSP != nil {
+			compiled.config.contentSecurityPolicy = make(map[string][]string)
+		}
+		mergeCSP(compiled.config.contentSecurityPolicy, themeCSP)
+	}
+	if len(compiled.config.html) == 0 {
+		return nil, fmt.Errorf("no files provided")
+	}
+	compiled.tmpl = template.New("").Funcs(dir.funcs())
+	for _, html := range compiled.config.html {
 		html = strings.TrimPrefix(html, "/")
-		b, err = fs.ReadFile(dir.fsys, html)
+		if _, ok := compiled.modTimes[html]; !ok {
+			compiled.modTimes[html] = dir.modTime(html)
+		}
+		b, err := fs.ReadFile(dir.fsys, html)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		_, err = t.New(html).Parse(string(b))
+		_, err = compiled.tmpl.New(html).Parse(string(b))
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
-	err = t.ExecuteTemplate(w, strings.TrimPrefix(tconfig.html[0], "/"), data)
-	return err
+	compiled.name = strings.TrimPrefix(compiled.config.html[0], "/")
+	if useCache {
+		dir.cache.Set(cacheKey, compiled, 0)
+	}
+	return compiled, nil
+}
+
+// modTime returns the modification time of a file in dir.fsys, or the zero
+// time if it does not exist.
+func (dir *TemplateDir) modTime(name string) time.Time {
+	info, err := fs.Stat(dir.fsys, name)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// modified reports whether any of the files have been modified, created or
+// deleted since their modification times were recorded.
+func (dir *TemplateDir) modified(modTimes map[string]time.Time) bool {
+	for name, modTime := range modTimes {
+		if !dir.modTime(name).Equal(modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+// Invalidate drops every compiled template and config from the cache, so
+// that they are recompiled on their next use. Changes to files in the fs.FS
+// are picked up without calling Invalidate, as long as the fs.FS reports
+// modification times.
+func (dir *TemplateDir) Invalidate() {
+	dir.cache.DeletePrefix("templatedir:")
+}
+
+// runConfig evaluates the config file subDir/name, with $CONFIG set to the
+// value returned by subDir/config.js (if it exists). It returns both the
+// value of config.js and the value of the config file.
+func (dir *TemplateDir) runConfig(subDir, name string) (configjs, v interface{}, err error) {
+	return dir.runConfigFiles(subDir+"/config.js", subDir+"/"+name)
+}
+
+// runConfigFiles is runConfig with the paths of config.js and the config
+// file given separately, as they may come from different themes.
+func (dir *TemplateDir) runConfigFiles(configjsPath, name string) (configjs, v interface{}, err error) {
+	b, err := fs.ReadFile(dir.fsys, configjsPath)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, nil, err
+		}
+		configjs = map[string]interface{}{}
+	} else {
+		configjs, err = dir.runJS(configjsPath, string(b), nil)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	b, err = fs.ReadFile(dir.fsys, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	v, err = dir.runJS(name, string(b), map[string]interface{}{"$CONFIG": configjs})
+	if err != nil {
+		return nil, nil, err
+	}
+	return configjs, v, nil
 }
 
 func (data templateData) CSS() (template.HTML, error) {
@@ -300,12 +739,12 @@ func (data templateData) CSS() (template.HTML, error) {
 		buf.Reset()
 		bufpool.Put(buf)
 	}()
+	if data.EditMode {
+		buf.WriteString("\n" + `<link rel="stylesheet" href="` + data.assetURLPrefix + `editmode.css">`)
+	}
 	for _, css := range data.css {
-		if strings.HasPrefix(css, "/") {
-			buf.WriteString("\n" + `<script src="` + css + `"></script>`)
-		} else {
-			buf.WriteString("\n" + `<script src="` + data.assetURLPrefix + css + `"></script>`)
-		}
+		url, integrity := data.assetURL(css)
+		buf.WriteString("\n" + `<link rel="stylesheet" href="` + template.HTMLEscapeString(url) + `"` + integrityAttr(integrity) + `>`)
 	}
 	return template.HTML(buf.String()), nil
 }
@@ -316,45 +755,72 @@ func (data templateData) JS() (template.HTML, error) {
 		buf.Reset()
 		bufpool.Put(buf)
 	}()
-	buf.WriteString(`<script type="application/json" data-env>`)
-	err := json.NewEncoder(buf).Encode(map[string]interface{}{
+	env := map[string]interface{}{
 		"URL":        data.URL,
 		"Namespace":  data.Namespace,
 		"LocaleCode": data.LocaleCode,
 		"EditMode":   data.EditMode,
-	})
+		"SaveURL":    data.assetURLPrefix + "save",
+		"PublishURL": data.assetURLPrefix + "publish",
+		"UploadURL":  data.uploadURL,
+	}
+	if data.devMode {
+		env["ReloadURL"] = data.assetURLPrefix + "reload"
+	}
+	nonce := ""
+	if data.nonce != "" {
+		nonce = ` nonce="` + data.nonce + `"`
+	}
+	buf.WriteString(`<script type="application/json" data-env` + nonce + `>`)
+	err := json.NewEncoder(buf).Encode(env)
 	if err != nil {
 		return "", err
 	}
 	buf.WriteString(`</script>`)
-	buf.WriteString("\n" + `<script src="` + data.assetURLPrefix + `env.js"></script>`)
+	buf.WriteString("\n" + `<script src="` + data.assetURLPrefix + `env.js"` + nonce + `></script>`)
+	if data.devMode {
+		buf.WriteString("\n" + `<script src="` + data.assetURLPrefix + `devmode.js"` + nonce + `></script>`)
+	}
+	if data.EditMode {
+		buf.WriteString("\n" + `<script src="` + data.assetURLPrefix + `editmode.js"` + nonce + `></script>`)
+	}
 	for _, js := range data.js {
-		if strings.HasPrefix(js, "/") {
-			buf.WriteString("\n" + `<script src="` + js + `"></script>`)
-		} else {
-			buf.WriteString("\n" + `<script src="` + data.assetURLPrefix + js + `"></script>`)
-		}
+		url, integrity := data.assetURL(js)
+		buf.WriteString("\n" + `<script src="` + template.HTMLEscapeString(url) + `"` + integrityAttr(integrity) + nonce + `></script>`)
 	}
 	return template.HTML(buf.String()), nil
 }
 
-func (data templateData) ContentSecurityPolicy() (template.HTML, error) {
-	return "", nil
-}
-
 func (dir *TemplateDir) funcs() map[string]interface{} {
-	return map[string]interface{}{
-		"getValue": func(data templateData, name string, opts ...func(data *templateData)) (value NullString, err error) {
-			for _, opt := range opts {
-				opt(&data)
+	funcs := map[string]interface{}{
+		"getValue": dir.getValue,
+		"getRows":  dir.getRows,
+		"valueOr": func(data templateData, name string, fallback string, opts ...func(data *templateData)) (string, error) {
+			value, err := dir.getValue(data, name, opts...)
+			if err != nil || !value.Valid {
+				return fallback, err
 			}
-			return dir.store.GetValue(data.LocaleCode, data.Namespace, name)
+			return value.Str, nil
 		},
-		"getRows": func(data templateData, name string, opts ...func(data *templateData)) (rows []map[string]interface{}, err error) {
-			for _, opt := range opts {
-				opt(&data)
+		"getJSON": func(data templateData, name string, opts ...func(data *templateData)) (interface{}, error) {
+			value, err := dir.getValue(data, name, opts...)
+			if err != nil || !value.Valid {
+				return nil, err
+			}
+			var v interface{}
+			err = json.Unmarshal([]byte(value.Str), &v)
+			if err != nil {
+				return nil, fmt.Errorf("getJSON %q: %w", name, err)
 			}
-			return dir.store.GetRows(data.LocaleCode, data.Namespace, name)
+			return v, nil
+		},
+		"getRowsPaged": dir.getRowsPaged,
+		"getRowsSorted": func(data templateData, name string, column string, opts ...func(data *templateData)) ([]map[string]interface{}, error) {
+			rows, err := dir.getRows(data, name, opts...)
+			if err != nil {
+				return nil, err
+			}
+			return sortRows(rows, column), nil
 		},
 		"safeHTML": func(s string) template.HTML { return template.HTML(s) },
 		"namespace": func(namespace string) func(data *templateData) {
@@ -364,6 +830,97 @@ func (dir *TemplateDir) funcs() map[string]interface{} {
 			return func(data *templateData) { data.LocaleCode = localeCode }
 		},
 	}
+	for name, fn := range dir.funcMap {
+		funcs[name] = fn
+	}
+	return funcs
+}
+
+// getValue is the getValue template func. It returns the value called name in
+// the page's namespace and locale, trying the fallback locales if there is
+// none. Options like namespace and localeCode change where it looks.
+func (dir *TemplateDir) getValue(data templateData, name string, opts ...func(data *templateData)) (value NullString, err error) {
+	for _, opt := range opts {
+		opt(&data)
+	}
+	for _, localeCode := range append([]string{data.LocaleCode}, dir.localeFallbacks(data.LocaleCode)...) {
+		if data.preview {
+			value, err = dir.store.GetDraftValue(localeCode, data.Namespace, name)
+			if err != nil || value.Valid {
+				return value, err
+			}
+		}
+		value, err = dir.store.GetValue(localeCode, data.Namespace, name)
+		if err != nil || value.Valid {
+			return value, err
+		}
+	}
+	return value, nil
+}
+
+// getRows is the getRows template func, which works like getValue but for
+// rows.
+func (dir *TemplateDir) getRows(data templateData, name string, opts ...func(data *templateData)) (rows []map[string]interface{}, err error) {
+	for _, opt := range opts {
+		opt(&data)
+	}
+	for _, localeCode := range append([]string{data.LocaleCode}, dir.localeFallbacks(data.LocaleCode)...) {
+		if data.preview {
+			var ok bool
+			rows, ok, err = dir.store.GetDraftRows(localeCode, data.Namespace, name)
+			if err != nil || len(rows) > 0 {
+				return rows, err
+			}
+			if ok {
+				// every row was deleted in the draft, so the published rows
+				// must not show through
+				continue
+			}
+		}
+		rows, err = dir.store.GetRows(localeCode, data.Namespace, name)
+		if err != nil || len(rows) > 0 {
+			return rows, err
+		}
+	}
+	return rows, nil
+}
+
+// getRowsPaged is the getRowsPaged template func. It is getRows limited to at
+// most limit rows starting from offset, which are fetched from the store if it
+// is a RowsPager.
+func (dir *TemplateDir) getRowsPaged(data templateData, name string, limit, offset int, opts ...func(data *templateData)) ([]map[string]interface{}, error) {
+	pager, ok := dir.store.(RowsPager)
+	if !ok {
+		rows, err := dir.getRows(data, name, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return pageRows(rows, limit, offset), nil
+	}
+	for _, opt := range opts {
+		opt(&data)
+	}
+	for _, localeCode := range append([]string{data.LocaleCode}, dir.localeFallbacks(data.LocaleCode)...) {
+		if data.preview {
+			rows, ok, err := dir.store.GetDraftRows(localeCode, data.Namespace, name)
+			if err != nil {
+				return nil, err
+			}
+			if len(rows) > 0 {
+				return pageRows(rows, limit, offset), nil
+			}
+			if ok {
+				continue
+			}
+		}
+		// the total decides whether to fall back, since a page past the
+		// last row is empty even if there are rows
+		rows, total, err := pager.GetRowsPage(localeCode, data.Namespace, name, limit, offset)
+		if err != nil || total > 0 {
+			return rows, err
+		}
+	}
+	return nil, nil
 }
 
 type templateConfig struct {
@@ -374,7 +931,10 @@ type templateConfig struct {
 	contentSecurityPolicy map[string][]string
 }
 
-func (cfg *templateConfig) Unmarshal(subDir string, v interface{}) error {
+// Unmarshal reads the value returned by a template config. Paths that do not
+// start with "/" are relative to the theme, and are turned into paths in the
+// fs.FS by resolve.
+func (cfg *templateConfig) Unmarshal(resolve func(name string) string, v interface{}) error {
 	m, ok := v.(map[string]interface{})
 	if !ok {
 		return fmt.Errorf("not a map")
@@ -385,7 +945,7 @@ func (cfg *templateConfig) Unmarshal(subDir string, v interface{}) error {
 				if strings.HasPrefix(html, "/") {
 					cfg.html = append(cfg.html, html)
 				} else {
-					cfg.html = append(cfg.html, subDir+"/"+html)
+					cfg.html = append(cfg.html, resolve(html))
 				}
 			}
 		}
@@ -396,7 +956,7 @@ func (cfg *templateConfig) Unmarshal(subDir string, v interface{}) error {
 				if strings.HasPrefix(css, "/") {
 					cfg.css = append(cfg.css, css)
 				} else {
-					cfg.css = append(cfg.css, subDir+"/"+css)
+					cfg.css = append(cfg.css, resolve(css))
 				}
 			}
 		}
@@ -407,25 +967,19 @@ func (cfg *templateConfig) Unmarshal(subDir string, v interface{}) error {
 				if strings.HasPrefix(js, "/") {
 					cfg.js = append(cfg.js, js)
 				} else {
-					cfg.js = append(cfg.js, subDir+"/"+js)
+					cfg.js = append(cfg.js, resolve(js))
 				}
 			}
 		}
 	}
 	cfg.vars, _ = m["Vars"].(map[string]interface{})
-	if __csp__, ok := m["ContentSecurityPolicy"].(map[string][]interface{}); ok {
+	// goja exports objects as map[string]interface{} and arrays as
+	// []interface{}, never as map[string][]interface{}
+	if csp := unmarshalCSP(m["ContentSecurityPolicy"]); csp != nil {
 		if cfg.contentSecurityPolicy == nil {
 			cfg.contentSecurityPolicy = make(map[string][]string)
 		}
-		for policy, __values__ := range __csp__ {
-			var values []string
-			for _, __value__ := range __values__ {
-				if value, ok := __value__.(string); ok {
-					values = append(values, value)
-				}
-			}
-			cfg.contentSecurityPolicy[policy] = values
-		}
+		mergeCSP(cfg.contentSecurityPolicy, csp)
 	}
 	return nil
 }
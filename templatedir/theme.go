@@ -0,0 +1,281 @@
+package templatedir
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Theme is a directory in the fs.FS containing a theme.config.js. The
+// theme.config.js returns the theme's manifest, for example:
+//
+//	return {
+//	  Name: "plainsimple",
+//	  Extends: "basetheme",
+//	  Description: "Just a plain simple theme",
+//	  FallbackAssets: { "/pm-images/plainsimple/hero.jpg": "hero.jpg" },
+//	};
+type Theme struct {
+	// Path is the theme directory, relative to the root of the fs.FS.
+	Path        string
+	Name        string
+	Description string
+	// Extends is the Path of the parent theme, if any. Files that the theme
+	// does not have are looked up in its parent, and then in its parent's
+	// parent and so on. That includes template configs, config.js and any
+	// HTML, CSS or JS files referred to by a relative path.
+	Extends string
+	// FallbackAssets maps URL paths to the files served for them if nothing
+	// else does. Files are relative to the root of the fs.FS.
+	FallbackAssets map[string]string
+	// Templates are the template configs (*.config.js) in the theme
+	// directory and the directories of the themes it extends, relative to the
+	// theme directory.
+	Templates []string
+}
+
+// ThemeErrors is every error found while loading the themes in a fs.FS.
+type ThemeErrors []error
+
+func (errs ThemeErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// ListThemes loads every theme in the fs.FS, sorted by Path. Directories whose
+// names start with a dot are skipped. Themes that fail
+// to load are left out and their errors are returned together as ThemeErrors,
+// so the themes that did load are usable even if err is not nil.
+func (dir *TemplateDir) ListThemes() ([]Theme, error) {
+	var themes []Theme
+	var errs ThemeErrors
+	err := fs.WalkDir(dir.fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// hidden directories hold things like themes that are still being
+		// installed, which must not be picked up
+		if d.IsDir() && name != "." && strings.HasPrefix(d.Name(), ".") {
+			return fs.SkipDir
+		}
+		if d.IsDir() || d.Name() != "theme.config.js" {
+			return nil
+		}
+		theme, err := dir.LoadTheme(path.Dir(name))
+		if themeErrs, ok := err.(ThemeErrors); ok {
+			errs = append(errs, themeErrs...)
+			return nil
+		}
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		themes = append(themes, theme)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(themes, func(i, j int) bool { return themes[i].Path < themes[j].Path })
+	if len(errs) > 0 {
+		return themes, errs
+	}
+	return themes, nil
+}
+
+// LoadTheme evaluates the theme.config.js in subDir and checks that it has a
+// Name and that every FallbackAsset exists. All problems with the manifest
+// are reported at once as ThemeErrors.
+func (dir *TemplateDir) LoadTheme(subDir string) (Theme, error) {
+	subDir = strings.TrimPrefix(strings.TrimSuffix(subDir, "/"), "/")
+	theme := Theme{Path: subDir, FallbackAssets: make(map[string]string)}
+	_, v, err := dir.runConfig(subDir, "theme.config.js")
+	if err != nil {
+		return theme, fmt.Errorf("%s/theme.config.js: %w", subDir, err)
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return theme, fmt.Errorf("%s/theme.config.js: did not return an object", subDir)
+	}
+	var errs ThemeErrors
+	theme.Name, _ = m["Name"].(string)
+	if strings.TrimSpace(theme.Name) == "" {
+		errs = append(errs, fmt.Errorf("%s/theme.config.js: Name is required", subDir))
+	}
+	if v, ok := m["Description"]; ok {
+		if theme.Description, ok = v.(string); !ok {
+			errs = append(errs, fmt.Errorf("%s/theme.config.js: Description is not a string", subDir))
+		}
+	}
+	if v, ok := m["FallbackAssets"]; ok {
+		fallbackAssets, ok := v.(map[string]interface{})
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s/theme.config.js: FallbackAssets is not an object", subDir))
+		}
+		for urlPath, __file__ := range fallbackAssets {
+			file, ok := __file__.(string)
+			if !ok {
+				errs = append(errs, fmt.Errorf("%s/theme.config.js: FallbackAssets[%q] is not a string", subDir, urlPath))
+				continue
+			}
+			if !strings.HasPrefix(file, "/") {
+				file = subDir + "/" + file
+			}
+			file = strings.TrimPrefix(file, "/")
+			if _, err := fs.Stat(dir.fsys, file); err != nil {
+				errs = append(errs, fmt.Errorf("%s/theme.config.js: FallbackAssets[%q]: %w", subDir, urlPath, err))
+				continue
+			}
+			theme.FallbackAssets[urlPath] = file
+		}
+	}
+	if v, ok := m["Extends"]; ok {
+		if theme.Extends, ok = v.(string); !ok {
+			errs = append(errs, fmt.Errorf("%s/theme.config.js: Extends is not a string", subDir))
+		}
+		theme.Extends = strings.Trim(theme.Extends, "/")
+	}
+	chain, err := dir.themeChain(subDir)
+	if err != nil {
+		errs = append(errs, err)
+		chain = []string{subDir}
+	}
+	seen := make(map[string]bool)
+	for _, themePath := range chain {
+		entries, err := fs.ReadDir(dir.fsys, themePath)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || !strings.HasSuffix(name, ".config.js") || name == "theme.config.js" || seen[name] {
+				continue
+			}
+			seen[name] = true
+			theme.Templates = append(theme.Templates, name)
+		}
+	}
+	sort.Strings(theme.Templates)
+	if len(errs) > 0 {
+		return theme, errs
+	}
+	return theme, nil
+}
+
+// maxExtendsDepth is the longest chain of themes extending each other.
+const maxExtendsDepth = 8
+
+// themeChain returns subDir followed by the theme it extends, the theme that
+// theme extends and so on.
+func (dir *TemplateDir) themeChain(subDir string) ([]string, error) {
+	chain := []string{subDir}
+	for current := subDir; ; {
+		if _, err := fs.Stat(dir.fsys, current+"/theme.config.js"); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return chain, nil
+			}
+			return nil, err
+		}
+		_, v, err := dir.runConfig(current, "theme.config.js")
+		if err != nil {
+			return nil, err
+		}
+		m, _ := v.(map[string]interface{})
+		parent, _ := m["Extends"].(string)
+		parent = strings.Trim(parent, "/")
+		if parent == "" {
+			return chain, nil
+		}
+		for _, themePath := range chain {
+			if themePath == parent {
+				return nil, fmt.Errorf("%s/theme.config.js: Extends %q, which leads to a cycle: %s", current, parent, strings.Join(append(chain, parent), " -> "))
+			}
+		}
+		if _, err := fs.Stat(dir.fsys, parent+"/theme.config.js"); err != nil {
+			return nil, fmt.Errorf("%s/theme.config.js: Extends %q, which is not a theme", current, parent)
+		}
+		chain = append(chain, parent)
+		if len(chain) > maxExtendsDepth {
+			return nil, fmt.Errorf("%s: themes extend each other more than %d levels deep", subDir, maxExtendsDepth)
+		}
+		current = parent
+	}
+}
+
+// ValidateTheme compiles every template in the theme and checks that the CSS
+// and JS files it refers to by a relative path exist, looking them up through
+// the themes it extends. All problems are reported at once as ThemeErrors.
+func (dir *TemplateDir) ValidateTheme(theme Theme) error {
+	var errs ThemeErrors
+	for _, templateConfigPath := range theme.Templates {
+		compiled, err := dir.compile(theme.Path, templateConfigPath, true)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s/%s: %w", theme.Path, templateConfigPath, err))
+			continue
+		}
+		// absolute paths are served by something other than the theme, so
+		// only the relative ones (already resolved by compile) are checked
+		for _, files := range [][]string{compiled.config.css, compiled.config.js} {
+			for _, file := range files {
+				if strings.HasPrefix(file, "/") {
+					continue
+				}
+				if _, err := fs.Stat(dir.fsys, file); err != nil {
+					errs = append(errs, fmt.Errorf("%s/%s: %w", theme.Path, templateConfigPath, err))
+				}
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Reload drops every cached template and reloads the themes, for when themes
+// have been added, removed or replaced in the fs.FS while the TemplateDir is
+// in use.
+func (dir *TemplateDir) Reload() error {
+	dir.Invalidate()
+	return dir.loadThemes()
+}
+
+// loadThemes replaces the registered FallbackAssets with those of every
+// theme that loads. Broken themes are only reported if ValidateThemes is set,
+// in which case every template of every theme is compiled as well. Otherwise
+// they are left to fail when one of their templates is served.
+func (dir *TemplateDir) loadThemes() error {
+	themes, err := dir.ListThemes()
+	var errs ThemeErrors
+	if err != nil {
+		themeErrs, ok := err.(ThemeErrors)
+		if !ok {
+			return err
+		}
+		errs = append(errs, themeErrs...)
+	}
+	fallbackAssets := make(map[string]string)
+	for _, theme := range themes {
+		for urlPath, file := range theme.FallbackAssets {
+			fallbackAssets[urlPath] = file
+		}
+		if dir.validateThemes {
+			if err := dir.ValidateTheme(theme); err != nil {
+				errs = append(errs, err.(ThemeErrors)...)
+			}
+		}
+	}
+	dir.fallbackAssetsMu.Lock()
+	dir.fallbackAssets = fallbackAssets
+	dir.fallbackAssetsMu.Unlock()
+	if dir.validateThemes && len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
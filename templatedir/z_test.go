@@ -1,13 +1,23 @@
 package templatedir
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"strconv"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/bokwoon95/pagemanager/testutil"
 	"github.com/dop251/goja"
@@ -94,3 +104,654 @@ func Test_Assets(t *testing.T) {
 		fmt.Println(url, rr.Result().Status, rr.Body.String())
 	}
 }
+
+func Test_save(t *testing.T) {
+	is := testutil.New(t)
+	_, currentfile, _, _ := runtime.Caller(0)
+	themesdir := filepath.Join(filepath.Dir(filepath.Dir(currentfile)), "pm-themes")
+	store := newVstore()
+	dir, err := New(os.DirFS(themesdir), store, EditPermission(func(r *http.Request, namespace string) bool { return true }))
+	is.NoErr(err)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("next called for %s", r.URL.Path)
+	})
+	post := func(body string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("POST", "/templatedir/save", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		dir.Assets(next).ServeHTTP(rr, r)
+		return rr
+	}
+
+	rr := post(`{
+		"LocaleCode": "en",
+		"Values": {"bokwoon95/plainsimple": {"title": "My <em>Blog</em><script>alert(1)</script>"}},
+		"Rows": {"/hello": {"posts": [
+			{"Values": {"title": "a"}, "Hrefs": {"link": "/a"}},
+			{"Values": {"title": "<b onclick=\"alert(1)\">b</b>"}, "Hrefs": {"link": "https://example.com/b"}}
+		]}}
+	}`)
+	is.Equal(http.StatusNoContent, rr.Code)
+	// edits are saved as drafts
+	value, _ := store.GetValue("en", "bokwoon95/plainsimple", "title")
+	is.Equal(NullString{}, value)
+	value, _ = store.GetDraftValue("en", "bokwoon95/plainsimple", "title")
+	is.Equal(NullString{Valid: true, Str: "My <em>Blog</em>"}, value)
+	rows, ok, _ := store.GetDraftRows("en", "/hello", "posts")
+	is.True(ok)
+	is.Equal([]map[string]interface{}{
+		{"title": "a", "link": "/a"},
+		{"title": "<b>b</b>", "link": "https://example.com/b"},
+	}, rows)
+
+	publish := func(body string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("POST", "/templatedir/publish", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		dir.Assets(next).ServeHTTP(rr, r)
+		return rr
+	}
+	is.Equal(http.StatusBadRequest, publish(`{"Namespace": "/hello world"}`).Code)
+	is.Equal(http.StatusBadRequest, publish(`{"Rows": {"/hello": ["bad name"]}}`).Code)
+	is.Equal(http.StatusNoContent, publish(`{"Namespace": "/hello", "LocaleCode": "en", "Rows": {"/hello": ["posts"]}}`).Code)
+	rows, _ = store.GetRows("en", "/hello", "posts")
+	is.Equal(2, len(rows))
+	_, ok, _ = store.GetDraftRows("en", "/hello", "posts")
+	is.True(!ok)
+	value, _ = store.GetValue("en", "bokwoon95/plainsimple", "title")
+	is.Equal(NullString{}, value)
+
+	// two pages share the theme namespace: publishing one page publishes
+	// only the keys it shows, in its own locale
+	rr = post(`{
+		"LocaleCode": "en",
+		"Values": {"bokwoon95/plainsimple": {"subtitle": "other page draft"}}
+	}`)
+	is.Equal(http.StatusNoContent, rr.Code)
+	rr = post(`{
+		"LocaleCode": "fr",
+		"Values": {"bokwoon95/plainsimple": {"title": "Mon Blog"}}
+	}`)
+	is.Equal(http.StatusNoContent, rr.Code)
+	is.Equal(http.StatusNoContent, publish(`{"Namespace": "/hello", "LocaleCode": "en", "Values": {"bokwoon95/plainsimple": ["title"]}}`).Code)
+	value, _ = store.GetValue("en", "bokwoon95/plainsimple", "title")
+	is.Equal(NullString{Valid: true, Str: "My <em>Blog</em>"}, value)
+	value, _ = store.GetValue("en", "bokwoon95/plainsimple", "subtitle")
+	is.Equal(NullString{}, value)
+	value, _ = store.GetDraftValue("en", "bokwoon95/plainsimple", "subtitle")
+	is.Equal("other page draft", value.Str)
+	value, _ = store.GetValue("fr", "bokwoon95/plainsimple", "title")
+	is.Equal(NullString{}, value)
+	value, _ = store.GetDraftValue("fr", "bokwoon95/plainsimple", "title")
+	is.Equal("Mon Blog", value.Str)
+
+	for _, body := range []string{
+		`{"LocaleCode": "en US"}`,
+		`{"Values": {"": {"title": "x"}}}`,
+		`{"Values": {"/hello": {"bad name": "x"}}}`,
+		`{"Rows": {"/hello": {"posts": [{"Hrefs": {"link": "javascript:alert(1)"}}]}}}`,
+		`{"Values": {"/hello": {"title": "x"}}, "Rows": {"/hello world": {"posts": []}}}`,
+		`not json`,
+	} {
+		rr = post(body)
+		is.Equal(http.StatusBadRequest, rr.Code)
+	}
+	value, _ = store.GetDraftValue("", "/hello", "title")
+	is.Equal(NullString{}, value)
+
+	r, _ := http.NewRequest("GET", "/templatedir/save", nil)
+	rr = httptest.NewRecorder()
+	dir.Assets(next).ServeHTTP(rr, r)
+	is.Equal(http.StatusMethodNotAllowed, rr.Code)
+}
+
+func Test_EditPermission(t *testing.T) {
+	is := testutil.New(t)
+	_, currentfile, _, _ := runtime.Caller(0)
+	themesdir := filepath.Join(filepath.Dir(filepath.Dir(currentfile)), "pm-themes")
+	store := newVstore()
+	dir, err := New(os.DirFS(themesdir), store, EditPermission(func(r *http.Request, namespace string) bool {
+		return r.Header.Get("X-Editor") != "" && strings.HasPrefix(namespace, "/blog/")
+	}))
+	is.NoErr(err)
+	post := func(body string, editor bool) int {
+		r, _ := http.NewRequest("POST", "/templatedir/save", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		if editor {
+			r.Header.Set("X-Editor", "1")
+		}
+		rr := httptest.NewRecorder()
+		dir.Assets(http.NotFoundHandler()).ServeHTTP(rr, r)
+		return rr.Code
+	}
+	is.Equal(http.StatusNoContent, post(`{"Values": {"/blog/a": {"title": "a"}}}`, true))
+	is.Equal(http.StatusForbidden, post(`{"Values": {"/blog/a": {"title": "a"}}}`, false))
+	is.Equal(http.StatusForbidden, post(`{"Namespace": "/about", "Values": {"/blog/a": {"title": "a"}}}`, true))
+	// a payload with any namespace that cannot be edited is rejected whole
+	is.Equal(http.StatusForbidden, post(`{"Values": {"/blog/a": {"title": "b"}}, "Rows": {"/about": {"posts": []}}}`, true))
+	value, _ := store.GetDraftValue("", "/blog/a", "title")
+	is.Equal("a", value.Str)
+	_, ok, _ := store.GetDraftRows("", "/about", "posts")
+	is.True(!ok)
+	publish := func(body string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("POST", "/templatedir/publish", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-Editor", "1")
+		rr := httptest.NewRecorder()
+		dir.Assets(http.NotFoundHandler()).ServeHTTP(rr, r)
+		return rr
+	}
+	rr := publish(`{"Values": {"/blog/a": ["title"], "/about": ["title"], "/": ["title"]}}`)
+	is.Equal(http.StatusForbidden, rr.Code)
+	is.Equal(`not allowed to edit namespace "/", "/about"`, strings.TrimSpace(rr.Body.String()))
+	value, _ = store.GetValue("", "/blog/a", "title")
+	is.True(!value.Valid)
+	is.Equal(http.StatusNoContent, publish(`{"Values": {"/blog/a": ["title"]}}`).Code)
+	value, _ = store.GetValue("", "/blog/a", "title")
+	is.Equal("a", value.Str)
+
+	editMode := func(url string, editor bool) bool {
+		r, _ := http.NewRequest("GET", url, nil)
+		if editor {
+			r.Header.Set("X-Editor", "1")
+		}
+		buf := &strings.Builder{}
+		is.NoErr(dir.ServeTemplate(buf, r, "plainsimple", "index.config.js", EditMode(true)))
+		return strings.Contains(buf.String(), `"EditMode":true`)
+	}
+	is.True(editMode("/blog/a", true))
+	is.True(!editMode("/blog/a", false))
+	is.True(!editMode("/about", true))
+	// only EditMode pages load the editor
+	serve := func(editor bool) string {
+		r, _ := http.NewRequest("GET", "/blog/a", nil)
+		if editor {
+			r.Header.Set("X-Editor", "1")
+		}
+		buf := &strings.Builder{}
+		is.NoErr(dir.ServeTemplate(buf, r, "plainsimple", "index.config.js", EditMode(true)))
+		return buf.String()
+	}
+	page := serve(true)
+	is.True(regexp.MustCompile(`<script src="/templatedir/editmode.js" nonce="[^"]+"></script>`).MatchString(page))
+	is.True(strings.Contains(page, `<link rel="stylesheet" href="/templatedir/editmode.css">`))
+	page = serve(false)
+	is.True(!strings.Contains(page, "editmode.js"))
+	is.True(!strings.Contains(page, "editmode.css"))
+	// without an EditPermission nothing can be edited
+	dir, err = New(os.DirFS(themesdir), store)
+	is.NoErr(err)
+	is.Equal(http.StatusForbidden, post(`{"Values": {"/blog/a": {"title": "a"}}}`, true))
+	is.Equal(http.StatusForbidden, publish(`{"Namespace": "/blog/a", "Values": {"/blog/a": ["title"]}}`).Code)
+	is.True(!editMode("/blog/a", true))
+}
+
+func Test_compile(t *testing.T) {
+	is := testutil.New(t)
+	modTime := time.Now()
+	fsys := fstest.MapFS{
+		"theme/index.config.js": {Data: []byte(`return { HTML: ["index.html"] }`), ModTime: modTime},
+		"theme/index.html":      {Data: []byte(`v1`), ModTime: modTime},
+	}
+	dir, err := New(fsys, newVstore())
+	is.NoErr(err)
+	serve := func(dir *TemplateDir) string {
+		r, _ := http.NewRequest("GET", "/", nil)
+		buf := &strings.Builder{}
+		is.NoErr(dir.ServeTemplate(buf, r, "theme", "index.config.js"))
+		return buf.String()
+	}
+
+	is.Equal("v1", serve(dir))
+	compiled, err := dir.compile("theme", "index.config.js", true)
+	is.NoErr(err)
+	cached, err := dir.compile("theme", "index.config.js", true)
+	is.NoErr(err)
+	is.True(compiled == cached)
+
+	// changes are only picked up once the modification time changes
+	fsys["theme/index.html"].Data = []byte(`v2`)
+	is.Equal("v1", serve(dir))
+	fsys["theme/index.html"].ModTime = modTime.Add(time.Second)
+	is.Equal("v2", serve(dir))
+
+	// Invalidate drops the cache regardless of modification times
+	fsys["theme/index.html"].Data = []byte(`v3`)
+	is.Equal("v2", serve(dir))
+	dir.Invalidate()
+	is.Equal("v3", serve(dir))
+
+	devDir, err := New(fsys, newVstore(), DevMode(true))
+	is.NoErr(err)
+	is.Equal("v3", serve(devDir))
+	fsys["theme/index.html"].Data = []byte(`v4`)
+	is.Equal("v4", serve(devDir))
+}
+
+func Test_reload(t *testing.T) {
+	is := testutil.New(t)
+	tmpdir := t.TempDir()
+	is.NoErr(os.MkdirAll(filepath.Join(tmpdir, "theme"), 0755))
+	is.NoErr(os.WriteFile(filepath.Join(tmpdir, "theme", "index.html"), []byte(`v1`), 0644))
+
+	// the reload endpoint does not exist outside DevMode
+	dir, err := New(os.DirFS(tmpdir), newVstore())
+	is.NoErr(err)
+	rec := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/templatedir/reload", nil)
+	dir.Assets(http.NotFoundHandler()).ServeHTTP(rec, r)
+	is.Equal(http.StatusNotFound, rec.Code)
+
+	devDir, err := New(os.DirFS(tmpdir), newVstore(), DevMode(true))
+	is.NoErr(err)
+	server := httptest.NewServer(devDir.Assets(http.NotFoundHandler()))
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/templatedir/reload")
+	is.NoErr(err)
+	defer resp.Body.Close()
+	is.Equal(http.StatusOK, resp.StatusCode)
+	is.Equal("text/event-stream", resp.Header.Get("Content-Type"))
+	is.NoErr(os.WriteFile(filepath.Join(tmpdir, "theme", "index.html"), []byte(`v2 changed`), 0644))
+	b, err := io.ReadAll(resp.Body)
+	is.NoErr(err)
+	is.True(strings.Contains(string(b), "event: reload\n"))
+}
+
+func Test_ContentSecurityPolicy(t *testing.T) {
+	is := testutil.New(t)
+	fsys := fstest.MapFS{
+		"theme/config.js": {Data: []byte(`return {
+  ContentSecurityPolicy: { "img-src": ["images.unsplash.com"], "font-src": ["fonts.gstatic.com"] },
+}`)},
+		"theme/index.config.js": {Data: []byte(`return {
+  HTML: ["index.html"],
+  JS: ["index.js"],
+  ContentSecurityPolicy: { "script-src": ["code.jquery.com"], "object-src": ["example.com"] },
+}`)},
+		"theme/index.html": {Data: []byte(`{{ .ContentSecurityPolicy }}{{ .JS }}`)},
+	}
+	dir, err := New(fsys, newVstore())
+	is.NoErr(err)
+	r, _ := http.NewRequest("GET", "/", nil)
+
+	rec := httptest.NewRecorder()
+	is.NoErr(dir.ServeTemplate(rec, r, "theme", "index.config.js",
+		ContentSecurityPolicy(map[string][]string{"connect-src": {"api.example.com"}}),
+	))
+	csp := rec.Header().Get("Content-Security-Policy")
+	nonce := regexp.MustCompile(`'nonce-([^']+)'`).FindStringSubmatch(csp)
+	is.True(len(nonce) == 2)
+	is.Equal("base-uri 'self'; "+
+		"connect-src 'self' api.example.com; "+
+		"default-src 'self'; "+
+		"font-src 'self' fonts.gstatic.com; "+
+		"img-src 'self' images.unsplash.com; "+
+		"object-src example.com; "+
+		"script-src 'self' code.jquery.com 'nonce-"+nonce[1]+"'; "+
+		"style-src 'self'", csp)
+	body := rec.Body.String()
+	is.True(!strings.Contains(body, "<meta"))
+	is.Equal(3, strings.Count(body, `nonce="`+nonce[1]+`"`))
+
+	// every response gets a new nonce
+	rec2 := httptest.NewRecorder()
+	is.NoErr(dir.ServeTemplate(rec2, r, "theme", "index.config.js"))
+	is.True(rec2.Header().Get("Content-Security-Policy") != csp)
+
+	// without a ResponseWriter the policy is rendered as a meta tag
+	buf := &strings.Builder{}
+	is.NoErr(dir.ServeTemplate(buf, r, "theme", "index.config.js"))
+	is.True(strings.HasPrefix(buf.String(), `<meta http-equiv="Content-Security-Policy" content="base-uri &#39;self&#39;;`))
+
+	rec3 := httptest.NewRecorder()
+	is.NoErr(dir.ServeTemplate(rec3, r, "theme", "index.config.js", DisableCSP(true)))
+	is.Equal("", rec3.Header().Get("Content-Security-Policy"))
+	is.True(!strings.Contains(rec3.Body.String(), "nonce"))
+}
+
+func Test_fingerprint(t *testing.T) {
+	is := testutil.New(t)
+	modTime := time.Now()
+	fsys := fstest.MapFS{
+		"theme/index.config.js": {Data: []byte(`return { HTML: ["index.html"], CSS: ["index.css", "/cdn/lib.css"] }`)},
+		"theme/index.html":      {Data: []byte(`{{ .CSS }}`)},
+		"theme/index.css":       {Data: []byte(`body { color: red; }`), ModTime: modTime},
+	}
+	dir, err := New(fsys, newVstore())
+	is.NoErr(err)
+	serve := func() string {
+		r, _ := http.NewRequest("GET", "/", nil)
+		buf := &strings.Builder{}
+		is.NoErr(dir.ServeTemplate(buf, r, "theme", "index.config.js", DisableCSP(true)))
+		return buf.String()
+	}
+	get := func(url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", url, nil)
+		dir.Assets(http.NotFoundHandler()).ServeHTTP(rec, r)
+		return rec
+	}
+
+	sum := sha256.Sum256([]byte(`body { color: red; }`))
+	url := "/templatedir/theme/index.sha256-" + hex.EncodeToString(sum[:]) + ".css"
+	is.Equal("\n"+`<link rel="stylesheet" href="`+url+`" integrity="sha256-`+base64.StdEncoding.EncodeToString(sum[:])+`">`+
+		"\n"+`<link rel="stylesheet" href="/cdn/lib.css">`, serve())
+
+	rec := get(url)
+	is.Equal(http.StatusOK, rec.Code)
+	is.Equal("body { color: red; }", rec.Body.String())
+	is.Equal(immutableCacheControl, rec.Header().Get("Cache-Control"))
+	rec = get("/templatedir/theme/index.css")
+	is.Equal("", rec.Header().Get("Cache-Control"))
+
+	// after the file changes, the old URL must not be cached as immutable
+	fsys["theme/index.css"] = &fstest.MapFile{Data: []byte(`body { color: blue; }`), ModTime: modTime.Add(time.Second)}
+	is.True(!strings.Contains(serve(), url))
+	rec = get(url)
+	is.Equal("body { color: blue; }", rec.Body.String())
+	is.Equal("no-cache", rec.Header().Get("Cache-Control"))
+}
+
+func Test_ListThemes(t *testing.T) {
+	is := testutil.New(t)
+	_, currentfile, _, _ := runtime.Caller(0)
+	themesdir := filepath.Join(filepath.Dir(filepath.Dir(currentfile)), "pm-themes")
+	dir, err := New(os.DirFS(themesdir), newVstore(), ValidateThemes(true))
+	is.NoErr(err)
+	themes, err := dir.ListThemes()
+	is.NoErr(err)
+	is.Equal(1, len(themes))
+	is.Equal("plainsimple", themes[0].Name)
+	is.Equal([]string{"index.config.js"}, themes[0].Templates)
+	is.Equal("plainsimple/hero.jpg", themes[0].FallbackAssets["/pm-images/plainsimple/hero.jpg"])
+
+	fsys := fstest.MapFS{
+		"good/theme.config.js": {Data: []byte(`return { Name: "good", FallbackAssets: { "/pm-images/good.jpg": "good.jpg" } }`)},
+		"good/good.jpg":        {Data: []byte(`jpg`)},
+		"good/index.config.js": {Data: []byte(`return { HTML: ["index.html"] }`)},
+		"good/index.html":      {Data: []byte(`good`)},
+		"bad/theme.config.js":  {Data: []byte(`return { Description: 5, FallbackAssets: { "/pm-images/bad.jpg": "missing.jpg" } }`)},
+		"ugly/theme.config.js": {Data: []byte(`return { Name: "ugly" }`)},
+		"ugly/a.config.js":     {Data: []byte(`return { HTML: ["a.html"] }`)},
+		"ugly/a.html":          {Data: []byte(`{{ .Broken `)},
+		"ugly/b.config.js":     {Data: []byte(`return {`)},
+		"ugly/c.config.js":     {Data: []byte(`return { HTML: ["c.html"], CSS: ["c.css", "/cdn/lib.css"], JS: ["c.js", "base.js"] }`)},
+		"ugly/c.html":          {Data: []byte(`c`)},
+		"ugly/c.css":           {Data: []byte(`c`)},
+	}
+	// broken themes are not reported by default
+	dir, err = New(fsys, newVstore())
+	is.NoErr(err)
+	f, err := dir.OpenFallbackAsset("/pm-images/good.jpg")
+	is.NoErr(err)
+	f.Close()
+	themes, err = dir.ListThemes()
+	is.Equal(3, len(err.(ThemeErrors)))
+	is.Equal(2, len(themes))
+	is.Equal("good", themes[0].Name)
+	is.Equal("ugly", themes[1].Name)
+	is.Equal([]string{"a.config.js", "b.config.js", "c.config.js"}, themes[1].Templates)
+
+	_, err = New(fsys, newVstore(), ValidateThemes(true))
+	errs, ok := err.(ThemeErrors)
+	is.True(ok)
+	is.Equal(7, len(errs))
+	for _, substr := range []string{
+		"bad/theme.config.js: Name is required",
+		"bad/theme.config.js: Description is not a string",
+		`bad/theme.config.js: FallbackAssets["/pm-images/bad.jpg"]`,
+		"ugly/a.config.js",
+		"ugly/b.config.js",
+		"ugly/c.config.js: open ugly/c.js",
+		"ugly/c.config.js: open ugly/base.js",
+	} {
+		is.True(strings.Contains(err.Error(), substr))
+	}
+}
+
+func Test_runJS(t *testing.T) {
+	is := testutil.New(t)
+	fsys := fstest.MapFS{
+		"theme/loop.config.js":        {Data: []byte("return {\n  HTML: (function() { while (true) {} })(),\n}")},
+		"theme/recurse.config.js":     {Data: []byte("function f() { return f() }\nreturn f()")},
+		"theme/reference.config.js":   {Data: []byte("return {\n  HTML: [index],\n}")},
+		"theme/syntax.config.js":      {Data: []byte("return {\n  HTML: [,,\n")},
+		"theme/globals.config.js":     {Data: []byte(`return [typeof eval, typeof Reflect, typeof Proxy, typeof JSON, typeof $CONFIG]`)},
+		"theme/function.config.js":    {Data: []byte(`return Function("return 1")()`)},
+		"theme/constructor.config.js": {Data: []byte(`return (function() {}).constructor("return 1")()`)},
+	}
+	dir, err := New(fsys, newVstore(), ConfigTimeout(50*time.Millisecond))
+	is.NoErr(err)
+	run := func(name string) (interface{}, *ConfigError) {
+		v, err := dir.runJS("theme/"+name, string(fsys["theme/"+name].Data), map[string]interface{}{"$CONFIG": map[string]interface{}{}})
+		if err == nil {
+			return v, nil
+		}
+		configErr, ok := err.(*ConfigError)
+		is.True(ok)
+		return v, configErr
+	}
+
+	_, err = dir.compile("theme", "loop.config.js", true)
+	is.True(errors.Is(err, ErrConfigTimeout))
+	is.Equal("theme/loop.config.js: config took too long to evaluate", err.Error())
+
+	_, configErr := run("recurse.config.js")
+	is.True(errors.Is(configErr, ErrConfigStackOverflow))
+
+	_, configErr = run("reference.config.js")
+	is.Equal("theme/reference.config.js:2:10: ReferenceError: index is not defined", configErr.Error())
+
+	_, configErr = run("syntax.config.js")
+	is.Equal("theme/syntax.config.js", configErr.File)
+	is.True(configErr.Line > 0)
+	is.True(strings.Contains(configErr.Message, "SyntaxError"))
+
+	v, configErr := run("globals.config.js")
+	is.True(configErr == nil)
+	is.Equal([]interface{}{"undefined", "undefined", "undefined", "object", "object"}, v)
+
+	// strings cannot be compiled into code
+	_, configErr = run("function.config.js")
+	is.True(configErr != nil)
+	is.True(strings.Contains(configErr.Message, "ReferenceError: Function is not defined"))
+	_, configErr = run("constructor.config.js")
+	is.True(configErr != nil)
+	is.True(strings.Contains(configErr.Message, "TypeError"))
+}
+
+func Test_Extends(t *testing.T) {
+	is := testutil.New(t)
+	modTime := time.Now()
+	fsys := fstest.MapFS{
+		"base/theme.config.js":  {Data: []byte(`return { Name: "base" }`)},
+		"base/index.config.js":  {Data: []byte(`return { HTML: ["index.html", "navbar.html"], CSS: ["index.css"] }`)},
+		"base/index.html":       {Data: []byte(`{{ template "nav" }} {{ .CSS }}`)},
+		"base/navbar.html":      {Data: []byte(`{{ define "nav" }}base nav{{ end }}`)},
+		"base/index.css":        {Data: []byte(`body {}`)},
+		"child/theme.config.js": {Data: []byte(`return { Name: "child", Extends: "base" }`), ModTime: modTime},
+		"child/navbar.html":     {Data: []byte(`{{ define "nav" }}child nav{{ end }}`)},
+		"loop1/theme.config.js": {Data: []byte(`return { Name: "loop1", Extends: "loop2" }`)},
+		"loop2/theme.config.js": {Data: []byte(`return { Name: "loop2", Extends: "/loop1/" }`)},
+	}
+	dir, err := New(fsys, newVstore())
+	is.NoErr(err)
+	serve := func(subDir string) string {
+		r, _ := http.NewRequest("GET", "/", nil)
+		buf := &strings.Builder{}
+		is.NoErr(dir.ServeTemplate(buf, r, subDir, "index.config.js", DisableCSP(true)))
+		return buf.String()
+	}
+	is.True(strings.HasPrefix(serve("base"), "base nav \n"+`<link rel="stylesheet" href="/templatedir/base/index.sha256-`))
+	is.True(strings.HasPrefix(serve("child"), "child nav \n"+`<link rel="stylesheet" href="/templatedir/base/index.sha256-`))
+
+	// overriding a file in the child theme is picked up without invalidation
+	fsys["child/index.css"] = &fstest.MapFile{Data: []byte(`body {}`), ModTime: modTime}
+	is.True(strings.HasPrefix(serve("child"), "child nav \n"+`<link rel="stylesheet" href="/templatedir/child/index.sha256-`))
+
+	theme, err := dir.LoadTheme("child")
+	is.NoErr(err)
+	is.Equal("base", theme.Extends)
+	is.Equal([]string{"index.config.js"}, theme.Templates)
+	// CSS and JS files are validated through the themes that are extended
+	delete(fsys, "child/index.css")
+	is.NoErr(dir.ValidateTheme(theme))
+	// base/index.css has no ModTime, so its removal is not noticed by the
+	// cache
+	delete(fsys, "base/index.css")
+	dir.Invalidate()
+	err = dir.ValidateTheme(theme)
+	is.True(strings.Contains(err.Error(), "child/index.config.js: open child/index.css"))
+	fsys["base/index.css"] = &fstest.MapFile{Data: []byte(`body {}`)}
+	_, err = dir.LoadTheme("loop1")
+	is.True(strings.Contains(err.Error(), "loop1 -> loop2 -> loop1"))
+	fsys["child/theme.config.js"] = &fstest.MapFile{Data: []byte(`return { Name: "child", Extends: "missing" }`), ModTime: modTime.Add(time.Second)}
+	r, _ := http.NewRequest("GET", "/", nil)
+	err = dir.ServeTemplate(&strings.Builder{}, r, "child", "index.config.js")
+	is.True(strings.Contains(err.Error(), `Extends "missing", which is not a theme`))
+}
+
+func Test_templateHelpers(t *testing.T) {
+	is := testutil.New(t)
+	store := newVstore()
+	store.values[vkey{namespace: "/", name: "title"}] = NullString{Valid: true, Str: "Hello"}
+	store.values[vkey{namespace: "/", name: "config"}] = NullString{Valid: true, Str: `{"tags": ["a", "b"]}`}
+	store.values[vkey{localeCode: "fr", namespace: "/", name: "title"}] = NullString{Valid: true, Str: "Bonjour"}
+	store.rows[vkey{namespace: "/", name: "posts"}] = []map[string]interface{}{
+		{"title": "b", "views": float64(10)},
+		{"title": "c", "views": float64(2)},
+		{"title": "a"},
+	}
+	store.rows[vkey{namespace: "/other", name: "posts"}] = []map[string]interface{}{{"title": "other"}}
+	fsys := fstest.MapFS{
+		"theme/index.config.js": {Data: []byte(`return { HTML: ["index.html"] }`)},
+		"theme/index.html": {Data: []byte(`{{ valueOr . "title" "Default" }}|{{ valueOr . "missing" "Default" }}|` +
+			`{{ valueOr . "title" "Default" (localeCode "fr") }}|` +
+			`{{ range (getJSON . "config").tags }}{{ . }}{{ end }}|{{ getJSON . "missing" }}|` +
+			`{{ range getRowsPaged . "posts" 2 1 }}{{ .title }}{{ end }}|` +
+			`{{ range getRowsSorted . "posts" "title" }}{{ .title }}{{ end }}|` +
+			`{{ range getRowsSorted . "posts" "-views" }}{{ .title }}{{ end }}|` +
+			`{{ range getRowsSorted . "posts" "title" (namespace "/other") }}{{ .title }}{{ end }}`)},
+	}
+	dir, err := New(fsys, store)
+	is.NoErr(err)
+	r, _ := http.NewRequest("GET", "/", nil)
+	buf := &strings.Builder{}
+	is.NoErr(dir.ServeTemplate(buf, r, "theme", "index.config.js", DisableCSP(true)))
+	is.Equal("Hello|Default|Bonjour|ab||ca|abc|bca|other", buf.String())
+}
+
+func Test_getRowsPaged(t *testing.T) {
+	is := testutil.New(t)
+	store := &pagedVstore{vstore: newVstore()}
+	for i := 0; i < 100; i++ {
+		key := vkey{localeCode: "en", namespace: "/", name: "posts"}
+		store.rows[key] = append(store.rows[key], map[string]interface{}{"title": strconv.Itoa(i)})
+	}
+	store.rows[vkey{namespace: "/", name: "posts"}] = []map[string]interface{}{{"title": "fallback"}}
+	store.draftRows[vkey{localeCode: "en", namespace: "/draft", name: "posts"}] = []map[string]interface{}{{"title": "a"}, {"title": "b"}}
+	store.rows[vkey{localeCode: "en", namespace: "/draft", name: "posts"}] = []map[string]interface{}{{"title": "published"}}
+	fsys := fstest.MapFS{
+		"theme/index.config.js": {Data: []byte(`return { HTML: ["index.html"] }`)},
+		"theme/index.html": {Data: []byte(`{{ range getRowsPaged . "posts" 3 10 }}{{ .title }},{{ end }}|` +
+			`{{ range getRowsPaged . "posts" 3 200 }}{{ .title }},{{ end }}|` +
+			`{{ range getRowsPaged . "posts" 3 0 (localeCode "fr") }}{{ .title }},{{ end }}|` +
+			`{{ range getRowsPaged . "posts" 1 1 (namespace "/draft") }}{{ .title }},{{ end }}`)},
+	}
+	dir, err := New(fsys, store, LocaleFallbacks(func(string) []string { return []string{""} }))
+	is.NoErr(err)
+	serve := func(opts ...ServeOption) string {
+		r, _ := http.NewRequest("GET", "/", nil)
+		buf := &strings.Builder{}
+		is.NoErr(dir.ServeTemplate(buf, r, "theme", "index.config.js", append(opts, LocaleCode("en"), DisableCSP(true))...))
+		return buf.String()
+	}
+	// only the page of rows is fetched, and a page past the last row does
+	// not fall back to another locale
+	is.Equal("10,11,12,||fallback,|", serve())
+	is.Equal(3+1, store.rowsFetched)
+	// drafts are paged in memory
+	is.Equal("10,11,12,||fallback,|b,", serve(Preview(true)))
+}
+
+func Test_Preview(t *testing.T) {
+	is := testutil.New(t)
+	store := newVstore()
+	store.values[vkey{namespace: "/", name: "title"}] = NullString{Valid: true, Str: "Published"}
+	store.values[vkey{namespace: "/", name: "subtitle"}] = NullString{Valid: true, Str: "Subtitle"}
+	store.draftValues[vkey{namespace: "/", name: "title"}] = NullString{Valid: true, Str: "Draft"}
+	store.rows[vkey{namespace: "/", name: "posts"}] = []map[string]interface{}{{"title": "a"}}
+	store.draftRows[vkey{namespace: "/", name: "posts"}] = nil
+	fsys := fstest.MapFS{
+		"theme/index.config.js": {Data: []byte(`return { HTML: ["index.html"] }`)},
+		"theme/index.html":      {Data: []byte(`{{ getValue . "title" }}|{{ getValue . "subtitle" }}|{{ len (getRows . "posts") }}`)},
+	}
+	dir, err := New(fsys, store, EditPermission(func(r *http.Request, namespace string) bool { return true }))
+	is.NoErr(err)
+	serve := func(opts ...ServeOption) string {
+		r, _ := http.NewRequest("GET", "/", nil)
+		buf := &strings.Builder{}
+		is.NoErr(dir.ServeTemplate(buf, r, "theme", "index.config.js", append(opts, DisableCSP(true))...))
+		return buf.String()
+	}
+	is.Equal("Published|Subtitle|1", serve())
+	// the draft deleted every row, which must not fall back to the published rows
+	is.Equal("Draft|Subtitle|0", serve(Preview(true)))
+	is.Equal("Draft|Subtitle|0", serve(EditMode(true)))
+}
+
+func Test_BufferResponse(t *testing.T) {
+	is := testutil.New(t)
+	fsys := fstest.MapFS{
+		"theme/index.config.js":  {Data: []byte(`return { HTML: ["index.html"] }`)},
+		"theme/index.html":       {Data: []byte(`<p>hello</p>{{ .JS }}`)},
+		"theme/broken.config.js": {Data: []byte(`return { HTML: ["broken.html"] }`)},
+		"theme/broken.html":      {Data: []byte(`<p>partial</p>{{ .Missing }}`)},
+	}
+	dir, err := New(fsys, newVstore())
+	is.NoErr(err)
+	serve := func(configPath string, header http.Header, opts ...ServeOption) (*httptest.ResponseRecorder, error) {
+		rec := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/", nil)
+		for key, values := range header {
+			r.Header[key] = values
+		}
+		err := dir.ServeTemplate(rec, r, "theme", configPath, opts...)
+		return rec, err
+	}
+
+	// streaming writes whatever was rendered before the error
+	rec, err := serve("broken.config.js", nil)
+	is.True(err != nil)
+	is.Equal("<p>partial</p>", rec.Body.String())
+	// buffering writes nothing
+	rec, err = serve("broken.config.js", nil, BufferResponse(true))
+	is.True(err != nil)
+	is.Equal("", rec.Body.String())
+	is.Equal("", rec.Header().Get("Content-Security-Policy"))
+
+	rec, err = serve("index.config.js", nil, BufferResponse(true))
+	is.NoErr(err)
+	etag := rec.Header().Get("ETag")
+	is.True(strings.HasPrefix(etag, `W/"`))
+	is.Equal(strconv.Itoa(rec.Body.Len()), rec.Header().Get("Content-Length"))
+	// the nonce changes every response but the ETag does not
+	rec, err = serve("index.config.js", http.Header{"If-None-Match": {`"abc", ` + etag}}, BufferResponse(true))
+	is.NoErr(err)
+	is.Equal(http.StatusNotModified, rec.Code)
+	is.Equal("", rec.Body.String())
+	is.Equal("", rec.Header().Get("Content-Security-Policy"))
+	rec, err = serve("index.config.js", http.Header{"If-None-Match": {`"abc"`}}, BufferResponse(true))
+	is.NoErr(err)
+	is.Equal(http.StatusOK, rec.Code)
+	is.True(rec.Header().Get("Content-Security-Policy") != "")
+
+	// CacheConfig(false) recompiles even if no modification time changed
+	fsys["theme/index.html"].Data = []byte(`<p>changed</p>`)
+	rec, err = serve("index.config.js", nil)
+	is.NoErr(err)
+	is.True(strings.HasPrefix(rec.Body.String(), "<p>hello</p>"))
+	rec, err = serve("index.config.js", nil, CacheConfig(false))
+	is.NoErr(err)
+	is.Equal("<p>changed</p>", rec.Body.String())
+}
@@ -0,0 +1,328 @@
+package pagemanager
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bokwoon95/pagemanager/hyperforms"
+	hy "github.com/bokwoon95/pagemanager/hypergo"
+)
+
+const (
+	// maxThemeZipBytes is the largest theme archive that can be uploaded.
+	maxThemeZipBytes = 50 << 20
+	// maxThemeFileBytes is the largest single file a theme may contain.
+	maxThemeFileBytes = 10 << 20
+	// maxThemeBytes is the largest a theme may be once extracted.
+	maxThemeBytes = 100 << 20
+	// maxThemeFiles is the most files a theme may contain.
+	maxThemeFiles = 2000
+	// previousThemesDir is where the previous version of every upgraded
+	// theme is kept for rollback, inside the themes directory. TemplateDir
+	// skips directories starting with a dot.
+	previousThemesDir = ".previous"
+)
+
+var themeNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-][a-zA-Z0-9._-]*$`)
+
+// errThemesNotInstallable is returned by the theme installer if the themes
+// were given as an fs.FS with ThemesFS rather than as a directory.
+var errThemesNotInstallable = errors.New("themes can only be installed if they are in a ThemesDir")
+
+func (pm *PageManager) themeDir(themeName string) (string, error) {
+	if pm.themesDir == "" {
+		return "", errThemesNotInstallable
+	}
+	if !themeNameRegexp.MatchString(themeName) || strings.HasPrefix(themeName, ".") {
+		return "", fmt.Errorf("invalid theme name %q", themeName)
+	}
+	return filepath.Join(pm.themesDir, themeName), nil
+}
+
+// InstallTheme extracts a zipped theme into the themes directory as
+// themeName. The theme.config.js may be either at the root of the archive or
+// inside a single top level directory. The archive is rejected if it has no
+// valid theme.config.js, has a template that does not compile, or has entries
+// that are too large or point outside the theme. If themeName is already
+// installed, it is upgraded and the version it replaces is kept for
+// RollbackTheme.
+func (pm *PageManager) InstallTheme(themeName string, r io.ReaderAt, size int64) error {
+	dst, err := pm.themeDir(themeName)
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	staging, err := os.MkdirTemp(pm.themesDir, ".install-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+	err = extractTheme(zr, staging)
+	if err != nil {
+		return err
+	}
+	theme, err := pm.tmpldir.LoadTheme(filepath.Base(staging))
+	if err != nil {
+		return err
+	}
+	err = pm.tmpldir.ValidateTheme(theme)
+	if err != nil {
+		return err
+	}
+	previous := filepath.Join(pm.themesDir, previousThemesDir, themeName)
+	if _, err := os.Stat(dst); err == nil {
+		err = os.MkdirAll(filepath.Dir(previous), 0755)
+		if err != nil {
+			return err
+		}
+		err = os.RemoveAll(previous)
+		if err != nil {
+			return err
+		}
+		err = os.Rename(dst, previous)
+		if err != nil {
+			return err
+		}
+	}
+	err = os.Rename(staging, dst)
+	if err != nil {
+		return err
+	}
+	return pm.tmpldir.Reload()
+}
+
+// extractTheme extracts a theme archive into dir, stripping the top level
+// directory if every entry is inside it.
+func extractTheme(zr *zip.Reader, dir string) error {
+	var files []*zip.File
+	for _, file := range zr.File {
+		if !file.FileInfo().IsDir() {
+			files = append(files, file)
+		}
+	}
+	if len(files) > maxThemeFiles {
+		return fmt.Errorf("theme has more than %d files", maxThemeFiles)
+	}
+	prefix := ""
+	if _, err := fs.Stat(zr, "theme.config.js"); err != nil {
+		// the archive might wrap the theme in a directory, like the archives
+		// downloaded from a git host
+		for _, file := range files {
+			if i := strings.Index(file.Name, "/"); i < 0 || (prefix != "" && file.Name[:i+1] != prefix) {
+				return fmt.Errorf("theme.config.js not found")
+			} else if prefix == "" {
+				prefix = file.Name[:i+1]
+			}
+		}
+	}
+	var total int64
+	for _, file := range files {
+		name := strings.TrimPrefix(file.Name, prefix)
+		if !fs.ValidPath(name) || strings.Contains(name, `\`) {
+			return fmt.Errorf("invalid file name %q", file.Name)
+		}
+		if !file.Mode().IsRegular() {
+			return fmt.Errorf("%s is not a regular file", file.Name)
+		}
+		if file.UncompressedSize64 > maxThemeFileBytes {
+			return fmt.Errorf("%s is larger than %d bytes", file.Name, maxThemeFileBytes)
+		}
+		total += int64(file.UncompressedSize64)
+		if total > maxThemeBytes {
+			return fmt.Errorf("theme is larger than %d bytes", maxThemeBytes)
+		}
+		err := extractFile(file, filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			return fmt.Errorf("%s: %w", file.Name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "theme.config.js")); err != nil {
+		return fmt.Errorf("theme.config.js not found")
+	}
+	return nil
+}
+
+func extractFile(file *zip.File, filename string) error {
+	err := os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return err
+	}
+	src, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	// the size in the header is not to be trusted
+	n, err := io.Copy(dst, io.LimitReader(src, maxThemeFileBytes+1))
+	if err != nil {
+		dst.Close()
+		return err
+	}
+	if n > maxThemeFileBytes {
+		dst.Close()
+		return fmt.Errorf("larger than %d bytes", maxThemeFileBytes)
+	}
+	return dst.Close()
+}
+
+// UninstallTheme removes a theme, along with any previous version kept for
+// rollback.
+func (pm *PageManager) UninstallTheme(themeName string) error {
+	dir, err := pm.themeDir(themeName)
+	if err != nil {
+		return err
+	}
+	err = os.RemoveAll(dir)
+	if err != nil {
+		return err
+	}
+	err = os.RemoveAll(filepath.Join(pm.themesDir, previousThemesDir, themeName))
+	if err != nil {
+		return err
+	}
+	return pm.tmpldir.Reload()
+}
+
+// RollbackTheme swaps a theme with the version it replaced when it was last
+// upgraded, so rolling back twice undoes the rollback.
+func (pm *PageManager) RollbackTheme(themeName string) error {
+	dir, err := pm.themeDir(themeName)
+	if err != nil {
+		return err
+	}
+	previous := filepath.Join(pm.themesDir, previousThemesDir, themeName)
+	if _, err := os.Stat(previous); err != nil {
+		return fmt.Errorf("theme %q has no previous version", themeName)
+	}
+	tmp := previous + ".rollback"
+	err = os.Rename(dir, tmp)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(previous, dir)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, previous)
+	if err != nil {
+		return err
+	}
+	return pm.tmpldir.Reload()
+}
+
+func (pm *PageManager) hasPreviousTheme(themeName string) bool {
+	if pm.themesDir == "" {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(pm.themesDir, previousThemesDir, themeName))
+	return err == nil
+}
+
+func (pm *PageManager) superadminThemes(w http.ResponseWriter, r *http.Request) {
+	if !isSuperadmin(r) {
+		http.Redirect(w, r, superadminURLPrefix+"login", http.StatusSeeOther)
+		return
+	}
+	form := hyperforms.New(w, r)
+	if r.Method == http.MethodPost {
+		r.Body = http.MaxBytesReader(w, r.Body, maxThemeZipBytes+1<<20)
+		err := r.ParseMultipartForm(32 << 20)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+		switch themeName := r.FormValue("themeName"); r.FormValue("action") {
+		case "install":
+			err = pm.installUploadedTheme(r, themeName)
+		case "uninstall":
+			err = pm.UninstallTheme(themeName)
+		case "rollback":
+			err = pm.RollbackTheme(themeName)
+		default:
+			http.Error(w, "unknown action", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			form.AddErrMsgs(err.Error())
+			form.Redirect(w, r, r.URL.Path)
+			return
+		}
+		http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+		return
+	}
+	themes, err := pm.tmpldir.ListThemes()
+	if err != nil {
+		form.AddErrMsgs(err.Error())
+	}
+	var rows hy.Elements
+	for _, theme := range themes {
+		themeName := path.Base(theme.Path)
+		var actions hy.Elements
+		if pm.themesDir != "" && theme.Path == themeName {
+			actions.Append("input[type=hidden][name=themeName]", hy.Attr{"value": themeName})
+			if pm.hasPreviousTheme(themeName) {
+				actions.Append("button[type=submit][name=action][value=rollback]", nil, hy.Txt("Roll back"))
+			}
+			actions.Append("button[type=submit][name=action][value=uninstall]", nil, hy.Txt("Uninstall"))
+		}
+		rows.Append("tr", nil,
+			hy.H("td", nil, hy.Txt(theme.Path)),
+			hy.H("td", nil, hy.Txt(theme.Name)),
+			hy.H("td", nil, hy.Txt(theme.Description)),
+			hy.H("td", nil, hy.H("form[method=post]", hy.Attr{"enctype": "multipart/form-data"}, actions)),
+		)
+	}
+	var install hy.Element
+	if pm.themesDir != "" {
+		install = hy.H("form[method=post]", hy.Attr{"enctype": "multipart/form-data"},
+			hy.H("label[for=themeName]", nil, hy.Txt("Theme name")),
+			hy.H("input#themeName[type=text][name=themeName][required]", nil),
+			hy.H("label[for=themeZip]", nil, hy.Txt("Theme archive (.zip)")),
+			hy.H("input#themeZip[type=file][name=themeZip][required]", hy.Attr{"accept": ".zip"}),
+			hy.H("button[type=submit][name=action][value=install]", nil, hy.Txt("Install")),
+		)
+	}
+	err = renderPage(w, "Themes",
+		hy.H("h1", nil, hy.Txt("Themes")),
+		formErrors(form.ErrMsgs),
+		hy.H("table", nil,
+			hy.H("tr", nil, hy.H("th", nil, hy.Txt("Directory")), hy.H("th", nil, hy.Txt("Name")), hy.H("th", nil, hy.Txt("Description")), hy.H("th", nil)),
+			rows,
+		),
+		hy.H("h2", nil, hy.Txt("Install or upgrade a theme")),
+		install,
+	)
+	if err != nil {
+		pm.errHandler(w, r, err)
+		return
+	}
+}
+
+func (pm *PageManager) installUploadedTheme(r *http.Request, themeName string) error {
+	file, header, err := r.FormFile("themeZip")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if header.Size > maxThemeZipBytes {
+		return fmt.Errorf("theme archive is larger than %d bytes", maxThemeZipBytes)
+	}
+	return pm.InstallTheme(themeName, file, header.Size)
+}
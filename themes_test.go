@@ -0,0 +1,136 @@
+package pagemanager
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/bokwoon95/pagemanager/testutil"
+)
+
+func newThemeZip(t *testing.T, files map[string]string) *bytes.Reader {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func Test_InstallTheme(t *testing.T) {
+	is := testutil.New(t)
+	themesDir := t.TempDir()
+	pm, err := New(DataDB(newTestDB(t), "sqlite3"), ThemesDir(themesDir))
+	is.NoErr(err)
+	install := func(themeName string, files map[string]string) error {
+		zr := newThemeZip(t, files)
+		return pm.InstallTheme(themeName, zr, zr.Size())
+	}
+	themeNames := func() []string {
+		themes, err := pm.tmpldir.ListThemes()
+		is.NoErr(err)
+		var names []string
+		for _, theme := range themes {
+			names = append(names, theme.Name)
+		}
+		return names
+	}
+	v1 := map[string]string{
+		"theme.config.js": `return { Name: "v1" }`,
+		"index.config.js": `return { HTML: ["index.html"] }`,
+		"index.html":      `v1`,
+	}
+	v2 := map[string]string{
+		"blog-main/theme.config.js": `return { Name: "v2" }`,
+		"blog-main/index.config.js": `return { HTML: ["index.html"] }`,
+		"blog-main/index.html":      `v2`,
+	}
+
+	is.NoErr(install("blog", v1))
+	is.Equal([]string{"v1"}, themeNames())
+	// an archive wrapped in a top level directory is unwrapped
+	is.NoErr(install("blog", v2))
+	is.Equal([]string{"v2"}, themeNames())
+	b, err := os.ReadFile(filepath.Join(themesDir, "blog", "index.html"))
+	is.NoErr(err)
+	is.Equal("v2", string(b))
+	is.NoErr(pm.RollbackTheme("blog"))
+	is.Equal([]string{"v1"}, themeNames())
+	is.NoErr(pm.RollbackTheme("blog"))
+	is.Equal([]string{"v2"}, themeNames())
+	is.NoErr(pm.UninstallTheme("blog"))
+	is.Equal([]string(nil), themeNames())
+	is.True(pm.RollbackTheme("blog") != nil)
+
+	for _, files := range []map[string]string{
+		{"index.html": "no theme.config.js"},
+		{"theme.config.js": `return { Name: "x" }`, "../escape.html": "x"},
+		{"theme.config.js": `return { Name: "x" }`, "/abs.html": "x"},
+		{"theme.config.js": `return { Description: "no name" }`},
+		{"theme.config.js": `return { Name: "x" }`, "index.config.js": `return { HTML: ["index.html"] }`, "index.html": "{{ .Broken "},
+	} {
+		is.True(install("bad", files) != nil)
+	}
+	is.True(install("../bad", v1) != nil)
+	is.True(install(".hidden", v1) != nil)
+	entries, err := os.ReadDir(themesDir)
+	is.NoErr(err)
+	for _, entry := range entries {
+		is.True(entry.Name() == ".previous")
+	}
+
+	fsPM, err := New(DataDB(newTestDB(t), "sqlite3"), ThemesFS(fstest.MapFS{}))
+	is.NoErr(err)
+	zr := newThemeZip(t, v1)
+	is.True(errors.Is(fsPM.InstallTheme("blog", zr, zr.Size()), errThemesNotInstallable))
+}
+
+func Test_superadminThemes(t *testing.T) {
+	is := testutil.New(t)
+	themesDir := t.TempDir()
+	pm, err := New(DataDB(newTestDB(t), "sqlite3"), ThemesDir(themesDir))
+	is.NoErr(err)
+	is.NoErr(pm.pwbox.SetPassword([]byte("password123")))
+	rr := httptest.NewRecorder()
+	is.NoErr(pm.startSession(rr, httptest.NewRequest("GET", "/", nil), superadminUserID))
+	cookie := rr.Result().Cookies()[0]
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	is.NoErr(mw.WriteField("action", "install"))
+	is.NoErr(mw.WriteField("themeName", "uploaded"))
+	fw, err := mw.CreateFormFile("themeZip", "uploaded.zip")
+	is.NoErr(err)
+	zr := newThemeZip(t, map[string]string{"theme.config.js": `return { Name: "Uploaded", Description: "from a zip" }`})
+	_, err = zr.WriteTo(fw)
+	is.NoErr(err)
+	is.NoErr(mw.Close())
+	r := httptest.NewRequest("POST", superadminURLPrefix+"themes", body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	r.AddCookie(cookie)
+	rr = httptest.NewRecorder()
+	pm.ServeHTTP(rr, r)
+	is.Equal(http.StatusSeeOther, rr.Code)
+
+	r = httptest.NewRequest("GET", superadminURLPrefix+"themes", nil)
+	r.AddCookie(cookie)
+	rr = httptest.NewRecorder()
+	pm.ServeHTTP(rr, r)
+	is.Equal(http.StatusOK, rr.Code)
+	is.True(strings.Contains(rr.Body.String(), "from a zip"))
+}
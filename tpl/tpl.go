@@ -9,6 +9,9 @@ import (
 	"net/http"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/bokwoon95/pagemanager/cache"
 )
 
 var bufpool = sync.Pool{New: func() interface{} { return &bytes.Buffer{} }}
@@ -22,8 +25,7 @@ type Renderer struct {
 	funcMap              map[string]interface{}
 	tmplOption           []string
 	tmplDelims           [2]string
-	cacheGet             func(w http.ResponseWriter, r *http.Request, files []string) (*template.Template, error)
-	cacheSet             func(w http.ResponseWriter, r *http.Request, files []string, t *template.Template) error
+	cache                cache.Cache
 	shouldJSON           func(w http.ResponseWriter, r *http.Request, data interface{}) (bool, error)
 	jsonMarshaller       func(w http.ResponseWriter, r *http.Request, data interface{}) ([]byte, error)
 	alwaysParseTemplates bool
@@ -109,13 +111,17 @@ func (rdr Renderer) parseTemplate(w http.ResponseWriter, r *http.Request) (*temp
 	allFiles := make([]string, len(rdr.files)+1)
 	allFiles[0] = rdr.basefile
 	copy(allFiles[1:], rdr.files)
-	if rdr.cacheGet != nil && !ignoreCache {
-		t, err = rdr.cacheGet(w, r, allFiles)
-		if err != nil {
-			return t, fmt.Errorf("tpl: error when calling cacheGet: %w", err)
-		}
-		if t != nil {
-			return t, nil
+	cacheKey := "tpl:" + strings.Join(allFiles, "\n")
+	c := rdr.cache
+	if fc, ok := c.(funcCache); ok {
+		fc.w, fc.r = w, r
+		c = fc
+	}
+	if c != nil && !ignoreCache {
+		if cached, ok := c.Get(cacheKey); ok {
+			if t, ok := cached.(*template.Template); ok {
+				return t, nil
+			}
 		}
 	}
 	if rdr.fs == nil {
@@ -150,11 +156,8 @@ func (rdr Renderer) parseTemplate(w http.ResponseWriter, r *http.Request) (*temp
 			return nil, fmt.Errorf("error when parsing file %s: %w", file, err)
 		}
 	}
-	if rdr.cacheSet != nil && !ignoreCache {
-		err = rdr.cacheSet(w, r, allFiles, t)
-		if err != nil {
-			return t, fmt.Errorf("error when calling cacheSet: %w", err)
-		}
+	if c != nil && !ignoreCache {
+		c.Set(cacheKey, t, 0)
 	}
 	return t, nil
 }
@@ -240,36 +243,81 @@ func TemplateDelims(left, right string) RenderOption {
 	}
 }
 
+// DefaultCache caches parsed templates in an unbounded in-memory LRU.
 func DefaultCache() RenderOption {
-	cache := make(map[string]*template.Template)
-	mu := &sync.RWMutex{}
-	cacheGet := func(_ http.ResponseWriter, _ *http.Request, files []string) (*template.Template, error) {
-		fullname := strings.Join(files, "\n")
-		mu.RLock()
-		defer mu.RUnlock()
-		return cache[fullname], nil
-	}
-	cacheSet := func(_ http.ResponseWriter, _ *http.Request, files []string, t *template.Template) error {
-		fullname := strings.Join(files, "\n")
-		mu.Lock()
-		defer mu.Unlock()
-		cache[fullname] = t
-		return nil
+	return Cache(cache.NewLRU(0))
+}
+
+// Cache sets the cache that parsed templates are kept in, keyed by "tpl:"
+// followed by the newline-separated template files. Templates are not cached
+// if the FS, FuncMap, TemplateOption or TemplateDelims are changed in a call
+// to Render. Parsed templates cannot be serialized, so c must keep its values
+// in the process, like a *cache.LRU does.
+func Cache(c cache.Cache) RenderOption {
+	return func(rdr *Renderer) { rdr.cache = c }
+}
+
+// CacheGet sets a func that looks up parsed templates by their files.
+//
+// Deprecated: Use Cache. CacheGet is a wrapper over Cache that treats errors
+// returned by cacheGet as cache misses.
+func CacheGet(cacheGet func(w http.ResponseWriter, r *http.Request, files []string) (*template.Template, error)) RenderOption {
+	return func(rdr *Renderer) {
+		fc, _ := rdr.cache.(funcCache)
+		fc.get = cacheGet
+		rdr.cache = fc
 	}
+}
+
+// CacheSet sets a func that stores parsed templates by their files.
+//
+// Deprecated: Use Cache. CacheSet is a wrapper over Cache that ignores errors
+// returned by cacheSet.
+func CacheSet(cacheSet func(w http.ResponseWriter, r *http.Request, files []string, t *template.Template) error) RenderOption {
 	return func(rdr *Renderer) {
-		rdr.cacheGet = cacheGet
-		rdr.cacheSet = cacheSet
+		fc, _ := rdr.cache.(funcCache)
+		fc.set = cacheSet
+		rdr.cache = fc
 	}
 }
 
-func CacheGet(cacheGet func(w http.ResponseWriter, r *http.Request, files []string) (*template.Template, error)) RenderOption {
-	return func(rdr *Renderer) { rdr.cacheGet = cacheGet }
+// funcCache is the cache.Cache set by CacheGet and CacheSet. w and r are
+// those of the Render call that uses it.
+type funcCache struct {
+	w   http.ResponseWriter
+	r   *http.Request
+	get func(w http.ResponseWriter, r *http.Request, files []string) (*template.Template, error)
+	set func(w http.ResponseWriter, r *http.Request, files []string, t *template.Template) error
 }
 
-func CacheSet(cacheSet func(w http.ResponseWriter, r *http.Request, files []string, t *template.Template) error) RenderOption {
-	return func(rdr *Renderer) { rdr.cacheSet = cacheSet }
+// files returns the template files of a cache key.
+func (c funcCache) files(key string) []string {
+	return strings.Split(strings.TrimPrefix(key, "tpl:"), "\n")
 }
 
+func (c funcCache) Get(key string) (value interface{}, ok bool) {
+	if c.get == nil {
+		return nil, false
+	}
+	t, err := c.get(c.w, c.r, c.files(key))
+	if err != nil || t == nil {
+		return nil, false
+	}
+	return t, true
+}
+
+func (c funcCache) Set(key string, value interface{}, ttl time.Duration) {
+	t, ok := value.(*template.Template)
+	if c.set == nil || !ok {
+		return
+	}
+	_ = c.set(c.w, c.r, c.files(key), t)
+}
+
+func (c funcCache) Delete(key string) {}
+
+func (c funcCache) DeletePrefix(prefix string) {}
+
 func ShouldJSON(shouldJSON func(w http.ResponseWriter, r *http.Request, data interface{}) (bool, error)) RenderOption {
 	return func(rdr *Renderer) { rdr.shouldJSON = shouldJSON }
 }
@@ -0,0 +1,24 @@
+package pagemanager
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bokwoon95/pagemanager/testutil"
+)
+
+func Test_newULID(t *testing.T) {
+	is := testutil.New(t)
+	// timestamp from the ULID spec
+	id, err := newULID(time.Unix(0, 1469918176385*int64(time.Millisecond)))
+	is.NoErr(err)
+	is.Equal(26, len(id))
+	is.Equal("01ARYZ6S41", id[:10])
+	for _, char := range id {
+		is.True(strings.ContainsRune(crockford, char))
+	}
+	later, err := newULID(time.Unix(0, 1469918176386*int64(time.Millisecond)))
+	is.NoErr(err)
+	is.True(id < later)
+}
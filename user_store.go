@@ -0,0 +1,267 @@
+package pagemanager
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/bokwoon95/pagemanager/erro"
+	"github.com/bokwoon95/pagemanager/sq"
+)
+
+type UserStatus int
+
+const (
+	UserStatusDisabled UserStatus = 0
+	UserStatusInvited  UserStatus = 1
+	UserStatusActive   UserStatus = 2
+)
+
+func (status UserStatus) String() string {
+	switch status {
+	case UserStatusDisabled:
+		return "disabled"
+	case UserStatusInvited:
+		return "invited"
+	case UserStatusActive:
+		return "active"
+	default:
+		return "unknown"
+	}
+}
+
+// User is a user account. TokenHash is the SHA-256 hash of the token in the
+// user's invite or password reset link, if there is one.
+type User struct {
+	UserID         string
+	LoginID        string
+	DisplayName    string
+	PasswordHash   []byte
+	Status         UserStatus
+	Role           Role
+	TokenHash      string
+	TokenExpiresAt time.Time
+	CreatedAt      time.Time
+}
+
+type UserStore interface {
+	CreateUser(user User) error
+	// GetUser, GetUserByLoginID and GetUserByTokenHash return nil if the user
+	// does not exist.
+	GetUser(userID string) (*User, error)
+	GetUserByLoginID(loginID string) (*User, error)
+	GetUserByTokenHash(tokenHash string) (*User, error)
+	ListUsers() ([]User, error)
+	UpdateUser(user User) error
+	// DeleteUser also deletes the user's grants.
+	DeleteUser(userID string) error
+	// GetGrants returns the namespace prefixes that an editor is allowed to
+	// edit.
+	GetGrants(userID string) ([]string, error)
+	// SetGrants replaces all of a user's grants.
+	SetGrants(userID string, namespacePrefixes []string) error
+}
+
+func getUsers(dialect string, USERS pm_USERS, predicates ...sq.Predicate) sq.Query {
+	if dialect == "postgres" {
+		return sq.Postgres.From(USERS).Where(predicates...).OrderBy(USERS.USER_ID)
+	}
+	return sq.SQLite.From(USERS).Where(predicates...).OrderBy(USERS.USER_ID)
+}
+
+func addUser(dialect string, USERS pm_USERS, user User) sq.Query {
+	mapper := func(col *sq.Column) error {
+		col.SetString(USERS.USER_ID, user.UserID)
+		col.SetString(USERS.LOGIN_ID, user.LoginID)
+		col.SetString(USERS.DISPLAY_NAME, user.DisplayName)
+		col.SetString(USERS.PASSWORD_HASH, string(user.PasswordHash))
+		col.SetInt(USERS.STATUS, int(user.Status))
+		col.SetString(USERS.ROLE, string(user.Role))
+		col.SetString(USERS.TOKEN_HASH, user.TokenHash)
+		col.SetTime(USERS.TOKEN_EXPIRES_AT, user.TokenExpiresAt)
+		col.SetTime(USERS.CREATED_AT, user.CreatedAt)
+		return nil
+	}
+	if dialect == "postgres" {
+		return sq.Postgres.InsertInto(USERS).Valuesx(mapper)
+	}
+	return sq.SQLite.InsertInto(USERS).Valuesx(mapper)
+}
+
+func updateUser(dialect string, USERS pm_USERS, user User) sq.Query {
+	assignments := []sq.Assignment{
+		USERS.LOGIN_ID.SetString(user.LoginID),
+		USERS.DISPLAY_NAME.SetString(user.DisplayName),
+		USERS.PASSWORD_HASH.SetString(string(user.PasswordHash)),
+		USERS.STATUS.SetInt(int(user.Status)),
+		USERS.ROLE.SetString(string(user.Role)),
+		USERS.TOKEN_HASH.SetString(user.TokenHash),
+		USERS.TOKEN_EXPIRES_AT.SetTime(user.TokenExpiresAt),
+	}
+	if dialect == "postgres" {
+		return sq.Postgres.Update(USERS).Set(assignments...).Where(USERS.USER_ID.EqString(user.UserID))
+	}
+	return sq.SQLite.Update(USERS).Set(assignments...).Where(USERS.USER_ID.EqString(user.UserID))
+}
+
+func deleteUser(dialect string, USERS pm_USERS, userID string) sq.Query {
+	if dialect == "postgres" {
+		return sq.Postgres.DeleteFrom(USERS).Where(USERS.USER_ID.EqString(userID))
+	}
+	return sq.SQLite.DeleteFrom(USERS).Where(USERS.USER_ID.EqString(userID))
+}
+
+func getGrants(dialect string, GRANTS pm_GRANTS, userID string) sq.Query {
+	if dialect == "postgres" {
+		return sq.Postgres.From(GRANTS).Where(GRANTS.USER_ID.EqString(userID)).OrderBy(GRANTS.NAMESPACE_PREFIX)
+	}
+	return sq.SQLite.From(GRANTS).Where(GRANTS.USER_ID.EqString(userID)).OrderBy(GRANTS.NAMESPACE_PREFIX)
+}
+
+func addGrant(dialect string, GRANTS pm_GRANTS, userID, namespacePrefix string) sq.Query {
+	mapper := func(col *sq.Column) error {
+		col.SetString(GRANTS.USER_ID, userID)
+		col.SetString(GRANTS.NAMESPACE_PREFIX, namespacePrefix)
+		return nil
+	}
+	if dialect == "postgres" {
+		return sq.Postgres.InsertInto(GRANTS).Valuesx(mapper)
+	}
+	return sq.SQLite.InsertInto(GRANTS).Valuesx(mapper)
+}
+
+func deleteGrants(dialect string, GRANTS pm_GRANTS, userID string) sq.Query {
+	if dialect == "postgres" {
+		return sq.Postgres.DeleteFrom(GRANTS).Where(GRANTS.USER_ID.EqString(userID))
+	}
+	return sq.SQLite.DeleteFrom(GRANTS).Where(GRANTS.USER_ID.EqString(userID))
+}
+
+func usermapper(user *User, USERS pm_USERS) func(*sq.Row) error {
+	return func(row *sq.Row) error {
+		user.UserID = row.String(USERS.USER_ID)
+		user.LoginID = row.String(USERS.LOGIN_ID)
+		user.DisplayName = row.String(USERS.DISPLAY_NAME)
+		user.PasswordHash = row.Bytes(USERS.PASSWORD_HASH)
+		user.Status = UserStatus(row.Int(USERS.STATUS))
+		user.Role = Role(row.String(USERS.ROLE))
+		user.TokenHash = row.String(USERS.TOKEN_HASH)
+		user.TokenExpiresAt = row.Time(USERS.TOKEN_EXPIRES_AT)
+		user.CreatedAt = row.Time(USERS.CREATED_AT)
+		return sq.SkipRows
+	}
+}
+
+func usersmapper(users *[]User, USERS pm_USERS) func(*sq.Row) error {
+	return func(row *sq.Row) error {
+		var user User
+		_ = usermapper(&user, USERS)(row)
+		return row.Accumulate(func() error {
+			*users = append(*users, user)
+			return nil
+		})
+	}
+}
+
+type userstore struct {
+	db      *sql.DB
+	dialect string
+}
+
+func (store userstore) getUser(USERS pm_USERS, predicate sq.Predicate) (*User, error) {
+	var user User
+	rowCount, err := sq.Fetch(store.db, getUsers(store.dialect, USERS, predicate), usermapper(&user, USERS))
+	if err != nil {
+		return nil, erro.Wrap(err)
+	}
+	if rowCount == 0 {
+		return nil, nil
+	}
+	return &user, nil
+}
+
+func (store userstore) CreateUser(user User) error {
+	USERS := new_USERS("u")
+	_, _, err := sq.Exec(store.db, addUser(store.dialect, USERS, user), 0)
+	return erro.Wrap(err)
+}
+
+func (store userstore) GetUser(userID string) (*User, error) {
+	USERS := new_USERS("u")
+	return store.getUser(USERS, USERS.USER_ID.EqString(userID))
+}
+
+func (store userstore) GetUserByLoginID(loginID string) (*User, error) {
+	USERS := new_USERS("u")
+	return store.getUser(USERS, USERS.LOGIN_ID.EqString(loginID))
+}
+
+func (store userstore) GetUserByTokenHash(tokenHash string) (*User, error) {
+	if tokenHash == "" {
+		return nil, nil
+	}
+	USERS := new_USERS("u")
+	return store.getUser(USERS, USERS.TOKEN_HASH.EqString(tokenHash))
+}
+
+func (store userstore) ListUsers() ([]User, error) {
+	var users []User
+	USERS := new_USERS("u")
+	_, err := sq.Fetch(store.db, getUsers(store.dialect, USERS), usersmapper(&users, USERS))
+	if err != nil {
+		return nil, erro.Wrap(err)
+	}
+	return users, nil
+}
+
+func (store userstore) UpdateUser(user User) error {
+	USERS := new_USERS("u")
+	_, _, err := sq.Exec(store.db, updateUser(store.dialect, USERS, user), 0)
+	return erro.Wrap(err)
+}
+
+func (store userstore) DeleteUser(userID string) error {
+	USERS, GRANTS := new_USERS("u"), new_GRANTS("g")
+	err := sq.WithTx(store.db, func(tx *sql.Tx) error {
+		_, _, err := sq.Exec(tx, deleteGrants(store.dialect, GRANTS, userID), 0)
+		if err != nil {
+			return err
+		}
+		_, _, err = sq.Exec(tx, deleteUser(store.dialect, USERS, userID), 0)
+		return err
+	})
+	return erro.Wrap(err)
+}
+
+func (store userstore) GetGrants(userID string) ([]string, error) {
+	var namespacePrefixes []string
+	GRANTS := new_GRANTS("g")
+	_, err := sq.Fetch(store.db, getGrants(store.dialect, GRANTS, userID), func(row *sq.Row) error {
+		namespacePrefix := row.String(GRANTS.NAMESPACE_PREFIX)
+		return row.Accumulate(func() error {
+			namespacePrefixes = append(namespacePrefixes, namespacePrefix)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, erro.Wrap(err)
+	}
+	return namespacePrefixes, nil
+}
+
+func (store userstore) SetGrants(userID string, namespacePrefixes []string) error {
+	GRANTS := new_GRANTS("g")
+	err := sq.WithTx(store.db, func(tx *sql.Tx) error {
+		_, _, err := sq.Exec(tx, deleteGrants(store.dialect, GRANTS, userID), 0)
+		if err != nil {
+			return err
+		}
+		for _, namespacePrefix := range namespacePrefixes {
+			_, _, err = sq.Exec(tx, addGrant(store.dialect, GRANTS, userID, namespacePrefix), 0)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return erro.Wrap(err)
+}
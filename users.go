@@ -0,0 +1,461 @@
+package pagemanager
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bokwoon95/pagemanager/cryptoutil"
+	"github.com/bokwoon95/pagemanager/hyperforms"
+	hy "github.com/bokwoon95/pagemanager/hypergo"
+)
+
+const (
+	loginURL  = "/pm-login"
+	logoutURL = "/pm-logout"
+	inviteURL = "/pm-invite"
+	// userTokenTTL is how long an invite or password reset link stays valid.
+	userTokenTTL = 72 * time.Hour
+	// flashCookieName is the cookie that carries a newly generated invite or
+	// preview link across the redirect back to the users or pages dashboard.
+	flashCookieName = "pm-flash"
+)
+
+const ctxKeyUser ctxKey = "user"
+
+// UserFromContext returns the user put into the context by LoadSession, or
+// nil if the request does not belong to a user. Superadmin sessions have no
+// user.
+func UserFromContext(ctx context.Context) *User {
+	user, _ := ctx.Value(ctxKeyUser).(*User)
+	return user
+}
+
+// InviteUser creates a new user with the given loginID and returns the token
+// that the user must present at the invite link to set their password. New
+// users are viewers until given another role with SetUserPermissions.
+func (pm *PageManager) InviteUser(loginID, displayName string) (token string, err error) {
+	existing, err := pm.users.GetUserByLoginID(loginID)
+	if err != nil {
+		return "", err
+	}
+	if existing != nil {
+		return "", fmt.Errorf("login ID %q is already taken", loginID)
+	}
+	now := time.Now()
+	userID, err := newULID(now)
+	if err != nil {
+		return "", err
+	}
+	token, tokenHash, err := newUserToken()
+	if err != nil {
+		return "", err
+	}
+	err = pm.users.CreateUser(User{
+		UserID:         userID,
+		LoginID:        loginID,
+		DisplayName:    displayName,
+		Status:         UserStatusInvited,
+		Role:           RoleViewer,
+		TokenHash:      tokenHash,
+		TokenExpiresAt: now.Add(userTokenTTL),
+		CreatedAt:      now,
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// DisableUser prevents a user from logging in and logs them out everywhere.
+func (pm *PageManager) DisableUser(userID string) error {
+	user, err := pm.getUser(userID)
+	if err != nil {
+		return err
+	}
+	user.Status = UserStatusDisabled
+	user.TokenHash = ""
+	user.TokenExpiresAt = time.Time{}
+	err = pm.users.UpdateUser(*user)
+	if err != nil {
+		return err
+	}
+	return pm.sessions.RevokeUserSessions(userID)
+}
+
+// EnableUser re-enables a disabled user. A user who never set a password goes
+// back to being invited and needs a fresh link from ResetUserPassword.
+func (pm *PageManager) EnableUser(userID string) error {
+	user, err := pm.getUser(userID)
+	if err != nil {
+		return err
+	}
+	if user.Status != UserStatusDisabled {
+		return nil
+	}
+	user.Status = UserStatusActive
+	if len(user.PasswordHash) == 0 {
+		user.Status = UserStatusInvited
+	}
+	return pm.users.UpdateUser(*user)
+}
+
+// ResetUserPassword clears a user's password, logs them out everywhere and
+// returns the token that the user must present at the invite link to set a
+// new password.
+func (pm *PageManager) ResetUserPassword(userID string) (token string, err error) {
+	user, err := pm.getUser(userID)
+	if err != nil {
+		return "", err
+	}
+	if user.Status == UserStatusDisabled {
+		return "", fmt.Errorf("user %s is disabled", userID)
+	}
+	token, tokenHash, err := newUserToken()
+	if err != nil {
+		return "", err
+	}
+	user.PasswordHash = nil
+	user.Status = UserStatusInvited
+	user.TokenHash = tokenHash
+	user.TokenExpiresAt = time.Now().Add(userTokenTTL)
+	err = pm.users.UpdateUser(*user)
+	if err != nil {
+		return "", err
+	}
+	return token, pm.sessions.RevokeUserSessions(userID)
+}
+
+// DeleteUser deletes a user and logs them out everywhere.
+func (pm *PageManager) DeleteUser(userID string) error {
+	err := pm.users.DeleteUser(userID)
+	if err != nil {
+		return err
+	}
+	return pm.sessions.RevokeUserSessions(userID)
+}
+
+func (pm *PageManager) getUser(userID string) (*User, error) {
+	user, err := pm.users.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, fmt.Errorf("user %s does not exist", userID)
+	}
+	return user, nil
+}
+
+// newUserToken returns a random token for an invite or password reset link,
+// together with the hash that is stored in place of the token.
+func newUserToken() (token, tokenHash string, err error) {
+	b := make([]byte, 32)
+	_, err = rand.Read(b)
+	if err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashUserToken(token), nil
+}
+
+func hashUserToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+var (
+	dummyPasswordHashOnce sync.Once
+	dummyPasswordHash     []byte
+)
+
+// compareDummyPassword does the same amount of work as checking a real
+// password, so that logging in as a nonexistent user takes as long to reject
+// as a wrong password.
+func compareDummyPassword(password []byte) {
+	dummyPasswordHashOnce.Do(func() {
+		dummyPasswordHash, _ = cryptoutil.GenerateFromPassword([]byte("password"), cryptoutil.NewParams(nil))
+	})
+	_ = cryptoutil.CompareHashAndPassword(dummyPasswordHash, password)
+}
+
+func (pm *PageManager) userLogin(w http.ResponseWriter, r *http.Request) {
+	form := hyperforms.New(w, r)
+	loginID := form.Text("loginID", "")
+	loginID.Set("#loginID[required][autocomplete=username]", nil)
+	password := form.Input("password", "password", "")
+	password.Set("#password[required][autocomplete=current-password]", nil)
+	if r.Method == http.MethodPost {
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		user, err := pm.users.GetUserByLoginID(loginID.Value())
+		if err != nil {
+			pm.errHandler(w, r, err)
+			return
+		}
+		if user == nil || len(user.PasswordHash) == 0 {
+			compareDummyPassword([]byte(password.Value()))
+			user = nil
+		} else if cryptoutil.CompareHashAndPassword(user.PasswordHash, []byte(password.Value())) != nil {
+			user = nil
+		}
+		if user == nil || user.Status != UserStatusActive {
+			form.AddErrMsgs("invalid login ID or password")
+			form.Redirect(w, r, r.URL.Path)
+			return
+		}
+		err = pm.startSession(w, r, user.UserID)
+		if err != nil {
+			pm.errHandler(w, r, err)
+			return
+		}
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	form.SetAttribute("method", "post")
+	form.AppendElements(
+		formErrors(form.ErrMsgs),
+		hy.H("label[for=loginID]", nil, hy.Txt("Login ID")), loginID,
+		hy.H("label[for=password]", nil, hy.Txt("Password")), password,
+		hy.H("button[type=submit]", nil, hy.Txt("Log in")),
+	)
+	err := renderPage(w, "Log in", hy.H("h1", nil, hy.Txt("Log in")), form)
+	if err != nil {
+		pm.errHandler(w, r, err)
+		return
+	}
+}
+
+func (pm *PageManager) userLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	err := pm.endSession(w, r)
+	if err != nil {
+		pm.errHandler(w, r, err)
+		return
+	}
+	http.Redirect(w, r, loginURL, http.StatusSeeOther)
+}
+
+// acceptInvite lets a user with a valid invite or password reset link set
+// their password.
+func (pm *PageManager) acceptInvite(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	user, err := pm.users.GetUserByTokenHash(hashUserToken(token))
+	if err != nil {
+		pm.errHandler(w, r, err)
+		return
+	}
+	if token == "" || user == nil || user.Status == UserStatusDisabled || time.Now().After(user.TokenExpiresAt) {
+		w.WriteHeader(http.StatusNotFound)
+		err = renderPage(w, "Invalid link", hy.H("p", nil, hy.Txt("This link is invalid or has expired.")))
+		if err != nil {
+			pm.errHandler(w, r, err)
+		}
+		return
+	}
+	form := hyperforms.New(w, r)
+	password := form.Input("password", "password", "")
+	password.Set("#password[required][autocomplete=new-password]", nil)
+	confirmPassword := form.Input("password", "confirmPassword", "")
+	confirmPassword.Set("#confirmPassword[required][autocomplete=new-password]", nil)
+	if r.Method == http.MethodPost {
+		err = r.ParseForm()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		password.Validate(hyperforms.Required, hyperforms.LengthGe(8))
+		if password.Value() != confirmPassword.Value() {
+			form.AddErrMsgs("passwords do not match")
+		}
+		if len(form.ErrMsgs) > 0 || len(form.InputErrMsgs) > 0 {
+			form.Redirect(w, r, r.URL.String())
+			return
+		}
+		user.PasswordHash, err = cryptoutil.GenerateFromPassword([]byte(password.Value()), cryptoutil.NewParams(nil))
+		if err != nil {
+			pm.errHandler(w, r, err)
+			return
+		}
+		user.Status = UserStatusActive
+		user.TokenHash = ""
+		user.TokenExpiresAt = time.Time{}
+		err = pm.users.UpdateUser(*user)
+		if err != nil {
+			pm.errHandler(w, r, err)
+			return
+		}
+		err = pm.startSession(w, r, user.UserID)
+		if err != nil {
+			pm.errHandler(w, r, err)
+			return
+		}
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	form.SetAttribute("method", "post")
+	form.AppendElements(
+		formErrors(form.ErrMsgs),
+		hy.H("label[for=password]", nil, hy.Txt("Password")), formErrors(password.ErrMsgs()), password,
+		hy.H("label[for=confirmPassword]", nil, hy.Txt("Confirm password")), confirmPassword,
+		hy.H("button[type=submit]", nil, hy.Txt("Set password")),
+	)
+	err = renderPage(w, "Set password",
+		hy.H("h1", nil, hy.Txt("Set password")),
+		hy.H("p", nil, hy.Txt("Logging in as", user.LoginID)),
+		form,
+	)
+	if err != nil {
+		pm.errHandler(w, r, err)
+		return
+	}
+}
+
+func (pm *PageManager) superadminUsers(w http.ResponseWriter, r *http.Request) {
+	if !isSuperadmin(r) {
+		http.Redirect(w, r, superadminURLPrefix+"login", http.StatusSeeOther)
+		return
+	}
+	form := hyperforms.New(w, r)
+	loginID := form.Text("loginID", "")
+	loginID.Set("#loginID[required]", nil)
+	displayName := form.Text("displayName", "")
+	displayName.Set("#displayName", nil)
+	if r.Method == http.MethodPost {
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var token string
+		switch userID := r.FormValue("userID"); r.FormValue("action") {
+		case "":
+			loginID.Validate(hyperforms.Required, hyperforms.LengthLe(64))
+			displayName.Validate(hyperforms.LengthLe(256))
+			if len(form.InputErrMsgs) > 0 {
+				form.Redirect(w, r, r.URL.Path)
+				return
+			}
+			token, err = pm.InviteUser(loginID.Value(), displayName.Value())
+		case "disable":
+			err = pm.DisableUser(userID)
+		case "enable":
+			err = pm.EnableUser(userID)
+		case "reset":
+			token, err = pm.ResetUserPassword(userID)
+		case "delete":
+			err = pm.DeleteUser(userID)
+		case "permissions":
+			err = pm.SetUserPermissions(userID, Role(r.FormValue("role")), strings.Fields(r.FormValue("grants")))
+		default:
+			http.Error(w, "unknown action", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			form.AddErrMsgs(err.Error())
+			form.Redirect(w, r, r.URL.Path)
+			return
+		}
+		if token != "" {
+			scheme := "http"
+			if r.TLS != nil {
+				scheme = "https"
+			}
+			link := scheme + "://" + r.Host + inviteURL + "?token=" + token
+			err = hyperforms.SetCookieValue(w, flashCookieName, link, &http.Cookie{HttpOnly: true, SameSite: http.SameSiteLaxMode})
+			if err != nil {
+				pm.errHandler(w, r, err)
+				return
+			}
+		}
+		http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+		return
+	}
+	var link string
+	_ = hyperforms.GetCookieValue(w, r, flashCookieName, &link)
+	users, err := pm.users.ListUsers()
+	if err != nil {
+		pm.errHandler(w, r, err)
+		return
+	}
+	var rows hy.Elements
+	for _, user := range users {
+		actions := hy.Elements{hy.H("input[type=hidden][name=userID]", hy.Attr{"value": user.UserID})}
+		if user.Status == UserStatusDisabled {
+			actions.Append("button[type=submit][name=action][value=enable]", nil, hy.Txt("Enable"))
+		} else {
+			actions.Append("button[type=submit][name=action][value=disable]", nil, hy.Txt("Disable"))
+			actions.Append("button[type=submit][name=action][value=reset]", nil, hy.Txt("Reset password"))
+		}
+		actions.Append("button[type=submit][name=action][value=delete]", nil, hy.Txt("Delete"))
+		grants, err := pm.users.GetGrants(user.UserID)
+		if err != nil {
+			pm.errHandler(w, r, err)
+			return
+		}
+		var roles hy.Elements
+		for _, role := range []Role{RoleViewer, RoleEditor, RoleAdmin} {
+			selector := "option"
+			if role == user.Role {
+				selector = "option[selected]"
+			}
+			roles.Append(selector, hy.Attr{"value": string(role)}, hy.Txt(string(role)))
+		}
+		rows.Append("tr", nil,
+			hy.H("td", nil, hy.Txt(user.LoginID)),
+			hy.H("td", nil, hy.Txt(user.DisplayName)),
+			hy.H("td", nil, hy.Txt(user.Status.String())),
+			hy.H("td", nil, hy.H("form[method=post]", nil,
+				hy.H("input[type=hidden][name=userID]", hy.Attr{"value": user.UserID}),
+				hy.H("select[name=role]", nil, roles),
+				hy.H("input[type=text][name=grants]", hy.Attr{
+					"value":       strings.Join(grants, " "),
+					"placeholder": "pages or themes, e.g. /blog bokwoon95/plainsimple (editors only)",
+				}),
+				hy.H("button[type=submit][name=action][value=permissions]", nil, hy.Txt("Save")),
+			)),
+			hy.H("td", nil, hy.H("form[method=post]", nil, actions)),
+		)
+	}
+	var flash hy.Element
+	if link != "" {
+		flash = hy.H("p.flash", nil,
+			hy.Txt(fmt.Sprintf("Send this link to the user, it expires in %d hours:", int(userTokenTTL.Hours()))),
+			hy.H("a", hy.Attr{"href": link}, hy.Txt(link)),
+		)
+	}
+	form.SetAttribute("method", "post")
+	form.AppendElements(
+		formErrors(form.ErrMsgs),
+		hy.H("label[for=loginID]", nil, hy.Txt("Login ID")), formErrors(loginID.ErrMsgs()), loginID,
+		hy.H("label[for=displayName]", nil, hy.Txt("Display name")), formErrors(displayName.ErrMsgs()), displayName,
+		hy.H("button[type=submit]", nil, hy.Txt("Invite")),
+	)
+	err = renderPage(w, "Users",
+		hy.H("h1", nil, hy.Txt("Users")),
+		flash,
+		hy.H("table", nil,
+			hy.H("tr", nil, hy.H("th", nil, hy.Txt("Login ID")), hy.H("th", nil, hy.Txt("Display name")), hy.H("th", nil, hy.Txt("Status")), hy.H("th", nil, hy.Txt("Permissions")), hy.H("th", nil)),
+			rows,
+		),
+		hy.H("h2", nil, hy.Txt("Invite a user")),
+		form,
+	)
+	if err != nil {
+		pm.errHandler(w, r, err)
+		return
+	}
+}
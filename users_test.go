@@ -0,0 +1,132 @@
+package pagemanager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/bokwoon95/pagemanager/testutil"
+)
+
+func Test_users(t *testing.T) {
+	is := testutil.New(t)
+	pm, err := New(DataDB(newTestDB(t), "sqlite3"))
+	is.NoErr(err)
+	is.NoErr(pm.pwbox.SetPassword([]byte("password123")))
+	do := func(method, path string, form url.Values, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		if form != nil {
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		rr := httptest.NewRecorder()
+		pm.ServeHTTP(rr, r)
+		return rr
+	}
+	sessionCookie := func(rr *httptest.ResponseRecorder) *http.Cookie {
+		for _, c := range rr.Result().Cookies() {
+			if c.Name == sessionCookieName && c.MaxAge >= 0 {
+				return c
+			}
+		}
+		return nil
+	}
+	currentUser := func(cookie *http.Cookie) *User {
+		var user *User
+		r := httptest.NewRequest("GET", "/", nil)
+		r.AddCookie(cookie)
+		pm.LoadSession(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user = UserFromContext(r.Context())
+		})).ServeHTTP(httptest.NewRecorder(), r)
+		return user
+	}
+
+	token, err := pm.InviteUser("alice", "Alice")
+	is.NoErr(err)
+	_, err = pm.InviteUser("alice", "Alice again")
+	is.True(err != nil)
+	user, err := pm.users.GetUserByLoginID("alice")
+	is.NoErr(err)
+	is.Equal(UserStatusInvited, user.Status)
+	is.Equal(26, len(user.UserID))
+
+	// invited users cannot log in yet
+	rr := do("POST", "/pm-login", url.Values{"loginID": {"alice"}, "password": {""}})
+	is.True(sessionCookie(rr) == nil)
+
+	// accepting the invite sets the password and logs the user in
+	rr = do("GET", "/pm-invite?token=wrong", nil)
+	is.Equal(http.StatusNotFound, rr.Code)
+	rr = do("GET", "/pm-invite?token="+token, nil)
+	is.Equal(http.StatusOK, rr.Code)
+	rr = do("POST", "/pm-invite?token="+token, url.Values{"password": {"alicepassword"}, "confirmPassword": {"alicepassword"}})
+	is.Equal("/", rr.Header().Get("Location"))
+	cookie := sessionCookie(rr)
+	is.True(cookie != nil)
+	is.Equal(user.UserID, currentUser(cookie).UserID)
+	rr = do("GET", "/pm-invite?token="+token, nil)
+	is.Equal(http.StatusNotFound, rr.Code)
+
+	rr = do("POST", "/pm-login", url.Values{"loginID": {"alice"}, "password": {"wrong password"}})
+	is.True(sessionCookie(rr) == nil)
+	rr = do("POST", "/pm-login", url.Values{"loginID": {"bob"}, "password": {"alicepassword"}})
+	is.True(sessionCookie(rr) == nil)
+	rr = do("POST", "/pm-login", url.Values{"loginID": {"alice"}, "password": {"alicepassword"}})
+	is.Equal("/", rr.Header().Get("Location"))
+	cookie2 := sessionCookie(rr)
+	is.True(cookie2 != nil)
+
+	// disabling a user logs them out everywhere and stops them logging in
+	is.NoErr(pm.DisableUser(user.UserID))
+	is.True(currentUser(cookie) == nil)
+	is.True(currentUser(cookie2) == nil)
+	rr = do("POST", "/pm-login", url.Values{"loginID": {"alice"}, "password": {"alicepassword"}})
+	is.True(sessionCookie(rr) == nil)
+	_, err = pm.ResetUserPassword(user.UserID)
+	is.True(err != nil)
+	is.NoErr(pm.EnableUser(user.UserID))
+	rr = do("POST", "/pm-login", url.Values{"loginID": {"alice"}, "password": {"alicepassword"}})
+	cookie = sessionCookie(rr)
+	is.True(cookie != nil)
+
+	// resetting the password invalidates the old one
+	token, err = pm.ResetUserPassword(user.UserID)
+	is.NoErr(err)
+	is.True(currentUser(cookie) == nil)
+	rr = do("POST", "/pm-login", url.Values{"loginID": {"alice"}, "password": {"alicepassword"}})
+	is.True(sessionCookie(rr) == nil)
+	rr = do("POST", "/pm-invite?token="+token, url.Values{"password": {"newpassword"}, "confirmPassword": {"newpassword"}})
+	is.True(sessionCookie(rr) != nil)
+
+	// the superadmin manages users from the users page
+	rr = do("GET", "/pm-superadmin/users", nil)
+	is.Equal("/pm-superadmin/login", rr.Header().Get("Location"))
+	rr = httptest.NewRecorder()
+	is.NoErr(pm.startSession(rr, httptest.NewRequest("GET", "/", nil), superadminUserID))
+	superadmin := sessionCookie(rr)
+	rr = do("POST", "/pm-superadmin/users", url.Values{"loginID": {"bob"}, "displayName": {"Bob"}}, superadmin)
+	is.Equal(http.StatusSeeOther, rr.Code)
+	var flash *http.Cookie
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == flashCookieName {
+			flash = c
+		}
+	}
+	is.True(flash != nil)
+	rr = do("GET", "/pm-superadmin/users", nil, superadmin, flash)
+	is.Equal(http.StatusOK, rr.Code)
+	is.True(strings.Contains(rr.Body.String(), "/pm-invite?token="))
+	is.True(strings.Contains(rr.Body.String(), "Bob"))
+	bob, err := pm.users.GetUserByLoginID("bob")
+	is.NoErr(err)
+	rr = do("POST", "/pm-superadmin/users", url.Values{"action": {"delete"}, "userID": {bob.UserID}}, superadmin)
+	is.Equal(http.StatusSeeOther, rr.Code)
+	users, err := pm.users.ListUsers()
+	is.NoErr(err)
+	is.Equal(1, len(users))
+	is.Equal("alice", users[0].LoginID)
+}
@@ -0,0 +1,462 @@
+package pagemanager
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/bokwoon95/pagemanager/erro"
+	"github.com/bokwoon95/pagemanager/sq"
+	"github.com/bokwoon95/pagemanager/templatedir"
+)
+
+func getValue(dialect string, VALUES pm_VALUES, localeCode, namespace, name string) sq.Query {
+	predicates := []sq.Predicate{
+		VALUES.LOCALE_CODE.EqString(localeCode),
+		VALUES.NAMESPACE.EqString(namespace),
+		VALUES.NAME.EqString(name),
+	}
+	if dialect == "postgres" {
+		return sq.Postgres.From(VALUES).Where(predicates...)
+	}
+	return sq.SQLite.From(VALUES).Where(predicates...)
+}
+
+func getRows(dialect string, ROWS pm_ROWS, localeCode, namespace, name string) sq.Query {
+	predicates := []sq.Predicate{
+		ROWS.LOCALE_CODE.EqString(localeCode),
+		ROWS.NAMESPACE.EqString(namespace),
+		ROWS.NAME.EqString(name),
+	}
+	if dialect == "postgres" {
+		return sq.Postgres.From(ROWS).Where(predicates...).OrderBy(ROWS.ROW_NUM)
+	}
+	return sq.SQLite.From(ROWS).Where(predicates...).OrderBy(ROWS.ROW_NUM)
+}
+
+// getRowsPage is getRows limited to at most limit rows starting from offset.
+// A limit of 0 or less means no limit.
+func getRowsPage(dialect string, ROWS pm_ROWS, localeCode, namespace, name string, limit, offset int) sq.Query {
+	predicates := []sq.Predicate{
+		ROWS.LOCALE_CODE.EqString(localeCode),
+		ROWS.NAMESPACE.EqString(namespace),
+		ROWS.NAME.EqString(name),
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	if dialect == "postgres" {
+		q := sq.Postgres.From(ROWS).Where(predicates...).OrderBy(ROWS.ROW_NUM).Offset(offset)
+		if limit > 0 {
+			q = q.Limit(limit)
+		}
+		return q
+	}
+	// SQLite only allows an OFFSET after a LIMIT
+	if limit <= 0 {
+		limit = math.MaxInt32
+	}
+	return sq.SQLite.From(ROWS).Where(predicates...).OrderBy(ROWS.ROW_NUM).Limit(int64(limit)).Offset(int64(offset))
+}
+
+func countRows(dialect string, ROWS pm_ROWS, localeCode, namespace, name string) sq.Query {
+	predicates := []sq.Predicate{
+		ROWS.LOCALE_CODE.EqString(localeCode),
+		ROWS.NAMESPACE.EqString(namespace),
+		ROWS.NAME.EqString(name),
+	}
+	if dialect == "postgres" {
+		return sq.Postgres.From(ROWS).Where(predicates...)
+	}
+	return sq.SQLite.From(ROWS).Where(predicates...)
+}
+
+func addValue(dialect string, VALUES pm_VALUES, localeCode, namespace, name, value string) sq.Query {
+	mapper := func(col *sq.Column) error {
+		col.SetString(VALUES.LOCALE_CODE, localeCode)
+		col.SetString(VALUES.NAMESPACE, namespace)
+		col.SetString(VALUES.NAME, name)
+		col.SetString(VALUES.VALUE, value)
+		return nil
+	}
+	if dialect == "postgres" {
+		return sq.Postgres.InsertInto(VALUES).Valuesx(mapper)
+	}
+	return sq.SQLite.InsertInto(VALUES).Valuesx(mapper)
+}
+
+func addRows(dialect string, ROWS pm_ROWS, localeCode, namespace, name string, rows [][]byte) sq.Query {
+	mapper := func(col *sq.Column) error {
+		for i, row := range rows {
+			col.SetString(ROWS.LOCALE_CODE, localeCode)
+			col.SetString(ROWS.NAMESPACE, namespace)
+			col.SetString(ROWS.NAME, name)
+			col.SetInt(ROWS.ROW_NUM, i)
+			col.Set(ROWS.DATA, string(row))
+		}
+		return nil
+	}
+	if dialect == "postgres" {
+		return sq.Postgres.InsertInto(ROWS).Valuesx(mapper)
+	}
+	return sq.SQLite.InsertInto(ROWS).Valuesx(mapper)
+}
+
+func deleteValue(dialect string, VALUES pm_VALUES, localeCode, namespace, name string) sq.Query {
+	predicates := []sq.Predicate{
+		VALUES.LOCALE_CODE.EqString(localeCode),
+		VALUES.NAMESPACE.EqString(namespace),
+		VALUES.NAME.EqString(name),
+	}
+	if dialect == "postgres" {
+		return sq.Postgres.DeleteFrom(VALUES).Where(predicates...)
+	}
+	return sq.SQLite.DeleteFrom(VALUES).Where(predicates...)
+}
+
+func deleteRows(dialect string, ROWS pm_ROWS, localeCode, namespace, name string) sq.Query {
+	predicates := []sq.Predicate{
+		ROWS.LOCALE_CODE.EqString(localeCode),
+		ROWS.NAMESPACE.EqString(namespace),
+		ROWS.NAME.EqString(name),
+	}
+	if dialect == "postgres" {
+		return sq.Postgres.DeleteFrom(ROWS).Where(predicates...)
+	}
+	return sq.SQLite.DeleteFrom(ROWS).Where(predicates...)
+}
+
+func getDrafts(dialect string, DRAFTS pm_DRAFTS, predicates ...sq.Predicate) sq.Query {
+	if dialect == "postgres" {
+		return sq.Postgres.From(DRAFTS).Where(predicates...)
+	}
+	return sq.SQLite.From(DRAFTS).Where(predicates...)
+}
+
+func addDraft(dialect string, DRAFTS pm_DRAFTS, localeCode, namespace, name string, isRows bool, value string) sq.Query {
+	mapper := func(col *sq.Column) error {
+		col.SetString(DRAFTS.LOCALE_CODE, localeCode)
+		col.SetString(DRAFTS.NAMESPACE, namespace)
+		col.SetString(DRAFTS.NAME, name)
+		col.SetBool(DRAFTS.IS_ROWS, isRows)
+		col.SetString(DRAFTS.VALUE, value)
+		return nil
+	}
+	if dialect == "postgres" {
+		return sq.Postgres.InsertInto(DRAFTS).Valuesx(mapper)
+	}
+	return sq.SQLite.InsertInto(DRAFTS).Valuesx(mapper)
+}
+
+func deleteDrafts(dialect string, DRAFTS pm_DRAFTS, predicates ...sq.Predicate) sq.Query {
+	if dialect == "postgres" {
+		return sq.Postgres.DeleteFrom(DRAFTS).Where(predicates...)
+	}
+	return sq.SQLite.DeleteFrom(DRAFTS).Where(predicates...)
+}
+
+// draftKey matches the draft of the value (or rows, if isRows) called name.
+func draftKey(DRAFTS pm_DRAFTS, localeCode, namespace, name string, isRows bool) []sq.Predicate {
+	return []sq.Predicate{
+		DRAFTS.LOCALE_CODE.EqString(localeCode),
+		DRAFTS.NAMESPACE.EqString(namespace),
+		DRAFTS.NAME.EqString(name),
+		sq.Eq(DRAFTS.IS_ROWS, isRows),
+	}
+}
+
+func valuemapper(value *templatedir.NullString, VALUES pm_VALUES) func(*sq.Row) error {
+	return func(row *sq.Row) error {
+		s := row.NullString(VALUES.VALUE)
+		value.Valid = s.Valid
+		value.Str = s.String
+		return sq.SkipRows
+	}
+}
+
+func rowsmapper(rows *[]map[string]interface{}, ROWS pm_ROWS) func(*sq.Row) error {
+	return func(row *sq.Row) error {
+		b := row.Bytes(ROWS.DATA)
+		return row.Accumulate(func() error {
+			m := make(map[string]interface{})
+			if len(b) > 0 {
+				err := json.Unmarshal(b, &m)
+				if err != nil {
+					return err
+				}
+			}
+			*rows = append(*rows, m)
+			return nil
+		})
+	}
+}
+
+func queryValue(db sq.Queryer, dialect, localeCode, namespace, name string) (templatedir.NullString, error) {
+	var value templatedir.NullString
+	VALUES := new_VALUES("v")
+	_, err := sq.Fetch(db, getValue(dialect, VALUES, localeCode, namespace, name), valuemapper(&value, VALUES))
+	if err != nil {
+		return value, erro.Wrap(err)
+	}
+	return value, nil
+}
+
+func queryRows(db sq.Queryer, dialect, localeCode, namespace, name string) ([]map[string]interface{}, error) {
+	var rows []map[string]interface{}
+	ROWS := new_ROWS("r")
+	_, err := sq.Fetch(db, getRows(dialect, ROWS, localeCode, namespace, name), rowsmapper(&rows, ROWS))
+	if err != nil {
+		return nil, erro.Wrap(err)
+	}
+	return rows, nil
+}
+
+func queryDraft(db sq.Queryer, dialect, localeCode, namespace, name string, isRows bool) (templatedir.NullString, error) {
+	var value templatedir.NullString
+	DRAFTS := new_DRAFTS("d")
+	_, err := sq.Fetch(db, getDrafts(dialect, DRAFTS, draftKey(DRAFTS, localeCode, namespace, name, isRows)...), func(row *sq.Row) error {
+		s := row.NullString(DRAFTS.VALUE)
+		value.Valid = s.Valid
+		value.Str = s.String
+		return sq.SkipRows
+	})
+	if err != nil {
+		return value, erro.Wrap(err)
+	}
+	return value, nil
+}
+
+// valuestore keeps drafts in pm_drafts, with rows encoded as a single JSON
+// array so that a draft can have no rows at all.
+type valuestore struct {
+	db      *sql.DB
+	dialect string
+}
+
+// valuestoretx records a Revision for every value and rows that it changes,
+// attributed to userID.
+type valuestoretx struct {
+	tx      *sql.Tx
+	dialect string
+	userID  string
+}
+
+func (store valuestore) GetValue(localeCode, namespace, name string) (templatedir.NullString, error) {
+	return queryValue(store.db, store.dialect, localeCode, namespace, name)
+}
+
+func (store valuestore) GetRows(localeCode, namespace, name string) ([]map[string]interface{}, error) {
+	return queryRows(store.db, store.dialect, localeCode, namespace, name)
+}
+
+// GetRowsPage implements templatedir.RowsPager, so that getRowsPaged only
+// loads the rows that it shows.
+func (store valuestore) GetRowsPage(localeCode, namespace, name string, limit, offset int) (rows []map[string]interface{}, total int, err error) {
+	ROWS := new_ROWS("r")
+	_, err = sq.Fetch(store.db, countRows(store.dialect, ROWS, localeCode, namespace, name), func(row *sq.Row) error {
+		total = row.Int(sq.Count())
+		return sq.SkipRows
+	})
+	if err != nil {
+		return nil, 0, erro.Wrap(err)
+	}
+	if total == 0 || offset >= total {
+		return nil, total, nil
+	}
+	_, err = sq.Fetch(store.db, getRowsPage(store.dialect, ROWS, localeCode, namespace, name, limit, offset), rowsmapper(&rows, ROWS))
+	if err != nil {
+		return nil, 0, erro.Wrap(err)
+	}
+	return rows, total, nil
+}
+
+func (store valuestore) GetDraftValue(localeCode, namespace, name string) (templatedir.NullString, error) {
+	return queryDraft(store.db, store.dialect, localeCode, namespace, name, false)
+}
+
+func (store valuestore) GetDraftRows(localeCode, namespace, name string) (rows []map[string]interface{}, ok bool, err error) {
+	value, err := queryDraft(store.db, store.dialect, localeCode, namespace, name, true)
+	if err != nil || !value.Valid {
+		return nil, false, err
+	}
+	err = json.Unmarshal([]byte(value.Str), &rows)
+	if err != nil {
+		return nil, false, erro.Wrap(err)
+	}
+	return rows, true, nil
+}
+
+func (store valuestore) BeginTx(ctx context.Context) (templatedir.ValueStoreTx, error) {
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, erro.Wrap(err)
+	}
+	var userID string
+	if session := SessionFromContext(ctx); session != nil {
+		userID = session.UserID
+	}
+	return valuestoretx{tx: tx, dialect: store.dialect, userID: userID}, nil
+}
+
+func (tx valuestoretx) SetValue(localeCode, namespace, name string, value string) error {
+	oldValue, err := queryValue(tx.tx, tx.dialect, localeCode, namespace, name)
+	if err != nil {
+		return err
+	}
+	if oldValue.Valid && oldValue.Str == value {
+		return nil
+	}
+	VALUES := new_VALUES("v")
+	_, _, err = sq.Exec(tx.tx, deleteValue(tx.dialect, VALUES, localeCode, namespace, name), 0)
+	if err != nil {
+		return erro.Wrap(err)
+	}
+	_, _, err = sq.Exec(tx.tx, addValue(tx.dialect, VALUES, localeCode, namespace, name, value), 0)
+	if err != nil {
+		return erro.Wrap(err)
+	}
+	return tx.addRevision(Revision{
+		LocaleCode: localeCode,
+		Namespace:  namespace,
+		Name:       name,
+		OldValue:   oldValue,
+		NewValue:   value,
+	})
+}
+
+func (tx valuestoretx) SetRows(localeCode, namespace, name string, rows []map[string]interface{}) error {
+	oldRows, err := queryRows(tx.tx, tx.dialect, localeCode, namespace, name)
+	if err != nil {
+		return err
+	}
+	var oldValue templatedir.NullString
+	if len(oldRows) > 0 {
+		oldValue.Str, err = encodeRows(oldRows)
+		if err != nil {
+			return err
+		}
+		oldValue.Valid = true
+	}
+	newValue, err := encodeRows(rows)
+	if err != nil {
+		return err
+	}
+	if oldValue.Str == newValue || (!oldValue.Valid && len(rows) == 0) {
+		return nil
+	}
+	ROWS := new_ROWS("r")
+	_, _, err = sq.Exec(tx.tx, deleteRows(tx.dialect, ROWS, localeCode, namespace, name), 0)
+	if err != nil {
+		return erro.Wrap(err)
+	}
+	if len(rows) > 0 {
+		data := make([][]byte, len(rows))
+		for i, row := range rows {
+			data[i], err = json.Marshal(row)
+			if err != nil {
+				return erro.Wrap(err)
+			}
+		}
+		_, _, err = sq.Exec(tx.tx, addRows(tx.dialect, ROWS, localeCode, namespace, name, data), 0)
+		if err != nil {
+			return erro.Wrap(err)
+		}
+	}
+	return tx.addRevision(Revision{
+		LocaleCode: localeCode,
+		Namespace:  namespace,
+		Name:       name,
+		IsRows:     true,
+		OldValue:   oldValue,
+		NewValue:   newValue,
+	})
+}
+
+func (tx valuestoretx) setDraft(localeCode, namespace, name string, isRows bool, value string) error {
+	DRAFTS := new_DRAFTS("d")
+	_, _, err := sq.Exec(tx.tx, deleteDrafts(tx.dialect, DRAFTS, draftKey(DRAFTS, localeCode, namespace, name, isRows)...), 0)
+	if err != nil {
+		return erro.Wrap(err)
+	}
+	_, _, err = sq.Exec(tx.tx, addDraft(tx.dialect, DRAFTS, localeCode, namespace, name, isRows, value), 0)
+	return erro.Wrap(err)
+}
+
+func (tx valuestoretx) SetDraftValue(localeCode, namespace, name string, value string) error {
+	return tx.setDraft(localeCode, namespace, name, false, value)
+}
+
+func (tx valuestoretx) SetDraftRows(localeCode, namespace, name string, rows []map[string]interface{}) error {
+	value, err := encodeRows(rows)
+	if err != nil {
+		return err
+	}
+	return tx.setDraft(localeCode, namespace, name, true, value)
+}
+
+// publishDraft writes the draft of the value (or rows, if isRows) called
+// name with SetValue or SetRows, so that publishing is recorded in the
+// revisions like any other write.
+func (tx valuestoretx) publishDraft(localeCode, namespace, name string, isRows bool) error {
+	var value string
+	DRAFTS := new_DRAFTS("d")
+	rowCount, err := sq.Fetch(tx.tx, getDrafts(tx.dialect, DRAFTS, draftKey(DRAFTS, localeCode, namespace, name, isRows)...), func(row *sq.Row) error {
+		value = row.String(DRAFTS.VALUE)
+		return sq.SkipRows
+	})
+	if err != nil {
+		return erro.Wrap(err)
+	}
+	if rowCount == 0 {
+		return nil
+	}
+	if !isRows {
+		err = tx.SetValue(localeCode, namespace, name, value)
+	} else {
+		var rows []map[string]interface{}
+		err = json.Unmarshal([]byte(value), &rows)
+		if err != nil {
+			return erro.Wrap(err)
+		}
+		err = tx.SetRows(localeCode, namespace, name, rows)
+	}
+	if err != nil {
+		return err
+	}
+	_, _, err = sq.Exec(tx.tx, deleteDrafts(tx.dialect, DRAFTS, draftKey(DRAFTS, localeCode, namespace, name, isRows)...), 0)
+	return erro.Wrap(err)
+}
+
+func (tx valuestoretx) PublishDraftValue(localeCode, namespace, name string) error {
+	return tx.publishDraft(localeCode, namespace, name, false)
+}
+
+func (tx valuestoretx) PublishDraftRows(localeCode, namespace, name string) error {
+	return tx.publishDraft(localeCode, namespace, name, true)
+}
+
+func (tx valuestoretx) addRevision(revision Revision) error {
+	revision.UserID = tx.userID
+	revision.CreatedAt = time.Now()
+	REVISIONS := new_REVISIONS("r")
+	_, _, err := sq.Exec(tx.tx, addRevision(tx.dialect, REVISIONS, revision), 0)
+	return erro.Wrap(err)
+}
+
+// encodeRows encodes rows as the JSON array stored in a Revision.
+func encodeRows(rows []map[string]interface{}) (string, error) {
+	if rows == nil {
+		rows = []map[string]interface{}{}
+	}
+	b, err := json.Marshal(rows)
+	if err != nil {
+		return "", erro.Wrap(err)
+	}
+	return string(b), nil
+}
+
+func (tx valuestoretx) Commit() error { return tx.tx.Commit() }
+
+func (tx valuestoretx) Rollback() error { return tx.tx.Rollback() }
@@ -0,0 +1,237 @@
+package pagemanager
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bokwoon95/pagemanager/cryptoutil"
+	"github.com/bokwoon95/pagemanager/sq"
+	"github.com/bokwoon95/pagemanager/templatedir"
+	"github.com/bokwoon95/pagemanager/testutil"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func newTestDB(t *testing.T) *sql.DB {
+	is := testutil.New(t)
+	db, err := sql.Open("sqlite3", ":memory:")
+	is.NoErr(err)
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// runDialects runs test once for every supported dialect. Postgres is only
+// tested if PM_TEST_POSTGRES_URL is set.
+func runDialects(t *testing.T, test func(t *testing.T, dialect string)) {
+	for _, dialect := range []string{"sqlite3", "postgres"} {
+		dialect := dialect
+		t.Run(dialect, func(t *testing.T) { test(t, dialect) })
+	}
+}
+
+// newDialectDB returns an empty database of the dialect. Postgres databases
+// live in a fresh schema of the database in PM_TEST_POSTGRES_URL (a
+// postgres:// URL) that is dropped when the test ends.
+func newDialectDB(t *testing.T, dialect string) *sql.DB {
+	if dialect != "postgres" {
+		return newTestDB(t)
+	}
+	is := testutil.New(t)
+	dsn := os.Getenv("PM_TEST_POSTGRES_URL")
+	if dsn == "" {
+		t.Skip("PM_TEST_POSTGRES_URL not set")
+	}
+	admin, err := sql.Open("postgres", dsn)
+	is.NoErr(err)
+	schema := fmt.Sprintf("pm_test_%d", time.Now().UnixNano())
+	_, err = admin.Exec("CREATE SCHEMA " + schema)
+	is.NoErr(err)
+	u, err := url.Parse(dsn)
+	is.NoErr(err)
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+	db, err := sql.Open("postgres", u.String())
+	is.NoErr(err)
+	t.Cleanup(func() {
+		db.Close()
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		admin.Close()
+	})
+	return db
+}
+
+func Test_valuestore(t *testing.T) {
+	runDialects(t, func(t *testing.T, dialect string) {
+		is := testutil.New(t)
+		db := newDialectDB(t, dialect)
+		err := sq.EnsureTables(db, dialect, new_VALUES(""), new_ROWS(""), new_REVISIONS(""))
+		is.NoErr(err)
+		store := valuestore{db: db, dialect: dialect}
+
+		value, err := store.GetValue("en", "/", "title")
+		is.NoErr(err)
+		is.Equal(templatedir.NullString{}, value)
+
+		tx, err := store.BeginTx(context.Background())
+		is.NoErr(err)
+		is.NoErr(tx.SetValue("en", "/", "title", "first"))
+		is.NoErr(tx.SetValue("en", "/", "title", "second"))
+		is.NoErr(tx.SetValue("fr", "/", "title", "deuxième"))
+		is.NoErr(tx.SetRows("en", "/", "posts", []map[string]interface{}{
+			{"title": "a", "link": "/a"},
+			{"title": "b", "link": "/b"},
+			{"title": "c", "link": "/c"},
+		}))
+		is.NoErr(tx.Commit())
+
+		value, err = store.GetValue("en", "/", "title")
+		is.NoErr(err)
+		is.Equal(templatedir.NullString{Valid: true, Str: "second"}, value)
+		value, err = store.GetValue("fr", "/", "title")
+		is.NoErr(err)
+		is.Equal(templatedir.NullString{Valid: true, Str: "deuxième"}, value)
+		rows, err := store.GetRows("en", "/", "posts")
+		is.NoErr(err)
+		is.Equal([]map[string]interface{}{
+			{"title": "a", "link": "/a"},
+			{"title": "b", "link": "/b"},
+			{"title": "c", "link": "/c"},
+		}, rows)
+
+		tx, err = store.BeginTx(context.Background())
+		is.NoErr(err)
+		is.NoErr(tx.SetValue("en", "/", "title", "third"))
+		is.NoErr(tx.SetRows("en", "/", "posts", nil))
+		is.NoErr(tx.Rollback())
+		value, err = store.GetValue("en", "/", "title")
+		is.NoErr(err)
+		is.Equal("second", value.Str)
+		rows, err = store.GetRows("en", "/", "posts")
+		is.NoErr(err)
+		is.Equal(3, len(rows))
+	})
+}
+
+func Test_storeQueryDialects(t *testing.T) {
+	VALUES, ROWS, DRAFTS, REVISIONS := new_VALUES(""), new_ROWS(""), new_DRAFTS(""), new_REVISIONS("")
+	PAGES, SESSIONS, USERS, GRANTS := new_PAGES(""), new_SESSIONS(""), new_USERS(""), new_GRANTS("")
+	LOCALES, SUPERADMIN, KEYS := new_LOCALES(""), new_SUPERADMIN(""), new_KEYS("")
+	for _, dialect := range []string{"sqlite3", "postgres"} {
+		queries := []sq.Query{
+			getValue(dialect, VALUES, "en", "/", "title"),
+			getRows(dialect, ROWS, "en", "/", "posts"),
+			getRowsPage(dialect, ROWS, "en", "/", "posts", 10, 20),
+			countRows(dialect, ROWS, "en", "/", "posts"),
+			addValue(dialect, VALUES, "en", "/", "title", "hello"),
+			addRows(dialect, ROWS, "en", "/", "posts", [][]byte{[]byte(`{}`)}),
+			deleteValue(dialect, VALUES, "en", "/", "title"),
+			deleteRows(dialect, ROWS, "en", "/", "posts"),
+			getDrafts(dialect, DRAFTS),
+			addDraft(dialect, DRAFTS, "en", "/", "title", false, "hello"),
+			deleteDrafts(dialect, DRAFTS),
+			listValues(dialect, VALUES),
+			listRows(dialect, ROWS),
+			getRevisions(dialect, REVISIONS),
+			addRevision(dialect, REVISIONS, Revision{}),
+			getPages(dialect, PAGES),
+			addPage(dialect, PAGES, Page{}),
+			deletePage(dialect, PAGES, "/"),
+			getSession(dialect, SESSIONS, "s"),
+			addSession(dialect, SESSIONS, Session{}),
+			touchSession(dialect, SESSIONS, "s", time.Now()),
+			deleteSession(dialect, SESSIONS, "s"),
+			deleteUserSessions(dialect, SESSIONS, "u"),
+			getUsers(dialect, USERS),
+			addUser(dialect, USERS, User{}),
+			updateUser(dialect, USERS, User{}),
+			deleteUser(dialect, USERS, "u"),
+			getGrants(dialect, GRANTS, "u"),
+			addGrant(dialect, GRANTS, "u", "/"),
+			deleteGrants(dialect, GRANTS, "u"),
+			getLocales(dialect, LOCALES),
+			addLocale(dialect, LOCALES, Locale{}),
+			clearDefaultLocale(dialect, LOCALES),
+			deleteLocale(dialect, LOCALES, "en"),
+			getSuperadmin(dialect, SUPERADMIN),
+			addSuperadmin(dialect, SUPERADMIN, cryptoutil.PasswordMetadata{}),
+			setPasswordMetadata(dialect, SUPERADMIN, cryptoutil.PasswordMetadata{}),
+			setLoginID(dialect, SUPERADMIN, "admin"),
+			getKeyByID(dialect, KEYS, "k"),
+			getKeysByStatus(dialect, KEYS, cryptoutil.KeyStatusActive, 1),
+			setKeysByStatus(dialect, KEYS, cryptoutil.KeyStatusActive, "k"),
+			addKeys(dialect, KEYS, []cryptoutil.Key{{ID: "k"}}),
+			deleteKeys(dialect, KEYS, "k"),
+		}
+		for i, q := range queries {
+			if q.Dialect() != dialect {
+				t.Errorf("%s query %d: got dialect %q", dialect, i, q.Dialect())
+			}
+			if _, _, _, err := q.ToSQL(); err != nil {
+				t.Errorf("%s query %d: %v", dialect, i, err)
+			}
+		}
+	}
+}
+
+func Test_valuestoreUnique(t *testing.T) {
+	runDialects(t, func(t *testing.T, dialect string) {
+		is := testutil.New(t)
+		db := newDialectDB(t, dialect)
+		_, err := New(DataDB(db, dialect), ThemesDir(t.TempDir()))
+		is.NoErr(err)
+		_, err = db.Exec("INSERT INTO pm_values (locale_code, namespace, name) VALUES ('en', '/', 'title')")
+		is.NoErr(err)
+		_, err = db.Exec("INSERT INTO pm_values (locale_code, namespace, name) VALUES ('en', '/', 'title')")
+		is.True(err != nil)
+		_, err = db.Exec("INSERT INTO pm_rows (locale_code, namespace, name, row_num) VALUES ('en', '/', 'posts', 1)")
+		is.NoErr(err)
+		_, err = db.Exec("INSERT INTO pm_rows (locale_code, namespace, name, row_num) VALUES ('en', '/', 'posts', 1)")
+		is.True(err != nil)
+	})
+}
+
+func Test_valuestoreRowsPage(t *testing.T) {
+	runDialects(t, func(t *testing.T, dialect string) {
+		is := testutil.New(t)
+		db := newDialectDB(t, dialect)
+		is.NoErr(sq.EnsureTables(db, dialect, new_VALUES(""), new_ROWS(""), new_REVISIONS("")))
+		var store templatedir.ValueStore = valuestore{db: db, dialect: dialect}
+		pager, ok := store.(templatedir.RowsPager)
+		is.True(ok)
+		var posts []map[string]interface{}
+		for i := 0; i < 5; i++ {
+			posts = append(posts, map[string]interface{}{"title": fmt.Sprint(i)})
+		}
+		tx, err := store.BeginTx(context.Background())
+		is.NoErr(err)
+		is.NoErr(tx.SetRows("en", "/", "posts", posts))
+		is.NoErr(tx.Commit())
+
+		for _, tt := range []struct {
+			limit, offset int
+			want          []map[string]interface{}
+		}{
+			{2, 0, posts[:2]},
+			{2, 3, posts[3:]},
+			{0, 1, posts[1:]},
+			{-1, -1, posts},
+			{2, 5, nil},
+		} {
+			rows, total, err := pager.GetRowsPage("en", "/", "posts", tt.limit, tt.offset)
+			is.NoErr(err)
+			is.Equal(5, total)
+			is.Equal(tt.want, rows)
+		}
+		rows, total, err := pager.GetRowsPage("fr", "/", "posts", 2, 0)
+		is.NoErr(err)
+		is.Equal(0, total)
+		is.Equal(0, len(rows))
+	})
+}